@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Rule Grants an access, "none", "read", "write" or "admin", on the keys matching a path glob.
+type Rule struct {
+	Path   string `json:"path"`
+	Access string `json:"access"`
+}
+
+// Role A named set of rules.
+type Role struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Roles Lists the roles. Admin only.
+func (c *Client) Roles(ctx context.Context) ([]Role, error) {
+	var out struct {
+		Roles []Role `json:"roles"`
+	}
+	err := c.do(ctx, http.MethodGet, "/acl/roles", nil, &out)
+	return out.Roles, err
+}
+
+// Role Gets a role. Admin only.
+func (c *Client) Role(ctx context.Context, name string) (*Role, error) {
+	var r Role
+	err := c.do(ctx, http.MethodGet, "/acl/roles/"+escape(name), nil, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// PutRole Creates or replaces a role. Admin only.
+func (c *Client) PutRole(ctx context.Context, role Role) error {
+	return c.do(ctx, http.MethodPut, "/acl/roles/"+escape(role.Name), map[string][]Rule{"rules": role.Rules}, nil)
+}
+
+// DeleteRole Deletes a role, and removes it from the users. Admin only.
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/acl/roles/"+escape(name), nil, nil)
+}
+
+// UserRoles Gets the role names of a user. Admin only.
+func (c *Client) UserRoles(ctx context.Context, name string) ([]string, error) {
+	var out struct {
+		Roles []string `json:"roles"`
+	}
+	err := c.do(ctx, http.MethodGet, "/acl/users/"+escape(name), nil, &out)
+	return out.Roles, err
+}
+
+// SetUserRoles Sets the roles of a user. Admin only.
+func (c *Client) SetUserRoles(ctx context.Context, name string, roles []string) error {
+	return c.do(ctx, http.MethodPut, "/acl/users/"+escape(name), map[string][]string{"roles": roles}, nil)
+}
+
+// Audit Gets the audit log entries matching q, oldest first. Admin only.
+func (c *Client) Audit(ctx context.Context, q cfghive.AuditQuery) ([]cfghive.AuditEntry, error) {
+	params := url.Values{}
+	for k, v := range map[string]string{"user": q.User, "tenant": q.Tenant, "environment": q.Environment, "key": q.Key, "op": q.Op} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.After > 0 {
+		params.Set("after", strconv.FormatUint(q.After, 10))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/audit"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	var out struct {
+		Entries []cfghive.AuditEntry `json:"entries"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out.Entries, err
+}
+
+// VerifyAudit Checks the hash chain of the audit log, and gets the number of entries checked.
+// Returns the error found in the log, if any. Admin only.
+func (c *Client) VerifyAudit(ctx context.Context) (int, error) {
+	var out struct {
+		Valid   bool   `json:"valid"`
+		Entries int    `json:"entries"`
+		Error   string `json:"error"`
+	}
+	err := c.do(ctx, http.MethodGet, "/audit/verify", nil, &out)
+	if err != nil {
+		return 0, err
+	}
+	if !out.Valid {
+		return out.Entries, fmt.Errorf("%w: %s", cfghive.ErrAuditTampered, out.Error)
+	}
+	return out.Entries, nil
+}
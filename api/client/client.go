@@ -0,0 +1,202 @@
+// Package client A Go client of the api server, see handlers.OpenAPIDocument for the routes.
+//
+// Every method maps to one route. Error responses are returned as *Error, and the errors
+// of the hive routes also match the cfghive errors with errors.Is, as for local hives.
+// The /watch stream is not covered.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Client Calls an api server. It is safe for concurrent use once configured.
+type Client struct {
+	// The server URL, e.g. "http://localhost:8080".
+	BaseURL string
+	// The HTTP client, http.DefaultClient if nil. Its transport holds the TLS client certificate, if any.
+	HTTPClient *http.Client
+	// Credentials sent with every request: an access token or an api key as a bearer token,
+	// or else a user name and password if Username is set.
+	Token    string
+	Username string
+	Password string
+}
+
+// New Creates a client of the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Error An error response of the server.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter How long to wait before retrying, for rate limited requests.
+	RetryAfter time.Duration
+	// The hive error matching the status, for the hive routes.
+	err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Tokens The tokens issued by Login and Refresh.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn The seconds the access token is valid.
+	ExpiresIn int `json:"expires_in"`
+}
+
+// requestIDKey The context key of the request id, see WithRequestID.
+type requestIDKey struct{}
+
+// WithRequestID Gets a context sending id as the X-Request-ID header of the requests made with it,
+// so they can be followed through the server logs and audit log.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// newRequest Creates a request with the credentials, and in encoded as JSON if not nil.
+func (c *Client) newRequest(ctx context.Context, method string, path string, in interface{}) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		req.Header.Set("X-Request-ID", id)
+	}
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return req, nil
+}
+
+// send Sends a request, and decodes the response body into out if not nil.
+func (c *Client) send(req *http.Request, out interface{}) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return resp, responseError(resp)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		err = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp, err
+}
+
+// do Sends a request with in as body, and decodes the response body into out.
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(req, out)
+	return err
+}
+
+// responseError Reads the error of a failed response.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil || body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	e := &Error{StatusCode: resp.StatusCode, Message: body.Error}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+// Ping Gets the server time.
+func (c *Client) Ping(ctx context.Context) (time.Time, error) {
+	var out struct {
+		Time time.Time `json:"time"`
+	}
+	err := c.do(ctx, http.MethodGet, "/ping", nil, &out)
+	return out.Time, err
+}
+
+// Ready Returns an error if the server is not ready, or one of its hives cannot be reached.
+func (c *Client) Ready(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/readyz", nil, nil)
+}
+
+// Login Gets tokens for a user name and password. Set Token to the access token to use them.
+func (c *Client) Login(ctx context.Context, name string, password string) (*Tokens, error) {
+	var t Tokens
+	err := c.do(ctx, http.MethodPost, "/auth/login", map[string]string{"name": name, "password": password}, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Refresh Gets new tokens for a refresh token.
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	var t Tokens
+	err := c.do(ctx, http.MethodPost, "/auth/refresh", map[string]string{"refresh_token": refreshToken}, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Hive Gets the hive of the server as a cfghive.RemoteHive, with the credentials of the client.
+func (c *Client) Hive() *cfghive.RemoteHive {
+	h := cfghive.NewRemoteHive(c.BaseURL)
+	h.Client = c.HTTPClient
+	if c.Token != "" {
+		h.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		h.Username = c.Username
+		h.Password = c.Password
+	}
+	return h
+}
+
+// escape Escapes a path element.
+func escape(s string) string {
+	return url.PathEscape(s)
+}
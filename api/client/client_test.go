@@ -0,0 +1,349 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/client"
+	"github.com/melanblack/potential-framework/handlers"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newServer Starts a server with the api routes, and an admin user "admin".
+func newServer(t *testing.T, limits handlers.Limits) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	mem := func() cfghive.Hive {
+		h, _ := cfghive.NewMemHive()
+		return h
+	}
+	users := handlers.NewUserStore(mem())
+	users.Cost = bcrypt.MinCost
+	if _, err := users.Create("admin", "admin-password", true); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := cfghive.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	auth := handlers.NewAuth(users, []byte("0123456789abcdef0123456789abcdef"))
+	limiter := handlers.NewLimiter(limits)
+
+	engine := gin.New()
+	engine.Use(handlers.RequestID())
+	engine.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"response": "pong", "time": time.Now().UTC()})
+	})
+	limited := engine.Group("/", limiter.IPMiddleware())
+	auth.RegisterPublic(limited)
+	authorized := limited.Group("/", auth.Middleware(), limiter.UserMiddleware())
+	handlers.NewValueHandler(mem()).Register(authorized)
+	acl := handlers.NewACL(users)
+	hiveHandler := handlers.NewHiveHandler(mem())
+	hiveHandler.Audit = audit
+	hiveHandler.Register(authorized.Group("/", acl.Middleware()))
+	acl.Register(authorized)
+	handlers.NewAuditHandler(audit).Register(authorized)
+	handlers.NewUserHandler(users).Register(authorized)
+	auth.Register(authorized)
+	tenants := handlers.NewTenants(users, []byte("0123456789abcdef0123456789abcdef"), func(string, bool) (cfghive.Hive, error) {
+		return mem(), nil
+	})
+	tenants.Audit = audit
+	tenants.RegisterServe(limited)
+	tenants.Register(authorized)
+	t.Cleanup(func() { tenants.Close() })
+	environments := handlers.NewEnvironments(users)
+	environments.Audit = audit
+	for _, name := range []string{"staging", "prod"} {
+		if err := environments.Add(name, mem(), name == "prod"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	environments.Register(authorized, acl)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	srv := newServer(t, handlers.Limits{})
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Authentication
+	_, err := c.Users(ctx)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tokens, err := c.Login(ctx, "admin", "admin-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err = c.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Token = tokens.AccessToken
+
+	// Users
+	if _, err := c.CreateUser(ctx, "bob", "bob-password", false); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := c.SetAdmin(ctx, "bob", true); err != nil || !u.Admin {
+		t.Fatalf("%v, %v", u, err)
+	}
+	if err := c.SetPassword(ctx, "bob", "", "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	bob := client.New(srv.URL)
+	bob.Username, bob.Password = "bob", "new-password"
+	if u, err := bob.User(ctx, "bob"); err != nil || u.Name != "bob" {
+		t.Fatalf("%v, %v", u, err)
+	}
+	if users, err := c.Users(ctx); err != nil || len(users) != 2 {
+		t.Fatalf("%v, %v", users, err)
+	}
+
+	// Api keys
+	k, secret, err := bob.CreateAPIKey(ctx, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := client.New(srv.URL)
+	ci.Token = secret
+	if keys, err := ci.APIKeys(ctx); err != nil || len(keys) != 1 || keys[0].ID != k.ID {
+		t.Fatalf("%v, %v", keys, err)
+	}
+	if err := ci.DeleteAPIKey(ctx, k.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hive
+	if err := c.NewSub(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(client.WithRequestID(ctx, "deploy-1"), "app/port", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "app/port"); err != nil || v.Value() != 8080 {
+		t.Fatalf("%v, %v", v, err)
+	}
+	if children, err := c.Children(ctx, "app"); err != nil || !reflect.DeepEqual(children, []client.HiveChild{{Name: "port", Type: "int"}}) {
+		t.Fatalf("%v, %v", children, err)
+	}
+	if _, err := c.Get(ctx, "app/host"); !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := c.Set(ctx, "app/port/x", 1); !errors.Is(err, cfghive.ErrNotSubHive) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n, err := c.Import(ctx, map[string]interface{}{"db": map[string]interface{}{"host": "localhost"}}); err != nil || n != 1 {
+		t.Fatalf("%d, %v", n, err)
+	}
+	data, err := c.Export(ctx)
+	if err != nil || !reflect.DeepEqual(data["db"], map[string]interface{}{"host": "localhost"}) {
+		t.Fatalf("%v, %v", data, err)
+	}
+	if err := c.Delete(ctx, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Hive().GetInt("app/port"); err != nil || v != 8080 {
+		t.Fatalf("%v, %v", v, err)
+	}
+
+	// Access control
+	if _, err := c.CreateUser(ctx, "carol", "carol-password", false); err != nil {
+		t.Fatal(err)
+	}
+	err = c.PutRole(ctx, client.Role{Name: "app-reader", Rules: []client.Rule{{Path: "app/**", Access: "read"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetUserRoles(ctx, "carol", []string{"app-reader"}); err != nil {
+		t.Fatal(err)
+	}
+	if roles, err := c.UserRoles(ctx, "carol"); err != nil || !reflect.DeepEqual(roles, []string{"app-reader"}) {
+		t.Fatalf("%v, %v", roles, err)
+	}
+	carol := client.New(srv.URL)
+	carol.Username, carol.Password = "carol", "carol-password"
+	if _, err := carol.Get(ctx, "app/port"); err != nil {
+		t.Fatal(err)
+	}
+	if err := carol.Set(ctx, "app/port", 1); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if roles, err := c.Roles(ctx); err != nil || len(roles) != 1 {
+		t.Fatalf("%v, %v", roles, err)
+	}
+	if err := c.DeleteRole(ctx, "app-reader"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values
+	if err := carol.SetValue(ctx, "carol-value"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Value(ctx, "carol"); err != nil || !ok || v != "carol-value" {
+		t.Fatalf("%q, %v, %v", v, ok, err)
+	}
+	if _, ok, err := c.Value(ctx, "bob"); err != nil || ok {
+		t.Fatalf("%v, %v", ok, err)
+	}
+
+	// Audit
+	entries, err := c.Audit(ctx, cfghive.AuditQuery{Key: "app", User: "admin"})
+	if err != nil || len(entries) != 2 || entries[1].RequestID != "deploy-1" {
+		t.Fatalf("%v, %v", entries, err)
+	}
+	if n, err := c.VerifyAudit(ctx); err != nil || n != 4 {
+		t.Fatalf("%d, %v", n, err)
+	}
+
+	if err := c.DeleteUser(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientTenants(t *testing.T) {
+	srv := newServer(t, handlers.Limits{})
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	c.Username, c.Password = "admin", "admin-password"
+
+	tenant, password, err := c.CreateTenant(ctx, "acme", "", client.TenantQuota{MaxKeys: 1})
+	if err != nil || tenant.Name != "acme" || password == "" {
+		t.Fatalf("%v, %q, %v", tenant, password, err)
+	}
+	acme := c.Tenant("acme")
+	tokens, err := acme.Login(ctx, "admin", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.Token = tokens.AccessToken
+	if err := acme.Set(ctx, "port", 8080); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.Error
+	if err := acme.Set(ctx, "host", "db"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if v, err := acme.Hive().GetInt("port"); err != nil || v != 8080 {
+		t.Fatalf("%v, %v", v, err)
+	}
+	if _, err := c.Get(ctx, "port"); !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tenant, err = c.SetTenantQuota(ctx, "acme", client.TenantQuota{MaxKeys: 2})
+	if err != nil || tenant.Quota.MaxKeys != 2 || tenant.Keys != 1 {
+		t.Fatalf("%v, %v", tenant, err)
+	}
+	if err := acme.Set(ctx, "host", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if tenants, err := c.Tenants(ctx); err != nil || len(tenants) != 1 || tenants[0].Keys != 2 {
+		t.Fatalf("%v, %v", tenants, err)
+	}
+	entries, err := c.Audit(ctx, cfghive.AuditQuery{Tenant: "acme"})
+	if err != nil || len(entries) != 2 || entries[0].Tenant != "acme" {
+		t.Fatalf("%v, %v", entries, err)
+	}
+	if err := c.DeleteTenant(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTenant(ctx, "acme"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestClientEnvironments(t *testing.T) {
+	srv := newServer(t, handlers.Limits{})
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	c.Username, c.Password = "admin", "admin-password"
+	if _, err := c.CreateUser(ctx, "bob", "bob-password", true); err != nil {
+		t.Fatal(err)
+	}
+	bob := client.New(srv.URL)
+	bob.Username, bob.Password = "bob", "bob-password"
+
+	if envs, err := c.Environments(ctx); err != nil || len(envs) != 2 || envs[0].Name != "prod" || !envs[0].Protected {
+		t.Fatalf("%v, %v", envs, err)
+	}
+	staging := c.Environment("staging")
+	if _, err := staging.Import(ctx, map[string]interface{}{"app": map[string]interface{}{"port": 8080, "debug": true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Environment("prod").Set(ctx, "other", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := c.EnvironmentDiff(ctx, "staging", "prod", "app")
+	if err != nil || len(changes) != 1 || changes[0].Key != "app" || changes[0].Old != nil {
+		t.Fatalf("%v, %v", changes, err)
+	}
+
+	p, err := c.RequestPromotion(ctx, "staging", "prod", "app")
+	if err != nil || p.Status != "pending" {
+		t.Fatalf("%v, %v", p, err)
+	}
+	var apiErr *client.Error
+	if _, err := c.ApprovePromotion(ctx, p.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p, err = bob.ApprovePromotion(ctx, p.ID)
+	if err != nil || p.Status != "applied" || p.ReviewedBy != "bob" {
+		t.Fatalf("%v, %v", p, err)
+	}
+	if v, err := c.Environment("prod").Hive().GetBool("app/debug"); err != nil || !v {
+		t.Fatalf("%v, %v", v, err)
+	}
+	if list, err := c.Promotions(ctx, "prod", "applied"); err != nil || len(list) != 1 || list[0].ID != p.ID {
+		t.Fatalf("%v, %v", list, err)
+	}
+
+	p, err = c.RollbackPromotion(ctx, p.ID)
+	if err != nil || p.Status != "rolled_back" {
+		t.Fatalf("%v, %v", p, err)
+	}
+	if _, err := c.Environment("prod").Get(ctx, "app"); !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if p, err = c.GetPromotion(ctx, p.ID); err != nil || p.RolledBackBy != "admin" {
+		t.Fatalf("%v, %v", p, err)
+	}
+	entries, err := c.Audit(ctx, cfghive.AuditQuery{Environment: "prod", User: "bob"})
+	if err != nil || len(entries) != 1 || entries[0].Environment != "prod" || entries[0].Key != "app" {
+		t.Fatalf("%v, %v", entries, err)
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	srv := newServer(t, handlers.Limits{IPRate: 0.25, IPBurst: 1})
+	c := client.New(srv.URL)
+	c.Username, c.Password = "admin", "admin-password"
+	if _, err := c.Users(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Users(context.Background())
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 4*time.Second {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
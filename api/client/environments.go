@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Environment A named environment of the server, e.g. dev, staging or prod, with its own hive.
+type Environment struct {
+	Name string `json:"name"`
+	// Protected The environment is only changed by promotions, and by the admins.
+	Protected bool `json:"protected"`
+	// Revision The revision of the last change of the environment hive.
+	Revision uint64 `json:"revision"`
+	// Keys The values of the environment hive.
+	Keys int `json:"keys"`
+}
+
+// PromotionChange A key which differs between two environments.
+type PromotionChange struct {
+	Key string `json:"key"`
+	// Old The value in the target, nil if the key is not set there.
+	Old *cfghive.HiveValue `json:"old,omitempty"`
+	// New The value in the source, nil if the key is deleted from the target.
+	New *cfghive.HiveValue `json:"new,omitempty"`
+}
+
+// Promotion A request to copy the values of a key of an environment to another one.
+type Promotion struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Key The key promoted, "" being the whole hive.
+	Key string `json:"key"`
+	// Status One of pending, applied, rejected or rolled_back.
+	Status       string            `json:"status"`
+	Changes      []PromotionChange `json:"changes"`
+	RequestedBy  string            `json:"requested_by"`
+	Requested    time.Time         `json:"requested"`
+	ReviewedBy   string            `json:"reviewed_by,omitempty"`
+	Reviewed     *time.Time        `json:"reviewed,omitempty"`
+	Revision     uint64            `json:"revision,omitempty"`
+	RolledBackBy string            `json:"rolled_back_by,omitempty"`
+	RolledBack   *time.Time        `json:"rolled_back,omitempty"`
+}
+
+// Environment Gets a client of the hive routes of an environment, with the same HTTP client and credentials,
+// e.g. for its Hive.
+func (c *Client) Environment(name string) *Client {
+	env := *c
+	env.BaseURL = c.BaseURL + "/environments/" + escape(name)
+	return &env
+}
+
+// Environments Lists the environments.
+func (c *Client) Environments(ctx context.Context) ([]Environment, error) {
+	var out struct {
+		Environments []Environment `json:"environments"`
+	}
+	err := c.do(ctx, http.MethodGet, "/environments", nil, &out)
+	return out.Environments, err
+}
+
+// GetEnvironment Gets an environment.
+func (c *Client) GetEnvironment(ctx context.Context, name string) (*Environment, error) {
+	var e Environment
+	err := c.do(ctx, http.MethodGet, "/environments/"+escape(name), nil, &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// EnvironmentDiff Gets the changes promoting key from an environment to another one, sorted by key.
+func (c *Client) EnvironmentDiff(ctx context.Context, from string, to string, key string) ([]PromotionChange, error) {
+	var out struct {
+		Changes []PromotionChange `json:"changes"`
+	}
+	params := url.Values{"from": {from}}
+	if key != "" {
+		params.Set("key", key)
+	}
+	err := c.do(ctx, http.MethodGet, "/environments/"+escape(to)+"/diff?"+params.Encode(), nil, &out)
+	return out.Changes, err
+}
+
+// Promotions Lists the promotions to an environment with a status, newest first.
+// Empty arguments select any.
+func (c *Client) Promotions(ctx context.Context, environment string, status string) ([]Promotion, error) {
+	var out struct {
+		Promotions []Promotion `json:"promotions"`
+	}
+	params := url.Values{}
+	for k, v := range map[string]string{"environment": environment, "status": status} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	path := "/promotions"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out.Promotions, err
+}
+
+// GetPromotion Gets a promotion.
+func (c *Client) GetPromotion(ctx context.Context, id string) (*Promotion, error) {
+	return c.promotion(ctx, http.MethodGet, "/promotions/"+escape(id), nil)
+}
+
+// RequestPromotion Requests the promotion of key from an environment to another one,
+// applied once approved by another user.
+func (c *Client) RequestPromotion(ctx context.Context, from string, to string, key string) (*Promotion, error) {
+	return c.promotion(ctx, http.MethodPost, "/promotions", map[string]string{"from": from, "to": to, "key": key})
+}
+
+// ApprovePromotion Applies a pending promotion requested by another user. Admin only.
+func (c *Client) ApprovePromotion(ctx context.Context, id string) (*Promotion, error) {
+	return c.promotion(ctx, http.MethodPost, "/promotions/"+escape(id)+"/approve", nil)
+}
+
+// RejectPromotion Rejects a pending promotion. Admin only, or the user who requested it.
+func (c *Client) RejectPromotion(ctx context.Context, id string) (*Promotion, error) {
+	return c.promotion(ctx, http.MethodPost, "/promotions/"+escape(id)+"/reject", nil)
+}
+
+// RollbackPromotion Restores the values an applied promotion changed. Admin only.
+func (c *Client) RollbackPromotion(ctx context.Context, id string) (*Promotion, error) {
+	return c.promotion(ctx, http.MethodPost, "/promotions/"+escape(id)+"/rollback", nil)
+}
+
+func (c *Client) promotion(ctx context.Context, method string, path string, in interface{}) (*Promotion, error) {
+	var p Promotion
+	err := c.do(ctx, method, path, in, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// HiveChild A value of a sub-hive, see Children.
+type HiveChild struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// hivePath Gets the path of a key under route, e.g. /hive/a/b.
+func hivePath(route string, key string) string {
+	elements := strings.Split(strings.Trim(key, "/"), "/")
+	for i, e := range elements {
+		elements[i] = escape(e)
+	}
+	return route + strings.Join(elements, "/")
+}
+
+// doHive Sends a hive request, so its errors match the cfghive errors.
+func (c *Client) doHive(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	err := c.do(ctx, method, path, in, out)
+	var e *Error
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusBadRequest:
+			e.err = cfghive.ErrInvalidKey
+		case http.StatusNotFound:
+			e.err = cfghive.ErrKeyNotFound
+		case http.StatusMethodNotAllowed:
+			e.err = cfghive.ErrReadOnly
+		case http.StatusConflict:
+			e.err = cfghive.ErrNotSubHive
+		case http.StatusUnprocessableEntity:
+			e.err = cfghive.ErrInvalidType
+		}
+	}
+	return err
+}
+
+// Get Gets a value, or the whole hive if key is empty.
+func (c *Client) Get(ctx context.Context, key string) (*cfghive.HiveValue, error) {
+	var v cfghive.HiveValue
+	err := c.doHive(ctx, http.MethodGet, hivePath("/hive/", key), nil, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Children Lists the values of a sub-hive, sorted by name.
+func (c *Client) Children(ctx context.Context, key string) ([]HiveChild, error) {
+	var out struct {
+		Children []HiveChild `json:"children"`
+	}
+	err := c.doHive(ctx, http.MethodGet, hivePath("/hive/", key)+"?children", nil, &out)
+	return out.Children, err
+}
+
+// Set Sets a value, of any type supported by cfghive.NewHiveValue.
+func (c *Client) Set(ctx context.Context, key string, value interface{}) error {
+	v, err := cfghive.NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	return c.doHive(ctx, http.MethodPut, hivePath("/hive/", key), v, nil)
+}
+
+// Delete Deletes a value or a sub-hive.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.doHive(ctx, http.MethodDelete, hivePath("/hive/", key), nil, nil)
+}
+
+// NewSub Creates an empty sub-hive.
+func (c *Client) NewSub(ctx context.Context, key string) error {
+	return c.doHive(ctx, http.MethodPost, hivePath("/hive/", key), nil, nil)
+}
+
+// Export Gets the whole hive as plain values, see cfghive.HiveMapToGeneric.
+func (c *Client) Export(ctx context.Context) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	err := c.doHive(ctx, http.MethodGet, "/export", nil, &data)
+	return data, err
+}
+
+// Import Sets every top level key of data, plain values, all or nothing.
+// Returns the number of keys set.
+func (c *Client) Import(ctx context.Context, data map[string]interface{}) (int, error) {
+	var out struct {
+		Imported int `json:"imported"`
+	}
+	err := c.doHive(ctx, http.MethodPost, "/import", data, &out)
+	return out.Imported, err
+}
+
+// hiveAction Sends a commit, rollback or save.
+func (c *Client) hiveAction(ctx context.Context, path string) (bool, error) {
+	var out struct {
+		Done bool `json:"done"`
+	}
+	err := c.doHive(ctx, http.MethodPost, path, nil, &out)
+	return out.Done, err
+}
+
+// Commit Commits the pending changes. Returns true if the hive was committed.
+func (c *Client) Commit(ctx context.Context) (bool, error) {
+	return c.hiveAction(ctx, "/commit")
+}
+
+// Rollback Rolls back the pending changes. Returns true if the hive was rolled back.
+func (c *Client) Rollback(ctx context.Context) (bool, error) {
+	return c.hiveAction(ctx, "/rollback")
+}
+
+// Save Saves the hive.
+func (c *Client) Save(ctx context.Context) error {
+	_, err := c.hiveAction(ctx, "/save")
+	return err
+}
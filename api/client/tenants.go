@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TenantQuota The limits of a tenant. A zero limit is no limit.
+type TenantQuota struct {
+	// The values of the tenant hive.
+	MaxKeys int `json:"max_keys"`
+	// The bytes of a value, as typed JSON.
+	MaxValue int64 `json:"max_value"`
+	// The requests per second of all the tenant users, and how many at once.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Tenant A tenant of the server, with its own hive, users and roles.
+type Tenant struct {
+	Name    string      `json:"name"`
+	Created time.Time   `json:"created"`
+	Quota   TenantQuota `json:"quota"`
+	// Keys The values of the tenant hive.
+	Keys int `json:"keys"`
+}
+
+// Tenant Gets a client of the routes of a tenant, with the same HTTP client and no credentials.
+// Its credentials are the ones of the tenant users, e.g. from its Login.
+func (c *Client) Tenant(name string) *Client {
+	return &Client{BaseURL: c.BaseURL + "/t/" + escape(name), HTTPClient: c.HTTPClient}
+}
+
+// Tenants Lists the tenants. Admin only.
+func (c *Client) Tenants(ctx context.Context) ([]Tenant, error) {
+	var out struct {
+		Tenants []Tenant `json:"tenants"`
+	}
+	err := c.do(ctx, http.MethodGet, "/tenants", nil, &out)
+	return out.Tenants, err
+}
+
+// GetTenant Gets a tenant. Admin only.
+func (c *Client) GetTenant(ctx context.Context, name string) (*Tenant, error) {
+	var t Tenant
+	err := c.do(ctx, http.MethodGet, "/tenants/"+escape(name), nil, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTenant Creates a tenant, with an admin user named "admin". If adminPassword is empty,
+// it is generated and returned, only once. Admin only.
+func (c *Client) CreateTenant(ctx context.Context, name string, adminPassword string, quota TenantQuota) (*Tenant, string, error) {
+	var out struct {
+		Tenant
+		AdminPassword string `json:"admin_password"`
+	}
+	in := map[string]interface{}{"name": name, "admin_password": adminPassword, "quota": quota}
+	err := c.do(ctx, http.MethodPost, "/tenants", in, &out)
+	if err != nil {
+		return nil, "", err
+	}
+	if adminPassword != "" {
+		out.AdminPassword = adminPassword
+	}
+	return &out.Tenant, out.AdminPassword, nil
+}
+
+// SetTenantQuota Sets the quota of a tenant. Admin only.
+func (c *Client) SetTenantQuota(ctx context.Context, name string, quota TenantQuota) (*Tenant, error) {
+	var t Tenant
+	err := c.do(ctx, http.MethodPut, "/tenants/"+escape(name)+"/quota", quota, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTenant Stops serving a tenant. Admin only.
+func (c *Client) DeleteTenant(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/tenants/"+escape(name), nil, nil)
+}
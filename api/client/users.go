@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// User A user of the server.
+type User struct {
+	Name    string    `json:"name"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+}
+
+// APIKey An api key, see CreateAPIKey.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Label   string    `json:"label"`
+	Created time.Time `json:"created"`
+}
+
+// Users Lists the users. Admin only.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	var out struct {
+		Users []User `json:"users"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users", nil, &out)
+	return out.Users, err
+}
+
+// User Gets a user. Admins, or the user itself.
+func (c *Client) User(ctx context.Context, name string) (*User, error) {
+	var u User
+	err := c.do(ctx, http.MethodGet, "/users/"+escape(name), nil, &u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CreateUser Creates a user. Admin only.
+func (c *Client) CreateUser(ctx context.Context, name string, password string, admin bool) (*User, error) {
+	var u User
+	in := map[string]interface{}{"name": name, "password": password, "admin": admin}
+	err := c.do(ctx, http.MethodPost, "/users", in, &u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SetAdmin Grants or revokes the admin role of a user. Admin only.
+func (c *Client) SetAdmin(ctx context.Context, name string, admin bool) (*User, error) {
+	var u User
+	err := c.do(ctx, http.MethodPatch, "/users/"+escape(name), map[string]bool{"admin": admin}, &u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DeleteUser Deletes a user. Admin only.
+func (c *Client) DeleteUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+escape(name), nil, nil)
+}
+
+// SetPassword Changes the password of a user. The old password is needed for the principal's own,
+// admins reset the others without it.
+func (c *Client) SetPassword(ctx context.Context, name string, oldPassword string, password string) error {
+	in := map[string]string{"old_password": oldPassword, "password": password}
+	return c.do(ctx, http.MethodPut, "/users/"+escape(name)+"/password", in, nil)
+}
+
+// APIKeys Lists the api keys of the principal.
+func (c *Client) APIKeys(ctx context.Context) ([]APIKey, error) {
+	var out struct {
+		Keys []APIKey `json:"keys"`
+	}
+	err := c.do(ctx, http.MethodGet, "/auth/keys", nil, &out)
+	return out.Keys, err
+}
+
+// CreateAPIKey Creates an api key of the principal, and gets its secret, which is only returned once.
+func (c *Client) CreateAPIKey(ctx context.Context, label string) (*APIKey, string, error) {
+	var out struct {
+		APIKey
+		Key string `json:"key"`
+	}
+	err := c.do(ctx, http.MethodPost, "/auth/keys", map[string]string{"label": label}, &out)
+	if err != nil {
+		return nil, "", err
+	}
+	return &out.APIKey, out.Key, nil
+}
+
+// DeleteAPIKey Revokes an api key of the principal.
+func (c *Client) DeleteAPIKey(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/auth/keys/"+escape(id), nil, nil)
+}
+
+// SetValue Sets the value of the principal.
+func (c *Client) SetValue(ctx context.Context, value string) error {
+	return c.do(ctx, http.MethodPost, "/admin", map[string]string{"value": value}, nil)
+}
+
+// Value Gets the value of a user, false if there is none. Admins, or the user itself.
+func (c *Client) Value(ctx context.Context, name string) (string, bool, error) {
+	var out struct {
+		Value *string `json:"value"`
+	}
+	err := c.do(ctx, http.MethodGet, "/user/"+escape(name), nil, &out)
+	if err != nil || out.Value == nil {
+		return "", false, err
+	}
+	return *out.Value, true, nil
+}
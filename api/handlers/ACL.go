@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Errors returned by ACL.
+var (
+	ErrRoleNotFound = errors.New("role does not exist")
+	ErrInvalidRole  = errors.New("invalid role")
+)
+
+// The keys of the ACL in the user store hive. They are not user names, so they never clash with users.
+const (
+	aclKey      = "_acl"
+	aclRolesKey = aclKey + "/roles"
+	aclUsersKey = aclKey + "/users"
+)
+
+// Access What a rule allows on the keys it matches. Each access includes the ones below it.
+type Access int
+
+const (
+	AccessNone Access = iota
+	// AccessRead Allows getting and watching keys.
+	AccessRead
+	// AccessWrite Allows setting and deleting keys.
+	AccessWrite
+	// AccessAdmin Allows creating sub-hives, and rolling back the hive.
+	AccessAdmin
+)
+
+var accessNames = []string{"none", "read", "write", "admin"}
+
+func (a Access) String() string {
+	if a < 0 || int(a) >= len(accessNames) {
+		return "Access(" + strconv.Itoa(int(a)) + ")"
+	}
+	return accessNames[a]
+}
+
+// ParseAccess Gets the access with the given name.
+func ParseAccess(s string) (Access, error) {
+	for i, name := range accessNames {
+		if name == s && i > 0 {
+			return Access(i), nil
+		}
+	}
+	return AccessNone, fmt.Errorf("%w: unknown access %q", ErrInvalidRole, s)
+}
+
+func (a Access) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Access) UnmarshalText(b []byte) error {
+	var err error
+	*a, err = ParseAccess(string(b))
+	return err
+}
+
+// Rule Grants an access on the keys matching a path glob, and on their children.
+//
+// The glob elements are matched against the key elements with path.Match,
+// and ** matches any number of elements. For example productParams/* matches
+// productParams/a and productParams/a/b, but not productParams, and ** matches the whole hive.
+type Rule struct {
+	Path   string `json:"path"`
+	Access Access `json:"access"`
+}
+
+// Role A named set of rules.
+type Role struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// matchGlob Reports whether the glob elements match the key elements, or a parent of them.
+func matchGlob(glob []string, key []string) bool {
+	if len(glob) == 0 {
+		return true
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(key); i++ {
+			if matchGlob(glob[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(key) == 0 {
+		return false
+	}
+	ok, _ := path.Match(glob[0], key[0])
+	return ok && matchGlob(glob[1:], key[1:])
+}
+
+// Matches Reports whether the rule applies to key, "" being the whole hive.
+func (r *Rule) Matches(key string) bool {
+	var elems []string
+	if key != "" {
+		elems = strings.Split(key, "/")
+	}
+	return matchGlob(strings.Split(r.Path, "/"), elems)
+}
+
+func checkRule(r Rule) error {
+	if r.Access == AccessNone {
+		return fmt.Errorf("%w: rule %q has no access", ErrInvalidRole, r.Path)
+	}
+	if r.Path == "" {
+		return fmt.Errorf("%w: rule path is empty", ErrInvalidRole)
+	}
+	for _, elem := range strings.Split(r.Path, "/") {
+		if _, err := path.Match(elem, ""); elem == "" || err != nil {
+			return fmt.Errorf("%w: rule path %q is not a valid glob", ErrInvalidRole, r.Path)
+		}
+	}
+	return nil
+}
+
+// readRole Reads a role, with the hive lock held.
+func readRole(hive cfghive.Hive, name string) (*Role, error) {
+	if !userNameRe.MatchString(name) {
+		return nil, ErrRoleNotFound
+	}
+	v, err := hive.Get(aclRolesKey + "/" + name)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%s: %w", name, ErrRoleNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		return nil, err
+	}
+	role := &Role{Name: name, Rules: make([]Rule, len(sub))}
+	for i := range role.Rules {
+		rule := sub[strconv.Itoa(i)]
+		fields, err := rule.Sub()
+		if err != nil {
+			return nil, fmt.Errorf("role %s has no rule %d", name, i)
+		}
+		p, a := fields["path"], fields["access"]
+		role.Rules[i].Path, _ = p.String()
+		access, _ := a.String()
+		role.Rules[i].Access, err = ParseAccess(access)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return role, nil
+}
+
+// readUserRoles Reads the role names of a user, sorted, with the hive lock held.
+func readUserRoles(hive cfghive.Hive, user string) ([]string, error) {
+	v, err := hive.Get(aclUsersKey + "/" + user)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(sub))
+	for name := range sub {
+		roles = append(roles, name)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+// ACL Controls the access of users to the hive keys, with roles granting an access on path globs.
+// Admin users have every access. The roles and the roles of each user are stored in
+// a sub-hive of the user store, which is not served by HiveHandler.
+//
+// Middleware checks every hive operation:
+//
+//	GET /hive, GET /watch        Read on the key.
+//	PUT /hive, DELETE /hive      Write on the key.
+//	POST /hive                   Admin on the key.
+//	GET /export                  Read on the whole hive.
+//	POST /import                 Write on the whole hive.
+//	POST /commit, POST /save     Write on any key.
+//	POST /rollback               Admin on the whole hive.
+//
+// The admin endpoints are:
+//
+//	GET    /acl/roles        Lists the roles.
+//	GET    /acl/roles/:name  Gets a role.
+//	PUT    /acl/roles/:name  Creates or replaces a role with {"rules": [{"path", "access"}]}.
+//	DELETE /acl/roles/:name  Deletes a role, and removes it from the users.
+//	GET    /acl/users/:name  Gets the roles of a user, as {"name", "roles"}.
+//	PUT    /acl/users/:name  Sets the roles of a user with {"roles"}.
+type ACL struct {
+	users *UserStore
+}
+
+// NewACL Creates the access control of the users of store, storing the roles along them.
+func NewACL(users *UserStore) *ACL {
+	return &ACL{users: users}
+}
+
+// PutRole Creates or replaces a role.
+func (a *ACL) PutRole(role Role) error {
+	if !userNameRe.MatchString(role.Name) {
+		return fmt.Errorf("%w: name %q must be 1 to 64 letters, digits or ._@-", ErrInvalidRole, role.Name)
+	}
+	rules := make(map[string]interface{}, len(role.Rules))
+	for i, r := range role.Rules {
+		err := checkRule(r)
+		if err != nil {
+			return err
+		}
+		rules[strconv.Itoa(i)] = map[string]interface{}{"path": r.Path, "access": r.Access.String()}
+	}
+	return a.users.commit(func(hive cfghive.Hive) error {
+		for _, key := range []string{aclKey, aclRolesKey} {
+			if _, err := hive.Get(key); err != nil {
+				hive.NewSub(key)
+			}
+		}
+		return hive.Set(aclRolesKey+"/"+role.Name, rules)
+	})
+}
+
+// GetRole Gets a role.
+func (a *ACL) GetRole(name string) (*Role, error) {
+	var role *Role
+	err := a.users.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		role, err = readRole(hive, name)
+		return err
+	})
+	return role, err
+}
+
+// ListRoles Gets the roles, sorted by name.
+func (a *ACL) ListRoles() ([]Role, error) {
+	roles := []Role{}
+	err := a.users.hive.Do(func(hive cfghive.Hive) error {
+		v, err := hive.Get(aclRolesKey)
+		if errors.Is(err, cfghive.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sub, err := v.Sub()
+		if err != nil {
+			return err
+		}
+		for name := range sub {
+			role, err := readRole(hive, name)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, *role)
+		}
+		return nil
+	})
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles, err
+}
+
+// DeleteRole Deletes a role, and removes it from the users.
+func (a *ACL) DeleteRole(name string) error {
+	return a.users.commit(func(hive cfghive.Hive) error {
+		_, err := readRole(hive, name)
+		if err != nil {
+			return err
+		}
+		hive.Delete(aclRolesKey + "/" + name)
+		v, err := hive.Get(aclUsersKey)
+		if err != nil {
+			return nil
+		}
+		users, _ := v.Sub()
+		for user := range users {
+			hive.Delete(aclUsersKey + "/" + user + "/" + name)
+		}
+		return nil
+	})
+}
+
+// UserRoles Gets the role names of a user, sorted.
+func (a *ACL) UserRoles(user string) ([]string, error) {
+	var roles []string
+	err := a.users.hive.Do(func(hive cfghive.Hive) error {
+		_, _, err := readUser(hive, user)
+		if err != nil {
+			return err
+		}
+		roles, err = readUserRoles(hive, user)
+		return err
+	})
+	return roles, err
+}
+
+// SetUserRoles Sets the roles of a user, which must all exist.
+func (a *ACL) SetUserRoles(user string, roles []string) error {
+	return a.users.commit(func(hive cfghive.Hive) error {
+		_, _, err := readUser(hive, user)
+		if err != nil {
+			return err
+		}
+		set := make(map[string]interface{}, len(roles))
+		for _, name := range roles {
+			_, err := readRole(hive, name)
+			if errors.Is(err, ErrRoleNotFound) {
+				return fmt.Errorf("%w: %s does not exist", ErrInvalidRole, name)
+			}
+			if err != nil {
+				return err
+			}
+			set[name] = true
+		}
+		for _, key := range []string{aclKey, aclUsersKey} {
+			if _, err := hive.Get(key); err != nil {
+				hive.NewSub(key)
+			}
+		}
+		return hive.Set(aclUsersKey+"/"+user, set)
+	})
+}
+
+// rules Gets the rules of the roles of a user.
+func (a *ACL) rules(user string) ([]Rule, error) {
+	var rules []Rule
+	err := a.users.hive.Do(func(hive cfghive.Hive) error {
+		roles, err := readUserRoles(hive, user)
+		if err != nil {
+			return err
+		}
+		for _, name := range roles {
+			role, err := readRole(hive, name)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, role.Rules...)
+		}
+		return nil
+	})
+	return rules, err
+}
+
+// Access Gets the access of a user on key, "" being the whole hive.
+// The access of admin users is not checked, they have every access.
+func (a *ACL) Access(user string, key string) (Access, error) {
+	rules, err := a.rules(user)
+	if err != nil {
+		return AccessNone, err
+	}
+	access := AccessNone
+	for _, r := range rules {
+		if r.Access > access && r.Matches(key) {
+			access = r.Access
+		}
+	}
+	return access, nil
+}
+
+// anyAccess Gets the highest access of a user on any key.
+func (a *ACL) anyAccess(user string) (Access, error) {
+	rules, err := a.rules(user)
+	if err != nil {
+		return AccessNone, err
+	}
+	access := AccessNone
+	for _, r := range rules {
+		if r.Access > access {
+			access = r.Access
+		}
+	}
+	return access, nil
+}
+
+// required Gets the key and the access a hive request needs, see ACL.
+// anyKey is true if the access is needed on any key. The hive routes may be served under a prefix, see Tenants.
+func required(c *gin.Context) (key string, access Access, anyKey bool) {
+	route := c.FullPath()
+	is := func(r string) bool {
+		return strings.HasSuffix(route, r)
+	}
+	switch {
+	case is("/hive/*path"):
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			return hiveKey(c), AccessRead, false
+		case http.MethodPut, http.MethodDelete:
+			return hiveKey(c), AccessWrite, false
+		}
+		return hiveKey(c), AccessAdmin, false
+	case is("/watch/*path"):
+		return hiveKey(c), AccessRead, false
+	case is("/export"):
+		return "", AccessRead, false
+	case is("/import"):
+		return "", AccessWrite, false
+	case is("/commit"), is("/save"):
+		return "", AccessWrite, true
+	}
+	return "", AccessAdmin, false
+}
+
+// Middleware Rejects the hive requests the principal has no access for.
+// It must run after Auth.Middleware.
+func (a *ACL) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := CurrentPrincipal(c)
+		if p == nil {
+			abortWithError(c, http.StatusUnauthorized, ErrBadCredentials)
+			return
+		}
+		if p.Admin {
+			return
+		}
+		key, access, anyKey := required(c)
+		var has Access
+		var err error
+		if anyKey {
+			has, err = a.anyAccess(p.Name)
+		} else {
+			has, err = a.Access(p.Name, key)
+		}
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if has < access {
+			abortWithError(c, http.StatusForbidden, fmt.Errorf("%s access required on /%s", access, key))
+		}
+	}
+}
+
+// Register Adds the admin routes to r, which must authenticate the principal.
+func (a *ACL) Register(r gin.IRouter) {
+	acl := r.Group("/acl", RequireAdmin())
+	acl.GET("/roles", a.listRoles)
+	acl.GET("/roles/:name", a.getRole)
+	acl.PUT("/roles/:name", a.putRole)
+	acl.DELETE("/roles/:name", a.deleteRole)
+	acl.GET("/users/:name", a.getUserRoles)
+	acl.PUT("/users/:name", a.setUserRoles)
+}
+
+// aclErrorStatus Maps an ACL error to a response status.
+func aclErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRoleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidRole):
+		return http.StatusUnprocessableEntity
+	}
+	return userErrorStatus(err)
+}
+
+func abortWithACLError(c *gin.Context, err error) {
+	abortWithError(c, aclErrorStatus(err), err)
+}
+
+func (a *ACL) listRoles(c *gin.Context) {
+	roles, err := a.ListRoles()
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (a *ACL) getRole(c *gin.Context) {
+	role, err := a.GetRole(c.Param("name"))
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (a *ACL) putRole(c *gin.Context) {
+	var body struct {
+		Rules []Rule `json:"rules" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	role := Role{Name: c.Param("name"), Rules: body.Rules}
+	err = a.PutRole(role)
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (a *ACL) deleteRole(c *gin.Context) {
+	err := a.DeleteRole(c.Param("name"))
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (a *ACL) getUserRoles(c *gin.Context) {
+	name := c.Param("name")
+	roles, err := a.UserRoles(name)
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "roles": roles})
+}
+
+func (a *ACL) setUserRoles(c *gin.Context) {
+	var body struct {
+		Roles []string `json:"roles" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	name := c.Param("name")
+	err = a.SetUserRoles(name, body.Roles)
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	roles, err := a.UserRoles(name)
+	if err != nil {
+		abortWithACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "roles": roles})
+}
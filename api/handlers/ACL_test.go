@@ -0,0 +1,189 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func TestRuleMatches(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		key     string
+		matches bool
+	}{
+		{"license", "license", true},
+		{"license", "license/key", true},
+		{"license", "licenses", false},
+		{"license", "", false},
+		{"license/*", "license", false},
+		{"license/*", "license/key/id", true},
+		{"product*/*", "productParams/size", true},
+		{"*/port", "app/port", true},
+		{"*/port", "app/host", false},
+		{"**", "", true},
+		{"**", "a/b/c", true},
+		{"**/port", "app/db/port", true},
+		{"**/port", "port", true},
+		{"**/port", "app/host", false},
+		{"*", "", false},
+	} {
+		r := handlers.Rule{Path: tc.path, Access: handlers.AccessRead}
+		if r.Matches(tc.key) != tc.matches {
+			t.Errorf("%s matches %q is %v", tc.path, tc.key, !tc.matches)
+		}
+	}
+}
+
+func TestACL(t *testing.T) {
+	store := newUserStore(t)
+	acl := handlers.NewACL(store)
+
+	for _, role := range []handlers.Role{
+		{Name: "../x", Rules: []handlers.Rule{{Path: "a", Access: handlers.AccessRead}}},
+		{Name: "bad", Rules: []handlers.Rule{{Path: "a//b", Access: handlers.AccessRead}}},
+		{Name: "bad", Rules: []handlers.Rule{{Path: "[a", Access: handlers.AccessRead}}},
+		{Name: "bad", Rules: []handlers.Rule{{Path: "a", Access: handlers.AccessNone}}},
+	} {
+		if err := acl.PutRole(role); !errors.Is(err, handlers.ErrInvalidRole) {
+			t.Errorf("role %+v: unexpected error %v", role, err)
+		}
+	}
+	reader := handlers.Role{Name: "reader", Rules: []handlers.Rule{
+		{Path: "license", Access: handlers.AccessRead},
+		{Path: "productParams/*", Access: handlers.AccessWrite},
+	}}
+	if err := acl.PutRole(reader); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.PutRole(handlers.Role{Name: "empty", Rules: []handlers.Rule{}}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := acl.GetRole("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*role, reader) {
+		t.Fatalf("unexpected role %+v", role)
+	}
+	if _, err := acl.GetRole("missing"); !errors.Is(err, handlers.ErrRoleNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := acl.SetUserRoles("alice", []string{"missing"}); !errors.Is(err, handlers.ErrInvalidRole) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := acl.SetUserRoles("nobody", []string{"reader"}); !errors.Is(err, handlers.ErrUserNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := acl.SetUserRoles("alice", []string{"reader", "empty"}); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]handlers.Access{
+		"license/key":          handlers.AccessRead,
+		"productParams/size":   handlers.AccessWrite,
+		"productParams":        handlers.AccessNone,
+		"":                     handlers.AccessNone,
+		"productParams/size/x": handlers.AccessWrite,
+	} {
+		if access, err := acl.Access("alice", key); err != nil || access != expected {
+			t.Errorf("access on %q is %v, %v, expected %v", key, access, err, expected)
+		}
+	}
+
+	// The ACL is not a user.
+	users, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("unexpected users %+v", users)
+	}
+	if err := store.SetAdmin("admin", false); !errors.Is(err, handlers.ErrLastAdmin) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := acl.DeleteRole("reader"); err != nil {
+		t.Fatal(err)
+	}
+	if roles, err := acl.UserRoles("alice"); err != nil || !reflect.DeepEqual(roles, []string{"empty"}) {
+		t.Fatalf("unexpected roles %v, %v", roles, err)
+	}
+	roles, err := acl.ListRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0].Name != "empty" {
+		t.Fatalf("unexpected roles %+v", roles)
+	}
+
+	// The roles of a deleted user are not given to a new user of the same name.
+	if err := store.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("alice", "alice-password", false); err != nil {
+		t.Fatal(err)
+	}
+	if roles, err := acl.UserRoles("alice"); err != nil || len(roles) != 0 {
+		t.Fatalf("unexpected roles %v, %v", roles, err)
+	}
+}
+
+func TestACLMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	if _, err := store.Create("bob", "bob-password", false); err != nil {
+		t.Fatal(err)
+	}
+	acl := handlers.NewACL(store)
+	engine := gin.New()
+	authorized := engine.Group("/", handlers.NewAuth(store, testSecret).Middleware())
+	hive, _ := cfghive.NewMemHive()
+	handlers.NewHiveHandler(hive).Register(authorized.Group("/", acl.Middleware()))
+	acl.Register(authorized)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		user   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"admin", http.MethodPost, "/hive/license", "", http.StatusCreated},
+		{"admin", http.MethodPost, "/hive/productParams", "", http.StatusCreated},
+		{"admin", http.MethodPut, "/hive/license/key", `{"type":"string","value":"secret"}`, http.StatusNoContent},
+		{"alice", http.MethodGet, "/hive/license/key", "", http.StatusForbidden},
+		{"alice", http.MethodGet, "/acl/roles", "", http.StatusForbidden},
+		{"admin", http.MethodPut, "/acl/roles/params", `{"rules":[{"path":"productParams/*","access":"write"},{"path":"license","access":"read"}]}`, http.StatusOK},
+		{"admin", http.MethodPut, "/acl/roles/bad", `{"rules":[{"path":"x","access":"owner"}]}`, http.StatusUnprocessableEntity},
+		{"admin", http.MethodPut, "/acl/users/alice", `{"roles":["params"]}`, http.StatusOK},
+		{"admin", http.MethodPut, "/acl/users/alice", `{"roles":["missing"]}`, http.StatusUnprocessableEntity},
+		{"admin", http.MethodPut, "/acl/users/nobody", `{"roles":["params"]}`, http.StatusNotFound},
+		{"alice", http.MethodGet, "/hive/license/key", "", http.StatusOK},
+		{"alice", http.MethodPut, "/hive/license/key", `{"type":"string","value":"stolen"}`, http.StatusForbidden},
+		{"alice", http.MethodPut, "/hive/productParams/size", `{"type":"int","value":3}`, http.StatusNoContent},
+		{"alice", http.MethodPost, "/hive/productParams/sub", "", http.StatusForbidden},
+		{"alice", http.MethodGet, "/hive/", "", http.StatusForbidden},
+		{"alice", http.MethodGet, "/export", "", http.StatusForbidden},
+		{"alice", http.MethodPost, "/commit", "", http.StatusOK},
+		{"alice", http.MethodPost, "/rollback", "", http.StatusForbidden},
+		{"alice", http.MethodGet, "/watch/productParams", "", http.StatusForbidden},
+		{"bob", http.MethodGet, "/hive/productParams/size", "", http.StatusForbidden},
+		{"bob", http.MethodPost, "/commit", "", http.StatusForbidden},
+		{"admin", http.MethodDelete, "/acl/roles/params", "", http.StatusNoContent},
+		{"alice", http.MethodGet, "/hive/license/key", "", http.StatusForbidden},
+	} {
+		resp := userRequest(t, srv, tc.user, tc.method, tc.path, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s %s: status %d, expected %d", tc.user, tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+	}
+}
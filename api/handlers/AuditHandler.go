@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// maxAuditEntries The number of audit entries returned at most by a query.
+const maxAuditEntries = 1000
+
+// AuditHandler Serves the audit log of the hive changes, see HiveHandler.Audit:
+//
+//	GET /audit         Gets the entries, as {"entries"}, oldest first. Admin only.
+//	                   ?user, ?tenant ("-" for the main hive), ?environment ("-" for the other hives), ?key, ?op, ?since and ?until (RFC 3339), ?after (a sequence number)
+//	                   and ?limit (100 by default) select them, see cfghive.AuditQuery.
+//	GET /audit/verify  Checks the hash chain of the log, as {"valid", "entries", "error"}. Admin only.
+type AuditHandler struct {
+	log *cfghive.AuditLog
+}
+
+// NewAuditHandler Creates a handler serving log.
+func NewAuditHandler(log *cfghive.AuditLog) *AuditHandler {
+	return &AuditHandler{log: log}
+}
+
+// Register Adds the audit routes to r, which must authenticate the principal.
+func (h *AuditHandler) Register(r gin.IRouter) {
+	audit := r.Group("/audit", RequireAdmin())
+	audit.GET("", h.query)
+	audit.GET("/verify", h.verify)
+}
+
+// auditQuery Gets the query of the request parameters.
+func auditQuery(c *gin.Context) (cfghive.AuditQuery, error) {
+	q := cfghive.AuditQuery{
+		User:        c.Query("user"),
+		Tenant:      c.Query("tenant"),
+		Environment: c.Query("environment"),
+		Key:         c.Query("key"),
+		Op:          c.Query("op"),
+		Limit:       100,
+	}
+	var err error
+	if s := c.Query("since"); s != "" {
+		q.Since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, err
+		}
+	}
+	if s := c.Query("until"); s != "" {
+		q.Until, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, err
+		}
+	}
+	if s := c.Query("after"); s != "" {
+		q.After, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return q, err
+		}
+	}
+	if s := c.Query("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil {
+			return q, err
+		}
+	}
+	if q.Limit <= 0 || q.Limit > maxAuditEntries {
+		q.Limit = maxAuditEntries
+	}
+	return q, nil
+}
+
+func (h *AuditHandler) query(c *gin.Context) {
+	q, err := auditQuery(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := h.log.Query(q)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+func (h *AuditHandler) verify(c *gin.Context) {
+	n, err := h.log.Verify()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "entries": n, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true, "entries": n})
+}
@@ -0,0 +1,120 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func TestAuditHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	audit, err := cfghive.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	store := newUserStore(t)
+	if err := store.SetAdmin("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	if err := engine.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	authorized := engine.Group("/", handlers.NewAuth(store, testSecret).Middleware())
+	hive, _ := cfghive.NewMemHive()
+	h := handlers.NewHiveHandler(hive)
+	h.Audit = audit
+	h.Register(authorized)
+	handlers.NewAuditHandler(audit).Register(authorized)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		user   string
+		method string
+		path   string
+		body   string
+	}{
+		{"admin", http.MethodPost, "/hive/app", ""},
+		{"admin", http.MethodPut, "/hive/app/port", `{"type":"int","value":80}`},
+		{"alice", http.MethodPut, "/hive/app/port", `{"type":"int","value":8080}`},
+		{"alice", http.MethodDelete, "/hive/app/port", ""},
+		{"alice", http.MethodGet, "/hive/app", ""},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		req.SetBasicAuth(tc.user, tc.user+"-password")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Fatalf("%s %s: status %d", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+
+	resp := userRequest(t, srv, "admin", http.MethodGet, "/audit?user=alice", "")
+	var body struct {
+		Entries []cfghive.AuditEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Entries) != 2 {
+		t.Fatalf("unexpected entries %+v", body.Entries)
+	}
+	set, del := body.Entries[0], body.Entries[1]
+	if set.Op != "set" || set.Key != "app/port" || set.Client != "203.0.113.7" || set.Seq != 3 || set.Hash == "" {
+		t.Fatalf("unexpected entry %+v", set)
+	}
+	if old, _ := set.Old.Int(); old != 80 {
+		t.Fatalf("old value is %v", set.Old)
+	}
+	if n, _ := set.New.Int(); n != 8080 {
+		t.Fatalf("new value is %v", set.New)
+	}
+	if del.Op != "delete" || del.New != nil || del.Prev != set.Hash {
+		t.Fatalf("unexpected entry %+v", del)
+	}
+
+	if resp := userRequest(t, srv, "admin", http.MethodGet, "/audit?since=yesterday", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if resp := userRequest(t, srv, "bob", http.MethodGet, "/audit", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	resp = userRequest(t, srv, "admin", http.MethodGet, "/audit/verify", "")
+	var verify struct {
+		Valid   bool `json:"valid"`
+		Entries int  `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verify); err != nil {
+		t.Fatal(err)
+	}
+	if !verify.Valid || verify.Entries != 4 {
+		t.Fatalf("unexpected verification %+v", verify)
+	}
+
+	// The changes which cannot be audited are undone, all the keys of an import.
+	audit.Close()
+	if resp := userRequest(t, srv, "admin", http.MethodPost, "/import", `{"a": 1, "b": 2, "app": {}}`); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("import is %d", resp.StatusCode)
+	}
+	for _, key := range []string{"a", "b"} {
+		if resp := userRequest(t, srv, "admin", http.MethodGet, "/hive/"+key, ""); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s is %d", key, resp.StatusCode)
+		}
+	}
+	if resp := userRequest(t, srv, "admin", http.MethodGet, "/hive/app?children", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("app is %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PrincipalKey The context key of the authenticated *Principal.
+const PrincipalKey = "handlers.principal"
+
+// Authentication methods of a principal.
+const (
+	AuthBasic  = "basic"
+	AuthToken  = "token"
+	AuthAPIKey = "apikey"
+	AuthCert   = "cert"
+)
+
+// Principal Who a request is authenticated as.
+type Principal struct {
+	// The user name, the owner of the key for api keys.
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+	// How the request was authenticated, AuthBasic, AuthToken, AuthAPIKey or AuthCert.
+	Method string `json:"method"`
+	// The api key id, for AuthAPIKey.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// CurrentPrincipal Gets the authenticated principal, nil if there is none.
+func CurrentPrincipal(c *gin.Context) *Principal {
+	p, _ := c.Get(PrincipalKey)
+	principal, _ := p.(*Principal)
+	return principal
+}
+
+// RequireAdmin Rejects requests of principals who are not admins.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := CurrentPrincipal(c)
+		if p == nil || !p.Admin {
+			abortWithError(c, http.StatusForbidden, errors.New("admin role required"))
+		}
+	}
+}
+
+// Token uses, so a refresh token is never accepted as an access token.
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+const tokenIssuer = "potential-framework"
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Use string `json:"use"`
+	// The token generation of the user, see User.
+	Gen int64 `json:"gen"`
+	// The nonce of the user, see User.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Auth Authenticates the api requests, and issues tokens.
+//
+// Requests are authenticated with, in order:
+//
+//	Authorization: Bearer <access token>  A JWT access token from /auth/login or /auth/refresh.
+//	Authorization: Bearer <api key>       An api key from /auth/keys, also accepted in X-API-Key.
+//	Authorization: Basic <credentials>    A user name and password.
+//	A TLS client certificate              Verified by the server, the common name is the user name.
+//
+// The endpoints are:
+//
+//	POST   /auth/login    Issues tokens for {"name", "password"}. Unauthenticated.
+//	POST   /auth/refresh  Issues new tokens for {"refresh_token"}. Unauthenticated.
+//	GET    /auth/keys     Lists the api keys of the principal.
+//	POST   /auth/keys     Creates an api key with {"label"}. The key is only returned once.
+//	DELETE /auth/keys/:id Revokes an api key.
+//
+// Tokens are signed with HS256. Changing a password revokes the tokens issued before,
+// and tokens of deleted users are rejected, as the user is read for every request.
+type Auth struct {
+	users  *UserStore
+	secret []byte
+	// How long access and refresh tokens are valid, see SetTTL.
+	lock       sync.RWMutex
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewAuth Creates the authentication of the users of store, signing tokens with secret.
+func NewAuth(users *UserStore, secret []byte) *Auth {
+	return &Auth{
+		users:      users,
+		secret:     secret,
+		accessTTL:  15 * time.Minute,
+		refreshTTL: 7 * 24 * time.Hour,
+	}
+}
+
+// SetTTL Sets how long the tokens issued from now on are valid, 15 minutes and 7 days by default.
+func (a *Auth) SetTTL(access time.Duration, refresh time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.accessTTL = access
+	a.refreshTTL = refresh
+}
+
+// ttl Gets how long access and refresh tokens are valid.
+func (a *Auth) ttl() (time.Duration, time.Duration) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.accessTTL, a.refreshTTL
+}
+
+// RegisterPublic Adds the login and refresh routes to r, which must not require authentication.
+func (a *Auth) RegisterPublic(r gin.IRoutes) {
+	r.POST("/auth/login", a.login)
+	r.POST("/auth/refresh", a.refresh)
+}
+
+// Register Adds the api key routes to r, which must authenticate the principal.
+func (a *Auth) Register(r gin.IRoutes) {
+	r.GET("/auth/keys", a.listKeys)
+	r.POST("/auth/keys", a.createKey)
+	r.DELETE("/auth/keys/:id", a.deleteKey)
+}
+
+// issue Signs a token for a user.
+func (a *Auth) issue(u *User, use string, ttl time.Duration) (string, error) {
+	id, err := randomString(12)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   u.Name,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        id,
+		},
+		Use:   use,
+		Gen:   u.tokenGen,
+		Nonce: u.nonce,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+}
+
+// verify Gets the user a token of the given use was issued to.
+func (a *Auth) verify(token string, use string) (*User, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadCredentials, err)
+	}
+	if claims.Use != use {
+		return nil, fmt.Errorf("%w: not a %s token", ErrBadCredentials, use)
+	}
+	u, err := a.users.Get(claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != u.nonce {
+		return nil, fmt.Errorf("%w: token is of a deleted user", ErrBadCredentials)
+	}
+	if claims.Gen != u.tokenGen {
+		return nil, fmt.Errorf("%w: token is revoked", ErrBadCredentials)
+	}
+	return u, nil
+}
+
+// Credentials What a request is authenticated with, see Auth.
+type Credentials struct {
+	// The Authorization header, "Bearer <token or api key>" or "Basic <credentials>".
+	Authorization string
+	// The X-API-Key header.
+	APIKey string
+	// The TLS connection, if any, with the verified client certificate.
+	TLS *tls.ConnectionState
+}
+
+// Authenticate Gets the principal of credentials, nil if there are none.
+// Invalid credentials are reported with ErrBadCredentials.
+func (a *Auth) Authenticate(creds Credentials) (*Principal, error) {
+	key := creds.APIKey
+	if bearer, ok := strings.CutPrefix(creds.Authorization, "Bearer "); ok {
+		if !strings.HasPrefix(bearer, apiKeyPrefix) {
+			u, err := a.verify(bearer, tokenAccess)
+			if err != nil {
+				return nil, err
+			}
+			return &Principal{Name: u.Name, Admin: u.Admin, Method: AuthToken}, nil
+		}
+		key = bearer
+	}
+	if key != "" {
+		u, k, err := a.users.AuthenticateAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Name: u.Name, Admin: u.Admin, Method: AuthAPIKey, KeyID: k.ID}, nil
+	}
+	if name, password, ok := parseBasicAuth(creds.Authorization); ok {
+		u, err := a.users.Authenticate(name, password)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Name: u.Name, Admin: u.Admin, Method: AuthBasic}, nil
+	}
+	if tls := creds.TLS; tls != nil && len(tls.VerifiedChains) > 0 {
+		u, err := a.users.Get(tls.VerifiedChains[0][0].Subject.CommonName)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, fmt.Errorf("%w: unknown client certificate", ErrBadCredentials)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Name: u.Name, Admin: u.Admin, Method: AuthCert}, nil
+	}
+	return nil, nil
+}
+
+// parseBasicAuth Gets the user name and password of a Basic Authorization header.
+func parseBasicAuth(authorization string) (string, string, bool) {
+	r := http.Request{Header: http.Header{"Authorization": {authorization}}}
+	return r.BasicAuth()
+}
+
+// authenticate Gets the principal of a request, nil if it has no credentials.
+func (a *Auth) authenticate(c *gin.Context) (*Principal, error) {
+	return a.Authenticate(Credentials{
+		Authorization: c.GetHeader("Authorization"),
+		APIKey:        c.GetHeader("X-API-Key"),
+		TLS:           c.Request.TLS,
+	})
+}
+
+// Middleware Authenticates requests, setting the principal as PrincipalKey.
+// Requests without valid credentials are rejected.
+func (a *Auth) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := a.authenticate(c)
+		if p == nil && err == nil {
+			err = fmt.Errorf("%w: authentication required", ErrBadCredentials)
+		}
+		if errors.Is(err, ErrBadCredentials) {
+			c.Header("WWW-Authenticate", `Basic realm="Authorization Required"`)
+			abortWithError(c, http.StatusUnauthorized, err)
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Set(PrincipalKey, p)
+	}
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// issuePair Writes a new access and refresh token for a user.
+func (a *Auth) issuePair(c *gin.Context, u *User) {
+	accessTTL, refreshTTL := a.ttl()
+	access, err := a.issue(u, tokenAccess, accessTTL)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	refresh, err := a.issue(u, tokenRefresh, refreshTTL)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTTL.Seconds()),
+	})
+}
+
+func (a *Auth) login(c *gin.Context) {
+	var body struct {
+		Name     string `json:"name" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	u, err := a.users.Authenticate(body.Name, body.Password)
+	if errors.Is(err, ErrBadCredentials) {
+		abortWithError(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	a.issuePair(c, u)
+}
+
+func (a *Auth) refresh(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	u, err := a.verify(body.RefreshToken, tokenRefresh)
+	if errors.Is(err, ErrBadCredentials) {
+		abortWithError(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	a.issuePair(c, u)
+}
+
+func (a *Auth) listKeys(c *gin.Context) {
+	keys, err := a.users.ListAPIKeys(CurrentPrincipal(c).Name)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (a *Auth) createKey(c *gin.Context) {
+	var body struct {
+		Label string `json:"label"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	k, key, err := a.users.CreateAPIKey(CurrentPrincipal(c).Name, body.Label)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": k.ID, "label": k.Label, "created": k.Created, "key": key})
+}
+
+func (a *Auth) deleteKey(c *gin.Context) {
+	err := a.users.DeleteAPIKey(CurrentPrincipal(c).Name, c.Param("id"))
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
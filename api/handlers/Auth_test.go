@@ -0,0 +1,185 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newAuthServer(t *testing.T) (*httptest.Server, *handlers.UserStore) {
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	auth := handlers.NewAuth(store, testSecret)
+	engine := gin.New()
+	auth.RegisterPublic(engine)
+	authorized := engine.Group("/", auth.Middleware())
+	auth.Register(authorized)
+	authorized.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, handlers.CurrentPrincipal(c))
+	})
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+// authRequest Sends a request with the given headers, decoding the response into out if not nil.
+func authRequest(t *testing.T, method string, url string, body string, header map[string]string, out interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func TestAuthTokens(t *testing.T) {
+	srv, store := newAuthServer(t)
+
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/login", `{"name":"alice","password":"wrong-password"}`, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("login status %d", status)
+	}
+	var login tokens
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/login", `{"name":"alice","password":"alice-password"}`, nil, &login); status != http.StatusOK {
+		t.Fatalf("login status %d", status)
+	}
+	var me handlers.Principal
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(login.AccessToken), &me); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if me.Name != "alice" || me.Method != handlers.AuthToken {
+		t.Fatalf("unexpected principal %+v", me)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(login.RefreshToken), nil); status != http.StatusUnauthorized {
+		t.Fatalf("refresh token accepted as access token, status %d", status)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(login.AccessToken+"x"), nil); status != http.StatusUnauthorized {
+		t.Fatalf("tampered token accepted, status %d", status)
+	}
+
+	var refreshed tokens
+	body := `{"refresh_token":"` + login.RefreshToken + `"}`
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/refresh", body, nil, &refreshed); status != http.StatusOK {
+		t.Fatalf("refresh status %d", status)
+	}
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/refresh", `{"refresh_token":"`+login.AccessToken+`"}`, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("access token accepted as refresh token, status %d", status)
+	}
+
+	// Changing the password revokes the tokens.
+	if err := store.SetPassword("alice", "new-alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{login.AccessToken, refreshed.AccessToken} {
+		if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(token), nil); status != http.StatusUnauthorized {
+			t.Fatalf("revoked token accepted, status %d", status)
+		}
+	}
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/refresh", body, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("revoked refresh token accepted, status %d", status)
+	}
+}
+
+func TestAuthDeletedUser(t *testing.T) {
+	srv, store := newAuthServer(t)
+	var login tokens
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/login", `{"name":"alice","password":"alice-password"}`, nil, &login); status != http.StatusOK {
+		t.Fatalf("login status %d", status)
+	}
+
+	// The tokens of a deleted user are not valid for a new one with the same name.
+	if err := store.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("alice", "other-password", true); err != nil {
+		t.Fatal(err)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(login.AccessToken), nil); status != http.StatusUnauthorized {
+		t.Fatalf("token of a deleted user accepted, status %d", status)
+	}
+	body := `{"refresh_token":"` + login.RefreshToken + `"}`
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/refresh", body, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("refresh token of a deleted user accepted, status %d", status)
+	}
+}
+
+func TestAuthAPIKeys(t *testing.T) {
+	srv, _ := newAuthServer(t)
+	basic := func(user string) map[string]string {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, user+"-password")
+		return map[string]string{"Authorization": req.Header.Get("Authorization")}
+	}
+
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status %d", status)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", basic("nobody"), nil); status != http.StatusUnauthorized {
+		t.Fatalf("unknown user status %d", status)
+	}
+
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	if status := authRequest(t, http.MethodPost, srv.URL+"/auth/keys", `{"label":"ci"}`, basic("alice"), &created); status != http.StatusCreated {
+		t.Fatalf("create key status %d", status)
+	}
+	var me handlers.Principal
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(created.Key), &me); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if me.Name != "alice" || me.Method != handlers.AuthAPIKey || me.KeyID != created.ID {
+		t.Fatalf("unexpected principal %+v", me)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", map[string]string{"X-API-Key": created.Key}, nil); status != http.StatusOK {
+		t.Fatalf("X-API-Key status %d", status)
+	}
+
+	var list struct {
+		Keys []handlers.APIKey `json:"keys"`
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/auth/keys", "", bearer(created.Key), &list); status != http.StatusOK {
+		t.Fatalf("list keys status %d", status)
+	}
+	if len(list.Keys) != 1 || list.Keys[0].Label != "ci" {
+		t.Fatalf("unexpected keys %+v", list.Keys)
+	}
+	// Keys belong to their user.
+	if status := authRequest(t, http.MethodDelete, srv.URL+"/auth/keys/"+created.ID, "", basic("admin"), nil); status != http.StatusNotFound {
+		t.Fatalf("delete key of another user status %d", status)
+	}
+	if status := authRequest(t, http.MethodDelete, srv.URL+"/auth/keys/"+created.ID, "", basic("alice"), nil); status != http.StatusNoContent {
+		t.Fatalf("delete key status %d", status)
+	}
+	if status := authRequest(t, http.MethodGet, srv.URL+"/me", "", bearer(created.Key), nil); status != http.StatusUnauthorized {
+		t.Fatalf("revoked key status %d", status)
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Change operations, also used as the SSE event names.
+const (
+	ChangeSet      = "set"
+	ChangeDelete   = "delete"
+	ChangeNewSub   = "sub"
+	ChangeRollback = "rollback"
+	// ChangeReset Tells a client that changes were missed, and it must fetch the hive again.
+	ChangeReset = "reset"
+)
+
+// Change A change applied to the hive.
+type Change struct {
+	Revision uint64             `json:"revision"`
+	Op       string             `json:"op"`
+	Key      string             `json:"key,omitempty"`
+	Value    *cfghive.HiveValue `json:"value,omitempty"`
+	Time     time.Time          `json:"time"`
+}
+
+// affects Reports whether the change may change a key under prefix.
+func (c *Change) affects(prefix string) bool {
+	if prefix == "" || c.Key == "" {
+		return true
+	}
+	return c.Key == prefix || strings.HasPrefix(c.Key, prefix+"/") || strings.HasPrefix(prefix, c.Key+"/")
+}
+
+// within Gets the change as seen by a watcher of prefix. The value set on a parent of prefix is cut
+// down to the value of prefix, a delete if it has none, so the watchers never get the values of keys
+// they do not watch, which they may not be allowed to read, see ACL.
+func (c Change) within(prefix string) Change {
+	if c.Value == nil || prefix == "" || c.Key == prefix || c.Key != "" && !strings.HasPrefix(prefix, c.Key+"/") {
+		return c
+	}
+	v := c.Value
+	for _, name := range strings.Split(strings.TrimPrefix(prefix, c.Key+"/"), "/") {
+		sub, err := v.Sub()
+		if err != nil {
+			v = nil
+			break
+		}
+		child, ok := sub[name]
+		if !ok {
+			v = nil
+			break
+		}
+		v = &child
+	}
+	c.Key, c.Value = prefix, v
+	if v == nil {
+		c.Op = ChangeDelete
+	}
+	return c
+}
+
+// ChangeFeed Numbers the hive changes with a global revision, and fans them out to subscribers.
+// The last changes are kept, so reconnecting clients can resume from the revision they last saw.
+//
+// Every key also has a revision, the revision of the last change to the key, to one of its
+// children, or to one of its parents replacing it. Keys unchanged since the feed was created
+// have revision 0.
+type ChangeFeed struct {
+	lock     sync.Mutex
+	revision uint64
+	history  []Change
+	size     int
+	subs     map[chan Change]struct{}
+	// When the feed was created, so ETags from before a restart never match.
+	epoch string
+	// The revision of the last change to each key or to its children.
+	modified map[string]uint64
+	// The revision of the last change to each key itself, which replaces its children.
+	replaced map[string]uint64
+	closed   bool
+}
+
+// NewChangeFeed Creates a feed that keeps the last size changes.
+func NewChangeFeed(size int) *ChangeFeed {
+	return &ChangeFeed{
+		size:     size,
+		subs:     make(map[chan Change]struct{}),
+		epoch:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		modified: make(map[string]uint64),
+		replaced: make(map[string]uint64),
+	}
+}
+
+// parentKeys Gets the parents of key, from the root "" down.
+func parentKeys(key string) []string {
+	parents := []string{""}
+	for i := 0; i < len(key); i++ {
+		if key[i] == '/' {
+			parents = append(parents, key[:i])
+		}
+	}
+	return parents
+}
+
+// KeyRevision Gets the revision of key.
+func (f *ChangeFeed) KeyRevision(key string) uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r := f.modified[key]
+	for _, p := range parentKeys(key) {
+		if f.replaced[p] > r {
+			r = f.replaced[p]
+		}
+	}
+	return r
+}
+
+// ETag Gets the entity tag of the revision of key.
+func (f *ChangeFeed) ETag(key string) string {
+	return `"` + f.epoch + "-" + strconv.FormatUint(f.KeyRevision(key), 10) + `"`
+}
+
+// Revision Gets the revision of the last change.
+func (f *ChangeFeed) Revision() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.revision
+}
+
+// Publish Records a change and sends it to the subscribers.
+// Subscribers too slow to keep up are dropped, their channel is closed.
+func (f *ChangeFeed) Publish(op string, key string, value *cfghive.HiveValue) Change {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.revision++
+	c := Change{Revision: f.revision, Op: op, Key: key, Value: value, Time: time.Now().UTC()}
+	f.replaced[key] = f.revision
+	f.modified[key] = f.revision
+	if key != "" {
+		for _, p := range parentKeys(key) {
+			f.modified[p] = f.revision
+		}
+	}
+	f.history = append(f.history, c)
+	if len(f.history) > f.size {
+		f.history = f.history[len(f.history)-f.size:]
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- c:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return c
+}
+
+// Subscribe Gets the changes after revision, and a channel of the changes to come.
+// If changes after revision are no longer kept, a reset change is returned first.
+func (f *ChangeFeed) Subscribe(revision uint64) ([]Change, chan Change) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var missed []Change
+	oldest := f.revision + 1 - uint64(len(f.history))
+	if revision > f.revision || revision+1 < oldest {
+		// The client is from before a restart, or too far behind.
+		missed = append(missed, Change{Revision: f.revision, Op: ChangeReset, Time: time.Now().UTC()})
+	} else {
+		missed = append(missed, f.history[revision+1-oldest:]...)
+	}
+	ch := make(chan Change, 64)
+	if f.closed {
+		close(ch)
+		return missed, ch
+	}
+	f.subs[ch] = struct{}{}
+	return missed, ch
+}
+
+// Close Closes the channels of the subscribers, so the streams end, e.g. when the server shuts down.
+// Later subscribers get a closed channel.
+func (f *ChangeFeed) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	for ch := range f.subs {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// Unsubscribe Stops sending changes to ch.
+func (f *ChangeFeed) Unsubscribe(ch chan Change) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// watchKeepAlive How often a comment is sent on idle streams, so proxies keep them open.
+var watchKeepAlive = 30 * time.Second
+
+// watch Streams the changes under a path as server-sent events.
+// The event id is the change revision. Clients resume with the Last-Event-ID header,
+// or start after a revision returned by GET with ?revision.
+func (h *HiveHandler) watch(c *gin.Context) {
+	prefix := hiveKey(c)
+	revision := h.feed.Revision()
+	from := c.GetHeader("Last-Event-ID")
+	if q, ok := c.GetQuery("revision"); ok {
+		from = q
+	}
+	if from != "" {
+		r, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		revision = r
+	}
+	missed, ch := h.feed.Subscribe(revision)
+	defer h.feed.Unsubscribe(ch)
+
+	// Streams outlive the server write timeout.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func(change Change) {
+		if change.affects(prefix) {
+			change = change.within(prefix)
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(change.Revision, 10),
+				Event: change.Op,
+				Data:  change,
+			})
+		}
+	}
+	for _, change := range missed {
+		send(change)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case change, ok := <-ch:
+			if !ok {
+				// Too slow, or the server shuts down, the client reconnects and resumes from its last event.
+				return
+			}
+			send(change)
+		case <-keepAlive.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
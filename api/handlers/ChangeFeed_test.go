@@ -0,0 +1,180 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func TestChangeFeedResume(t *testing.T) {
+	f := handlers.NewChangeFeed(2)
+	for _, k := range []string{"a", "b", "c"} {
+		f.Publish(handlers.ChangeSet, k, nil)
+	}
+
+	missed, ch := f.Subscribe(1)
+	f.Unsubscribe(ch)
+	if len(missed) != 2 || missed[0].Key != "b" || missed[1].Key != "c" {
+		t.Fatalf("unexpected missed changes %+v", missed)
+	}
+	missed, ch = f.Subscribe(3)
+	if len(missed) != 0 {
+		t.Fatalf("unexpected missed changes %+v", missed)
+	}
+	f.Publish(handlers.ChangeDelete, "a", nil)
+	if c := <-ch; c.Revision != 4 || c.Op != handlers.ChangeDelete {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	f.Unsubscribe(ch)
+
+	// Changes after revision 0 are no longer kept, and revision 9 is from before a restart.
+	for _, r := range []uint64{0, 9} {
+		missed, ch = f.Subscribe(r)
+		f.Unsubscribe(ch)
+		if len(missed) != 1 || missed[0].Op != handlers.ChangeReset {
+			t.Fatalf("unexpected missed changes after %d: %+v", r, missed)
+		}
+	}
+}
+
+func TestChangeFeedSlowSubscriber(t *testing.T) {
+	f := handlers.NewChangeFeed(1)
+	_, ch := f.Subscribe(0)
+	for i := 0; i < 100; i++ {
+		f.Publish(handlers.ChangeSet, "a", nil)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n == 0 || n == 100 {
+		t.Fatalf("slow subscriber got %d changes", n)
+	}
+}
+
+func TestChangeFeedClose(t *testing.T) {
+	f := handlers.NewChangeFeed(1)
+	_, before := f.Subscribe(0)
+	f.Close()
+	_, after := f.Subscribe(0)
+	for _, ch := range []chan handlers.Change{before, after} {
+		if _, ok := <-ch; ok {
+			t.Fatal("subscriber channel is open")
+		}
+	}
+	f.Publish(handlers.ChangeSet, "a", nil)
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  handlers.Change
+}
+
+// readEvents Reads n events from a server-sent events stream.
+func readEvents(t *testing.T, r *bufio.Reader, n int) []sseEvent {
+	var events []sseEvent
+	var e sseEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.event != "" {
+				events = append(events, e)
+			}
+			e = sseEvent{}
+		case strings.HasPrefix(line, "id:"):
+			e.id = line[3:]
+		case strings.HasPrefix(line, "event:"):
+			e.event = line[6:]
+		case strings.HasPrefix(line, "data:"):
+			if err := json.Unmarshal([]byte(line[5:]), &e.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return events
+}
+
+func watch(t *testing.T, url string, lastEventID string) *bufio.Reader {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("watch status %d", resp.StatusCode)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestHiveHandlerWatch(t *testing.T) {
+	srv, _ := newHiveServer(t)
+	stream := watch(t, srv.URL+"/watch/app", "")
+
+	request(t, http.MethodPost, srv.URL+"/hive/app", "")
+	request(t, http.MethodPost, srv.URL+"/hive/other", "")
+	request(t, http.MethodPut, srv.URL+"/hive/app/port", `{"type":"int","value":80}`)
+	request(t, http.MethodDelete, srv.URL+"/hive/app", "")
+
+	events := readEvents(t, stream, 3)
+	if events[0].event != "sub" || events[0].data.Key != "app" {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+	if events[1].event != "set" || events[1].id != "3" {
+		t.Fatalf("unexpected event %+v", events[1])
+	}
+	if n, _ := events[1].data.Value.Int(); n != 80 {
+		t.Fatalf("unexpected value %v", events[1].data.Value.Value())
+	}
+	if events[2].event != "delete" {
+		t.Fatalf("unexpected event %+v", events[2])
+	}
+
+	// A reconnecting client gets the changes it missed.
+	resp := request(t, http.MethodGet, srv.URL+"/hive/other", "")
+	if resp.Header.Get("X-Hive-Revision") != "4" {
+		t.Fatalf("revision is %q, expected 4", resp.Header.Get("X-Hive-Revision"))
+	}
+	stream = watch(t, srv.URL+"/watch/", "1")
+	events = readEvents(t, stream, 3)
+	if events[0].id != "2" || events[0].data.Key != "other" {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+}
+
+func TestHiveHandlerWatchParent(t *testing.T) {
+	srv, _ := newHiveServer(t)
+	stream := watch(t, srv.URL+"/watch/license/foo", "")
+
+	// The watchers of a key only get its value when a parent is set, not the ones of its siblings.
+	request(t, http.MethodPut, srv.URL+"/hive/license", `{"type":"sub","value":{"foo":{"type":"int","value":1},"bar":{"type":"string","value":"secret"}}}`)
+	request(t, http.MethodPut, srv.URL+"/hive/license", `{"type":"sub","value":{"bar":{"type":"string","value":"secret"}}}`)
+
+	events := readEvents(t, stream, 2)
+	if n, _ := events[0].data.Value.Int(); events[0].event != "set" || events[0].data.Key != "license/foo" || n != 1 {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+	if events[1].event != "delete" || events[1].data.Key != "license/foo" || events[1].data.Value != nil {
+		t.Fatalf("unexpected event %+v", events[1])
+	}
+}
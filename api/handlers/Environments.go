@@ -0,0 +1,811 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Errors returned by Environments.
+var (
+	ErrEnvironmentNotFound  = errors.New("environment does not exist")
+	ErrInvalidEnvironment   = errors.New("invalid environment")
+	ErrEnvironmentProtected = errors.New("environment is protected, its changes must be promoted")
+	ErrPromotionNotFound    = errors.New("promotion does not exist")
+	ErrInvalidPromotion     = errors.New("invalid promotion")
+	ErrPromotionConflict    = errors.New("the target has changed since the promotion was requested")
+	ErrPromotionState       = errors.New("promotion cannot be changed in its state")
+	ErrSelfApproval         = errors.New("promotions must be approved by another user")
+)
+
+// promotionsKey The key of the promotions in the user store hive. It is not a user name, so it never clashes with users.
+const promotionsKey = "_promotions"
+
+// environmentKey The context key of the environment of a request, see Environments.serve.
+const environmentKey = "handlers.environment"
+
+// environmentNameRe The environment names, which are also part of the hive specs, see Settings.EnvironmentHives.
+var environmentNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// promotionIDRe The promotion ids, 8 random bytes in hex.
+var promotionIDRe = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// The states of a promotion. A pending promotion is either applied or rejected, and an applied one can be rolled back.
+const (
+	PromotionPending    = "pending"
+	PromotionApplied    = "applied"
+	PromotionRejected   = "rejected"
+	PromotionRolledBack = "rolled_back"
+)
+
+// Environment A named environment, e.g. dev, staging or prod, with its own hive.
+type Environment struct {
+	Name string `json:"name"`
+	// Protected The environment is only changed by promotions, and by the admins.
+	Protected bool `json:"protected"`
+	// Revision The revision of the last change of the environment hive, see ChangeFeed.
+	Revision uint64 `json:"revision"`
+	// Keys The values of the environment hive, see cfghive.HiveSize.
+	Keys int `json:"keys"`
+}
+
+// PromotionChange A key which differs between two environments.
+type PromotionChange struct {
+	Key string `json:"key"`
+	// Old The value in the target, nil if the key is not set there.
+	Old *cfghive.HiveValue `json:"old,omitempty"`
+	// New The value in the source, nil if the key is deleted from the target.
+	New *cfghive.HiveValue `json:"new,omitempty"`
+}
+
+// Promotion A request to copy the values of a key of an environment to another one,
+// applied once approved by another user.
+type Promotion struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Key The key promoted, "" being the whole hive.
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	// Changes The diff from the target to the source when the promotion was requested.
+	// The promotion is only applied if the target has not changed since.
+	Changes     []PromotionChange `json:"changes"`
+	RequestedBy string            `json:"requested_by"`
+	Requested   time.Time         `json:"requested"`
+	// ReviewedBy The user who approved or rejected the promotion.
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	Reviewed   *time.Time `json:"reviewed,omitempty"`
+	// Revision The revision of the target once the promotion was applied, or rolled back, see ChangeFeed.
+	Revision     uint64     `json:"revision,omitempty"`
+	RolledBackBy string     `json:"rolled_back_by,omitempty"`
+	RolledBack   *time.Time `json:"rolled_back,omitempty"`
+}
+
+// environment An environment being served.
+type environment struct {
+	name      string
+	protected bool
+	handler   *HiveHandler
+	routes    []hiveRoute
+}
+
+// info Gets an environment with its current revision and number of values.
+func (env *environment) info() Environment {
+	return Environment{
+		Name:      env.name,
+		Protected: env.protected,
+		Revision:  env.handler.feed.Revision(),
+		Keys:      int(cfghive.HiveSize(*env.handler.hive.GetData())),
+	}
+}
+
+// Environments Serves named environments, each with its own hive, and promotes the changes
+// of an environment to another one once approved:
+//
+//	GET  /environments                   Lists the environments, as {"environments"}.
+//	GET  /environments/:environment      Gets an environment.
+//	GET  /environments/:environment/diff Gets the changes promoting ?from to the environment, as {"changes"}.
+//	                                     ?key only compares a key and its children.
+//	/environments/:environment/hive/*path, /export, /import, /commit,
+//	/rollback, /save, /watch/*path       The environment hive, see HiveHandler and ACL.
+//
+//	GET  /promotions                     Lists the promotions, newest first, as {"promotions"}.
+//	                                     ?environment (the target) and ?status select them.
+//	POST /promotions                     Requests the promotion of {"from", "to", "key"}, with the diff
+//	                                     of the environments. Needs write access on the key.
+//	GET  /promotions/:id                 Gets a promotion.
+//	POST /promotions/:id/approve         Applies a pending promotion, all or nothing. Admin only,
+//	                                     and not by the user who requested it.
+//	POST /promotions/:id/reject          Rejects a pending promotion. Admin only, or the user who requested it.
+//	POST /promotions/:id/rollback        Restores the values an applied promotion changed. Admin only.
+//
+// A promotion is only applied or rolled back if the keys it changes still have the values
+// it was requested or applied with, else it is a 409 and a new promotion must be requested.
+// The roles of the users apply to the keys of every environment, see ACL, and the users only
+// see the promotions of keys they can read. Only the admins change the protected environments
+// directly, the other users request promotions.
+//
+// The promotions are stored in the user store of the main hive, along the users.
+type Environments struct {
+	registry *UserStore
+	acl      *ACL
+	// Audit Records the changes of the environment hives, with the environment name, if not nil. Set it before Add.
+	Audit *cfghive.AuditLog
+	// Limiter Limits the size of the values of the environment hives, if not nil. Set it before Add.
+	Limiter *Limiter
+
+	// lock Is held while a promotion changes, so it is applied once.
+	lock sync.Mutex
+	// The environments are added before serving, and never removed.
+	envs map[string]*environment
+}
+
+// NewEnvironments Creates the environments, storing their promotions in registry.
+func NewEnvironments(registry *UserStore) *Environments {
+	return &Environments{registry: registry, envs: make(map[string]*environment)}
+}
+
+// CheckEnvironmentName Checks an environment name, which is part of the hive spec of the environment.
+func CheckEnvironmentName(name string) error {
+	if !environmentNameRe.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be 1 to 63 lowercase letters, digits or -", ErrInvalidEnvironment, name)
+	}
+	return nil
+}
+
+// Add Serves hive as an environment, which is locked for every request, see NewHiveHandler.
+// Only the admins change a protected environment directly.
+func (e *Environments) Add(name string, hive cfghive.Hive, protected bool) error {
+	err := CheckEnvironmentName(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := e.envs[name]; ok {
+		return fmt.Errorf("%w: %s is added twice", ErrInvalidEnvironment, name)
+	}
+	env := &environment{name: name, protected: protected, handler: NewHiveHandler(hive)}
+	env.handler.Audit = e.Audit
+	env.handler.Limiter = e.Limiter
+	env.handler.Environment = name
+	env.routes = env.handler.routes()
+	e.envs[name] = env
+	return nil
+}
+
+// CloseFeeds Ends the watch streams of the environments, which would hold the server shutdown, see ChangeFeed.Close.
+func (e *Environments) CloseFeeds() {
+	for _, env := range e.envs {
+		env.handler.Feed().Close()
+	}
+}
+
+// get Gets an environment.
+func (e *Environments) get(name string) (*environment, error) {
+	env, ok := e.envs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	return env, nil
+}
+
+// List Lists the environments, sorted by name.
+func (e *Environments) List() []Environment {
+	list := make([]Environment, 0, len(e.envs))
+	for _, env := range e.envs {
+		list = append(list, env.info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get Gets an environment.
+func (e *Environments) Get(name string) (*Environment, error) {
+	env, err := e.get(name)
+	if err != nil {
+		return nil, err
+	}
+	info := env.info()
+	return &info, nil
+}
+
+// snapshot Gets a copy of the value of key, nil if it does not exist, which is not changed with the hive.
+func (env *environment) snapshot(key string) (*cfghive.HiveValue, error) {
+	var b []byte
+	err := env.handler.hive.Do(func(hive cfghive.Hive) error {
+		v, err := lookup(hive, key)
+		if errors.Is(err, cfghive.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err = json.Marshal(v)
+		return err
+	})
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var v cfghive.HiveValue
+	err = json.Unmarshal(b, &v)
+	return &v, err
+}
+
+func joinKey(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+// diffValues Appends the changes setting the target value of key to the source one.
+// The sub-hives of both are compared key by key, any other difference replaces the target value.
+func diffValues(key string, source *cfghive.HiveValue, target *cfghive.HiveValue, changes []PromotionChange) []PromotionChange {
+	switch {
+	case source == nil && target == nil:
+		return changes
+	case source == nil || target == nil:
+		return append(changes, PromotionChange{Key: key, Old: target, New: source})
+	}
+	sourceSub, serr := source.Sub()
+	targetSub, terr := target.Sub()
+	if serr != nil || terr != nil {
+		if !source.Equal(target) {
+			changes = append(changes, PromotionChange{Key: key, Old: target, New: source})
+		}
+		return changes
+	}
+	names := make([]string, 0, len(sourceSub)+len(targetSub))
+	for name := range sourceSub {
+		names = append(names, name)
+	}
+	for name := range targetSub {
+		if _, ok := sourceSub[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var s, t *cfghive.HiveValue
+		if v, ok := sourceSub[name]; ok {
+			s = &v
+		}
+		if v, ok := targetSub[name]; ok {
+			t = &v
+		}
+		changes = diffValues(joinKey(key, name), s, t, changes)
+	}
+	return changes
+}
+
+// Diff Gets the changes setting key in the environment to to its value in from, sorted by key.
+func (e *Environments) Diff(from string, to string, key string) ([]PromotionChange, error) {
+	source, err := e.get(from)
+	if err != nil {
+		return nil, err
+	}
+	target, err := e.get(to)
+	if err != nil {
+		return nil, err
+	}
+	key = strings.Trim(key, "/")
+	s, err := source.snapshot(key)
+	if err != nil {
+		return nil, err
+	}
+	t, err := target.snapshot(key)
+	if err != nil {
+		return nil, err
+	}
+	return diffValues(key, s, t, []PromotionChange{}), nil
+}
+
+// readPromotion Reads a promotion of the registry, with the hive lock held.
+func readPromotion(hive cfghive.Hive, id string) (*Promotion, error) {
+	if !promotionIDRe.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrPromotionNotFound, id)
+	}
+	v, err := hive.Get(promotionsKey + "/" + id)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrPromotionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	p := &Promotion{}
+	err = json.Unmarshal([]byte(s), p)
+	return p, err
+}
+
+// writePromotion Writes a promotion to the registry, as JSON, with the hive lock held.
+func writePromotion(hive cfghive.Hive, p *Promotion) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if _, err := hive.Get(promotionsKey); err != nil {
+		hive.NewSub(promotionsKey)
+	}
+	return hive.Set(promotionsKey+"/"+p.ID, string(b))
+}
+
+// Promotions Lists the promotions to an environment, or all of them if environment is empty,
+// with a status, or any if status is empty. The newest are first.
+func (e *Environments) Promotions(environment string, status string) ([]Promotion, error) {
+	list := []Promotion{}
+	err := e.registry.hive.Do(func(hive cfghive.Hive) error {
+		v, err := hive.Get(promotionsKey)
+		if errors.Is(err, cfghive.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sub, err := v.Sub()
+		if err != nil {
+			return err
+		}
+		for id := range sub {
+			p, err := readPromotion(hive, id)
+			if err != nil {
+				return fmt.Errorf("promotion %s: %w", id, err)
+			}
+			if (environment == "" || p.To == environment) && (status == "" || p.Status == status) {
+				list = append(list, *p)
+			}
+		}
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Requested.Equal(list[j].Requested) {
+			return list[i].Requested.After(list[j].Requested)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, err
+}
+
+// Promotion Gets a promotion.
+func (e *Environments) Promotion(id string) (*Promotion, error) {
+	var p *Promotion
+	err := e.registry.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		p, err = readPromotion(hive, id)
+		return err
+	})
+	return p, err
+}
+
+// Request Requests the promotion of key from an environment to another one, by user.
+// The promotion holds the diff of the environments, which must not be empty.
+func (e *Environments) Request(user string, from string, to string, key string) (*Promotion, error) {
+	if from == to {
+		return nil, fmt.Errorf("%w: %s is promoted to itself", ErrInvalidPromotion, from)
+	}
+	key = strings.Trim(key, "/")
+	changes, err := e.Diff(from, to, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("%w: /%s is the same in %s and %s", ErrInvalidPromotion, key, from, to)
+	}
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &Promotion{
+		ID:          hex.EncodeToString(b),
+		From:        from,
+		To:          to,
+		Key:         key,
+		Status:      PromotionPending,
+		Changes:     changes,
+		RequestedBy: user,
+		Requested:   time.Now().UTC().Truncate(time.Second),
+	}
+	err = e.registry.commit(func(hive cfghive.Hive) error {
+		return writePromotion(hive, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// currentValue Gets the value of key, nil if it does not exist, with the hive lock held.
+func currentValue(hive cfghive.Hive, key string) (*cfghive.HiveValue, error) {
+	v, err := hive.Get(key)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return v, err
+}
+
+func sameValue(a *cfghive.HiveValue, b *cfghive.HiveValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
+
+// applyValues Sets the keys of changes from their expected value to their new one, all or nothing,
+// with the hive lock held. The keys must not have changed.
+func (h *HiveHandler) applyValues(hive cfghive.Hive, changes []PromotionChange, expected func(PromotionChange) *cfghive.HiveValue, value func(PromotionChange) *cfghive.HiveValue) ([]hiveChange, error) {
+	sets := make(map[string]*cfghive.HiveValue)
+	for _, change := range changes {
+		current, err := currentValue(hive, change.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !sameValue(current, expected(change)) {
+			return nil, fmt.Errorf("%w: /%s", ErrPromotionConflict, change.Key)
+		}
+		if v := value(change); v != nil {
+			err = h.checkValueSize(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", change.Key, err)
+			}
+			sets[change.Key] = v
+		}
+	}
+	err := h.checkKeys(hive, sets)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]hiveChange, 0, len(changes))
+	for _, change := range changes {
+		old, v := expected(change), value(change)
+		if v == nil {
+			hive.Delete(change.Key)
+			applied = append(applied, hiveChange{op: ChangeDelete, key: change.Key, old: old})
+			continue
+		}
+		if change.Key == "" {
+			err = fmt.Errorf("%w: the whole hive is not a value", cfghive.ErrInvalidKey)
+		} else {
+			err = hive.Set(change.Key, v.Value())
+		}
+		if err != nil {
+			undoChanges(hive, applied)
+			return nil, fmt.Errorf("%s: %w", change.Key, err)
+		}
+		applied = append(applied, hiveChange{op: ChangeSet, key: change.Key, old: old, value: v})
+	}
+	return applied, nil
+}
+
+// transition Changes a promotion from a status to another one, applying its changes to the target
+// with change, then recording them in the audit log. Nothing is changed if any of it fails, see recordChanges.
+func (e *Environments) transition(src changeSource, id string, from string, update func(p *Promotion) error,
+	change func(h *HiveHandler, hive cfghive.Hive, p *Promotion) ([]hiveChange, error)) (*Promotion, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	p, err := e.Promotion(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != from {
+		return nil, fmt.Errorf("%w: %s is %s", ErrPromotionState, p.ID, p.Status)
+	}
+	previous := *p
+	err = update(p)
+	if err != nil {
+		return nil, err
+	}
+	if change == nil {
+		err = e.registry.commit(func(hive cfghive.Hive) error {
+			return writePromotion(hive, p)
+		})
+		return p, err
+	}
+	target, err := e.get(p.To)
+	if err != nil {
+		return nil, err
+	}
+	err = target.handler.hive.Do(func(hive cfghive.Hive) error {
+		applied, err := change(target.handler, hive, p)
+		if err != nil {
+			return err
+		}
+		// The promotion records the revision of its last change, each change recorded being one, see ChangeFeed.Publish.
+		p.Revision = target.handler.feed.Revision() + uint64(len(applied))
+		err = e.registry.commit(func(registry cfghive.Hive) error {
+			return writePromotion(registry, p)
+		})
+		if err != nil {
+			undoChanges(hive, applied)
+			return err
+		}
+		err = target.handler.recordChanges(src, hive, applied)
+		if err != nil {
+			// The changes are undone, so the promotion is not either.
+			e.registry.commit(func(registry cfghive.Hive) error {
+				return writePromotion(registry, &previous)
+			})
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Approve Applies a pending promotion to its target, approved by the user of src, who must not be the one
+// who requested it. The changes are recorded in the audit log with src.
+func (e *Environments) Approve(src changeSource, id string) (*Promotion, error) {
+	return e.transition(src, id, PromotionPending, func(p *Promotion) error {
+		if p.RequestedBy == src.user {
+			return fmt.Errorf("%w: %s requested %s", ErrSelfApproval, src.user, p.ID)
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		p.Status, p.ReviewedBy, p.Reviewed = PromotionApplied, src.user, &now
+		return nil
+	}, func(h *HiveHandler, hive cfghive.Hive, p *Promotion) ([]hiveChange, error) {
+		return h.applyValues(hive, p.Changes, func(c PromotionChange) *cfghive.HiveValue {
+			return c.Old
+		}, func(c PromotionChange) *cfghive.HiveValue {
+			return c.New
+		})
+	})
+}
+
+// Reject Rejects a pending promotion, by the user of src.
+func (e *Environments) Reject(src changeSource, id string) (*Promotion, error) {
+	return e.transition(src, id, PromotionPending, func(p *Promotion) error {
+		now := time.Now().UTC().Truncate(time.Second)
+		p.Status, p.ReviewedBy, p.Reviewed = PromotionRejected, src.user, &now
+		return nil
+	}, nil)
+}
+
+// Rollback Restores the values of the target an applied promotion changed, by the user of src.
+// The changes are recorded in the audit log with src.
+func (e *Environments) Rollback(src changeSource, id string) (*Promotion, error) {
+	return e.transition(src, id, PromotionApplied, func(p *Promotion) error {
+		now := time.Now().UTC().Truncate(time.Second)
+		p.Status, p.RolledBackBy, p.RolledBack = PromotionRolledBack, src.user, &now
+		return nil
+	}, func(h *HiveHandler, hive cfghive.Hive, p *Promotion) ([]hiveChange, error) {
+		return h.applyValues(hive, p.Changes, func(c PromotionChange) *cfghive.HiveValue {
+			return c.New
+		}, func(c PromotionChange) *cfghive.HiveValue {
+			return c.Old
+		})
+	})
+}
+
+// Register Adds the environment and promotion routes to r, which must authenticate the principal.
+// The environment hives are authorized by acl.
+func (e *Environments) Register(r gin.IRouter, acl *ACL) {
+	e.acl = acl
+	envs := r.Group("/environments")
+	envs.GET("", e.list)
+	envs.GET("/:environment", e.getEnvironment)
+	envs.GET("/:environment/diff", e.diff)
+	// Every environment is served by the same routes, calling the route of its handler.
+	hive := envs.Group("/:environment", e.serve, acl.Middleware())
+	for i, route := range (&HiveHandler{}).routes() {
+		i := i
+		hive.Handle(route.method, route.path, func(c *gin.Context) {
+			c.MustGet(environmentKey).(*environment).routes[i].handler(c)
+		})
+	}
+
+	promotions := r.Group("/promotions")
+	promotions.GET("", e.listPromotions)
+	promotions.POST("", e.request)
+	promotions.GET("/:id", e.getPromotion)
+	promotions.POST("/:id/approve", RequireAdmin(), e.approve)
+	promotions.POST("/:id/reject", e.reject)
+	promotions.POST("/:id/rollback", RequireAdmin(), e.rollback)
+}
+
+// serve Sets the environment of a hive request, and rejects the changes of the protected environments by non admins.
+func (e *Environments) serve(c *gin.Context) {
+	env, err := e.get(c.Param("environment"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	if p := CurrentPrincipal(c); env.protected && (p == nil || !p.Admin) {
+		if _, access, _ := required(c); access > AccessRead {
+			abortWithEnvironmentError(c, fmt.Errorf("%w: %s", ErrEnvironmentProtected, env.name))
+			return
+		}
+	}
+	c.Set(environmentKey, env)
+}
+
+// canAccess Reports whether the principal of a request has an access on key, see ACL.Access.
+func (e *Environments) canAccess(c *gin.Context, key string, access Access) (bool, error) {
+	p := CurrentPrincipal(c)
+	if p == nil {
+		return false, nil
+	}
+	if p.Admin {
+		return true, nil
+	}
+	has, err := e.acl.Access(p.Name, key)
+	return has >= access, err
+}
+
+// requireAccess Aborts the request if its principal has no access on key, see canAccess.
+func (e *Environments) requireAccess(c *gin.Context, key string, access Access) bool {
+	ok, err := e.canAccess(c, key, access)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return false
+	}
+	if !ok {
+		abortWithError(c, http.StatusForbidden, fmt.Errorf("%s access required on /%s", access, key))
+		return false
+	}
+	return true
+}
+
+func environmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidEnvironment), errors.Is(err, ErrInvalidPromotion):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrEnvironmentNotFound), errors.Is(err, ErrPromotionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPromotionConflict), errors.Is(err, ErrPromotionState):
+		return http.StatusConflict
+	case errors.Is(err, ErrEnvironmentProtected), errors.Is(err, ErrSelfApproval):
+		return http.StatusForbidden
+	}
+	return hiveErrorStatus(err)
+}
+
+func abortWithEnvironmentError(c *gin.Context, err error) {
+	abortWithError(c, environmentErrorStatus(err), err)
+}
+
+func (e *Environments) list(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"environments": e.List()})
+}
+
+func (e *Environments) getEnvironment(c *gin.Context) {
+	info, err := e.Get(c.Param("environment"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func (e *Environments) diff(c *gin.Context) {
+	key := strings.Trim(c.Query("key"), "/")
+	if !e.requireAccess(c, key, AccessRead) {
+		return
+	}
+	from := c.Query("from")
+	if from == "" {
+		abortWithEnvironmentError(c, fmt.Errorf("%w: from is required", ErrInvalidPromotion))
+		return
+	}
+	changes, err := e.Diff(from, c.Param("environment"), key)
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+func (e *Environments) listPromotions(c *gin.Context) {
+	list, err := e.Promotions(c.Query("environment"), c.Query("status"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	visible := make([]Promotion, 0, len(list))
+	for _, p := range list {
+		ok, err := e.canAccess(c, p.Key, AccessRead)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if ok {
+			visible = append(visible, p)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": visible})
+}
+
+func (e *Environments) request(c *gin.Context) {
+	var body struct {
+		From string `json:"from" binding:"required"`
+		To   string `json:"to" binding:"required"`
+		Key  string `json:"key"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if !e.requireAccess(c, strings.Trim(body.Key, "/"), AccessWrite) {
+		return
+	}
+	p, err := e.Request(CurrentPrincipal(c).Name, body.From, body.To, body.Key)
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	Logger(c).Info("promotion requested", "id", p.ID, "from", p.From, "to", p.To, "key", p.Key, "changes", len(p.Changes))
+	c.JSON(http.StatusCreated, p)
+}
+
+func (e *Environments) getPromotion(c *gin.Context) {
+	p, err := e.Promotion(c.Param("id"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	if !e.requireAccess(c, p.Key, AccessRead) {
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func (e *Environments) approve(c *gin.Context) {
+	p, err := e.Approve(ginSource(c), c.Param("id"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	Logger(c).Info("promotion applied", "id", p.ID, "to", p.To, "revision", p.Revision)
+	c.JSON(http.StatusOK, p)
+}
+
+func (e *Environments) reject(c *gin.Context) {
+	id := c.Param("id")
+	principal := CurrentPrincipal(c)
+	if !principal.Admin {
+		p, err := e.Promotion(id)
+		if err != nil {
+			abortWithEnvironmentError(c, err)
+			return
+		}
+		if p.RequestedBy != principal.Name {
+			abortWithError(c, http.StatusForbidden, fmt.Errorf("only the admins and %s can reject %s", p.RequestedBy, id))
+			return
+		}
+	}
+	p, err := e.Reject(ginSource(c), id)
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	Logger(c).Info("promotion rejected", "id", p.ID)
+	c.JSON(http.StatusOK, p)
+}
+
+func (e *Environments) rollback(c *gin.Context) {
+	p, err := e.Rollback(ginSource(c), c.Param("id"))
+	if err != nil {
+		abortWithEnvironmentError(c, err)
+		return
+	}
+	Logger(c).Info("promotion rolled back", "id", p.ID, "to", p.To, "revision", p.Revision)
+	c.JSON(http.StatusOK, p)
+}
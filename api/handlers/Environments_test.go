@@ -0,0 +1,162 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func newEnvironmentsServer(t *testing.T, environments *handlers.Environments, store *handlers.UserStore) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	auth := handlers.NewAuth(store, testSecret)
+	engine := gin.New()
+	authorized := engine.Group("/", auth.Middleware())
+	acl := handlers.NewACL(store)
+	acl.Register(authorized)
+	environments.Register(authorized, acl)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEnvironments(t *testing.T) {
+	store := newUserStore(t)
+	audit, err := cfghive.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	environments := handlers.NewEnvironments(store)
+	environments.Audit = audit
+	for _, name := range []string{"dev", "prod"} {
+		hive, _ := cfghive.NewMemHive()
+		if err := environments.Add(name, hive, name == "prod"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hive, _ := cfghive.NewMemHive()
+	if err := environments.Add("Prod", hive, false); err == nil {
+		t.Fatal("an invalid name is added")
+	}
+	srv := newEnvironmentsServer(t, environments, store)
+
+	var promotion handlers.Promotion
+	step := func(user string, method string, path string, body string, status int) {
+		t.Helper()
+		resp := userRequest(t, srv, user, method, path, body)
+		if resp.StatusCode != status {
+			t.Fatalf("%s %s %s is %d, expected %d", user, method, path, resp.StatusCode, status)
+		}
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			promotion = handlers.Promotion{}
+			json.NewDecoder(resp.Body).Decode(&promotion)
+		}
+	}
+
+	// The environment hives, with the roles of the users, and only changed by the admins if protected.
+	step("admin", http.MethodPut, "/acl/roles/app", `{"rules": [{"path": "app/**", "access": "write"}]}`, http.StatusOK)
+	step("admin", http.MethodPut, "/acl/users/alice", `{"roles": ["app"]}`, http.StatusOK)
+	step("admin", http.MethodPost, "/environments/dev/hive/app", "", http.StatusCreated)
+	step("alice", http.MethodPut, "/environments/dev/hive/app/port", `{"type": "int", "value": 8080}`, http.StatusNoContent)
+	step("alice", http.MethodPut, "/environments/dev/hive/app/host", `{"type": "string", "value": "db"}`, http.StatusNoContent)
+	step("alice", http.MethodPut, "/environments/dev/hive/db", `{"type": "string", "value": "db"}`, http.StatusForbidden)
+	step("alice", http.MethodPut, "/environments/prod/hive/app/port", `{"type": "int", "value": 8080}`, http.StatusForbidden)
+	step("alice", http.MethodGet, "/environments/prod/hive/app", "", http.StatusNotFound)
+	step("alice", http.MethodGet, "/environments/qa/hive/app", "", http.StatusNotFound)
+	step("admin", http.MethodPut, "/environments/prod/hive/db", `{"type": "string", "value": "prod-db"}`, http.StatusNoContent)
+
+	changes, err := environments.Diff("dev", "prod", "")
+	if err != nil || len(changes) != 2 || changes[0].Key != "app" || changes[0].Old != nil || changes[1].Key != "db" || changes[1].New != nil {
+		t.Fatalf("unexpected diff %+v, %v", changes, err)
+	}
+	step("alice", http.MethodGet, "/environments/prod/diff?from=dev&key=app", "", http.StatusOK)
+	step("alice", http.MethodGet, "/environments/prod/diff?from=dev", "", http.StatusForbidden)
+
+	// A promotion is applied once approved by another admin.
+	step("alice", http.MethodPost, "/promotions", `{"from": "dev", "to": "dev", "key": "app"}`, http.StatusBadRequest)
+	step("alice", http.MethodPost, "/promotions", `{"from": "dev", "to": "prod", "key": "db"}`, http.StatusForbidden)
+	step("alice", http.MethodPost, "/promotions", `{"from": "dev", "to": "prod", "key": "app"}`, http.StatusCreated)
+	applied := promotion.ID
+	if promotion.Status != handlers.PromotionPending || promotion.RequestedBy != "alice" || len(promotion.Changes) != 1 {
+		t.Fatalf("unexpected promotion %+v", promotion)
+	}
+	step("alice", http.MethodPost, "/promotions/"+applied+"/approve", "", http.StatusForbidden)
+	step("admin", http.MethodPost, "/promotions/"+applied+"/approve", "", http.StatusOK)
+	if promotion.Status != handlers.PromotionApplied || promotion.ReviewedBy != "admin" || promotion.Revision != 2 {
+		t.Fatalf("unexpected promotion %+v", promotion)
+	}
+	step("admin", http.MethodPost, "/promotions/"+applied+"/approve", "", http.StatusConflict)
+	step("alice", http.MethodGet, "/environments/prod/hive/app/port", "", http.StatusOK)
+
+	// It is not applied over the changes made since it was requested.
+	step("alice", http.MethodPut, "/environments/dev/hive/app/port", `{"type": "int", "value": 9090}`, http.StatusNoContent)
+	step("alice", http.MethodPost, "/promotions", `{"from": "dev", "to": "prod", "key": "app"}`, http.StatusCreated)
+	conflicting := promotion.ID
+	if len(promotion.Changes) != 1 || promotion.Changes[0].Key != "app/port" {
+		t.Fatalf("unexpected promotion %+v", promotion)
+	}
+	step("admin", http.MethodPut, "/environments/prod/hive/app/port", `{"type": "int", "value": 1}`, http.StatusNoContent)
+	step("admin", http.MethodPost, "/promotions/"+conflicting+"/approve", "", http.StatusConflict)
+	step("alice", http.MethodPost, "/promotions/"+conflicting+"/reject", "", http.StatusOK)
+	if promotion.Status != handlers.PromotionRejected || promotion.ReviewedBy != "alice" {
+		t.Fatalf("unexpected promotion %+v", promotion)
+	}
+
+	// Nor rolled back.
+	step("admin", http.MethodPost, "/promotions/"+applied+"/rollback", "", http.StatusConflict)
+	step("admin", http.MethodPut, "/environments/prod/hive/app/port", `{"type": "int", "value": 8080}`, http.StatusNoContent)
+	step("alice", http.MethodPost, "/promotions/"+applied+"/rollback", "", http.StatusForbidden)
+	step("admin", http.MethodPost, "/promotions/"+applied+"/rollback", "", http.StatusOK)
+	if promotion.Status != handlers.PromotionRolledBack || promotion.RolledBackBy != "admin" {
+		t.Fatalf("unexpected promotion %+v", promotion)
+	}
+	step("alice", http.MethodGet, "/environments/prod/hive/app", "", http.StatusNotFound)
+	step("admin", http.MethodGet, "/environments/prod/hive/db", "", http.StatusOK)
+
+	// Nobody approves their own promotions.
+	step("admin", http.MethodPost, "/promotions", `{"from": "dev", "to": "prod", "key": "app"}`, http.StatusCreated)
+	step("admin", http.MethodPost, "/promotions/"+promotion.ID+"/approve", "", http.StatusForbidden)
+	step("alice", http.MethodPost, "/promotions/"+promotion.ID+"/reject", "", http.StatusForbidden)
+	step("alice", http.MethodGet, "/promotions/0123456789abcdef", "", http.StatusNotFound)
+
+	pending, err := environments.Promotions("prod", handlers.PromotionPending)
+	if err != nil || len(pending) != 1 || pending[0].RequestedBy != "admin" {
+		t.Fatalf("unexpected promotions %+v, %v", pending, err)
+	}
+	all, err := environments.Promotions("", "")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("unexpected promotions %+v, %v", all, err)
+	}
+	info, err := environments.Get("prod")
+	if err != nil || !info.Protected || info.Keys != 1 {
+		t.Fatalf("unexpected environment %+v, %v", info, err)
+	}
+
+	// The changes are audited with their environment.
+	entries, err := audit.Query(cfghive.AuditQuery{Environment: "prod", Key: "app"})
+	if err != nil || len(entries) != 4 || entries[0].User != "admin" || entries[3].Op != handlers.ChangeDelete {
+		t.Fatalf("unexpected entries %+v, %v", entries, err)
+	}
+
+	// Nor applied, nor published, if its changes cannot be audited.
+	if _, err := store.Create("carol", "carol-password", true); err != nil {
+		t.Fatal(err)
+	}
+	audit.Close()
+	step("carol", http.MethodPost, "/promotions/"+pending[0].ID+"/approve", "", http.StatusInternalServerError)
+	step("alice", http.MethodGet, "/environments/prod/hive/app", "", http.StatusNotFound)
+	after, err := environments.Get("prod")
+	if err != nil || after.Revision != info.Revision {
+		t.Fatalf("unexpected environment %+v, %v", after, err)
+	}
+	p, err := environments.Promotion(pending[0].ID)
+	if err != nil || p.Status != handlers.PromotionPending {
+		t.Fatalf("unexpected promotion %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/hivepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// HiveService Serves the hive of a HiveHandler over gRPC, see hivepb.HiveService, with the
+// users of an Auth, the roles of an ACL, and the limits, audit log and change feed of the handler.
+// The revisions are the ones of the handler feed, as the /hive ETags and the /watch event ids.
+type HiveService struct {
+	hivepb.UnimplementedHiveServiceServer
+	handler *HiveHandler
+	auth    *Auth
+	acl     *ACL
+}
+
+// NewHiveService Creates a service serving the hive of handler.
+func NewHiveService(handler *HiveHandler, auth *Auth, acl *ACL) *HiveService {
+	return &HiveService{handler: handler, auth: auth, acl: acl}
+}
+
+// ServerOptions Gets the options of the gRPC servers of the service. Their interceptors
+// authenticate, rate limit and log every call, as the gin middlewares do for the routes.
+func (s *HiveService) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unary),
+		grpc.StreamInterceptor(s.stream),
+	}
+}
+
+// Register Adds the service to srv, which must be created with ServerOptions.
+func (s *HiveService) Register(srv *grpc.Server) {
+	hivepb.RegisterHiveServiceServer(srv, s)
+}
+
+// grpcCall Who makes a call and from where, set by the interceptors.
+type grpcCall struct {
+	principal *Principal
+	requestID string
+	client    string
+	tenant    string
+}
+
+type grpcCallKey struct{}
+
+// currentCall Gets the call of a context, set by the interceptors.
+func currentCall(ctx context.Context) *grpcCall {
+	call, _ := ctx.Value(grpcCallKey{}).(*grpcCall)
+	return call
+}
+
+// logger Gets the default logger, with the request id, the tenant and the principal name of the call.
+func (call *grpcCall) logger() *slog.Logger {
+	logger := slog.Default().With("request_id", call.requestID)
+	if call.tenant != "" {
+		logger = logger.With("tenant", call.tenant)
+	}
+	if call.principal != nil {
+		logger = logger.With("user", call.principal.Name)
+	}
+	return logger
+}
+
+// source Gets the source of the changes made by the call.
+func (call *grpcCall) source() changeSource {
+	return changeSource{client: call.client, requestID: call.requestID, user: call.principal.Name, logger: call.logger()}
+}
+
+// firstMetadata Gets the first value of a metadata key, empty if there is none.
+func firstMetadata(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// rateLimited Gets the error of a call over a rate limit, telling the client to retry after wait.
+func rateLimited(wait time.Duration) error {
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", int(math.Ceil(wait.Seconds())))
+}
+
+// begin Authenticates and rate limits a call, as the request id, Limiter and Auth middlewares do.
+// The call is returned even if it is rejected, to log it.
+func (s *HiveService) begin(ctx context.Context) (context.Context, *grpcCall, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	call := &grpcCall{requestID: firstMetadata(md, "x-request-id"), tenant: s.handler.Tenant}
+	if !requestIDRe.MatchString(call.requestID) {
+		call.requestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", call.requestID))
+	creds := Credentials{Authorization: firstMetadata(md, "authorization"), APIKey: firstMetadata(md, "x-api-key")}
+	if p, ok := peer.FromContext(ctx); ok {
+		call.client = p.Addr.String()
+		if host, _, err := net.SplitHostPort(call.client); err == nil {
+			call.client = host
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			creds.TLS = &info.State
+		}
+	}
+	limiter := s.handler.Limiter
+	if limiter != nil {
+		if ok, wait := limiter.allowIP(call.client); !ok {
+			return ctx, call, rateLimited(wait)
+		}
+	}
+	p, err := s.auth.Authenticate(creds)
+	if p == nil && err == nil {
+		err = fmt.Errorf("%w: authentication required", ErrBadCredentials)
+	}
+	if errors.Is(err, ErrBadCredentials) {
+		return ctx, call, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return ctx, call, status.Error(codes.Internal, err.Error())
+	}
+	call.principal = p
+	if limiter != nil {
+		if ok, wait := limiter.allowKey(p.Name); !ok {
+			return ctx, call, rateLimited(wait)
+		}
+	}
+	return context.WithValue(ctx, grpcCallKey{}, call), call, nil
+}
+
+// logCall Logs a call once served, as RequestLogger does for the requests,
+// at the error level for server errors and at the warning level for client errors.
+func logCall(ctx context.Context, call *grpcCall, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unimplemented:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	attrs := []interface{}{
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+		"client", call.client,
+	}
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+	call.logger().Log(ctx, level, "grpc call", attrs...)
+}
+
+func (s *HiveService) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, call, err := s.begin(ctx)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	logCall(ctx, call, info.FullMethod, start, err)
+	return resp, err
+}
+
+// callStream A server stream with the context of its call.
+type callStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *callStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *HiveService) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, call, err := s.begin(ss.Context())
+	if err == nil {
+		err = handler(srv, &callStream{ServerStream: ss, ctx: ctx})
+	}
+	logCall(ctx, call, info.FullMethod, start, err)
+	return err
+}
+
+// grpcError Maps a hive error to a gRPC status, as hiveErrorStatus does to a response status.
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, cfghive.ErrInvalidKey), errors.Is(err, cfghive.ErrInvalidType):
+		code = codes.InvalidArgument
+	case errors.Is(err, cfghive.ErrKeyNotFound):
+		code = codes.NotFound
+	case errors.Is(err, cfghive.ErrReadOnly), errors.Is(err, cfghive.ErrNotSubHive), errors.Is(err, errPreconditionFailed):
+		code = codes.FailedPrecondition
+	case errors.Is(err, errKeyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, errValueTooLarge), errors.Is(err, errQuotaExceeded):
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
+
+// authorize Checks the principal of a call has access on key, as ACL.Middleware does.
+func (s *HiveService) authorize(ctx context.Context, key string, access Access) error {
+	p := currentCall(ctx).principal
+	if p.Admin {
+		return nil
+	}
+	has, err := s.acl.Access(p.Name, key)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if has < access {
+		return status.Errorf(codes.PermissionDenied, "%s access required on /%s", access, key)
+	}
+	return nil
+}
+
+// grpcKey Gets the hive key of a request key, which may have leading and trailing slashes.
+func grpcKey(key string) string {
+	return strings.Trim(key, "/")
+}
+
+// requestValue Converts the value of a request, and checks its size.
+func (s *HiveService) requestValue(v *hivepb.Value) (*cfghive.HiveValue, error) {
+	value, err := v.HiveValue()
+	if err != nil {
+		return nil, err
+	}
+	return &value, s.handler.checkValueSize(&value)
+}
+
+// checkRevision Checks a key is at revision, if not 0, and does not exist if absent,
+// before changing it, with the hive lock held.
+func (s *HiveService) checkRevision(hive cfghive.Hive, key string, revision uint64, absent bool) error {
+	if revision == 0 && !absent {
+		return nil
+	}
+	_, err := hive.Get(key)
+	if err != nil && !errors.Is(err, cfghive.ErrKeyNotFound) {
+		return err
+	}
+	exists := err == nil
+	if absent && exists {
+		return fmt.Errorf("key %s exists: %w", key, errPreconditionFailed)
+	}
+	if revision != 0 && (!exists || s.handler.feed.KeyRevision(key) != revision) {
+		return fmt.Errorf("key %s has changed: %w", key, errPreconditionFailed)
+	}
+	return nil
+}
+
+// Get Gets a value, or the whole hive for the empty key.
+func (s *HiveService) Get(ctx context.Context, req *hivepb.GetRequest) (*hivepb.GetResponse, error) {
+	key := grpcKey(req.GetKey())
+	err := s.authorize(ctx, key, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	resp := &hivepb.GetResponse{}
+	var v *cfghive.HiveValue
+	err = s.handler.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		v, err = lookup(hive, key)
+		// Changes are published under the hive lock, so the revisions match the value.
+		resp.Revision = s.handler.feed.KeyRevision(key)
+		resp.HiveRevision = s.handler.feed.Revision()
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp.Value, err = hivepb.NewValue(v)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp, nil
+}
+
+// Set Sets a value.
+func (s *HiveService) Set(ctx context.Context, req *hivepb.SetRequest) (*hivepb.SetResponse, error) {
+	key := grpcKey(req.GetKey())
+	err := s.authorize(ctx, key, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.requestValue(req.GetValue())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &hivepb.SetResponse{}
+	err = s.handler.hive.Do(func(hive cfghive.Hive) error {
+		err := s.checkRevision(hive, key, req.GetIfRevision(), req.GetIfAbsent())
+		if err != nil {
+			return err
+		}
+		err = s.handler.checkKeys(hive, map[string]*cfghive.HiveValue{key: v})
+		if err != nil {
+			return err
+		}
+		old, _ := hive.Get(key)
+		err = hive.Set(key, v.Value())
+		if err != nil {
+			return err
+		}
+		err = s.handler.record(currentCall(ctx).source(), hive, ChangeSet, key, old, v)
+		if err != nil {
+			return err
+		}
+		resp.Revision = s.handler.feed.KeyRevision(key)
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp, nil
+}
+
+// Delete Deletes a value or a sub-hive.
+func (s *HiveService) Delete(ctx context.Context, req *hivepb.DeleteRequest) (*hivepb.DeleteResponse, error) {
+	key := grpcKey(req.GetKey())
+	err := s.authorize(ctx, key, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	err = s.handler.hive.Do(func(hive cfghive.Hive) error {
+		old, err := hive.Get(key)
+		if err != nil {
+			return err
+		}
+		err = s.checkRevision(hive, key, req.GetIfRevision(), false)
+		if err != nil {
+			return err
+		}
+		hive.Delete(key)
+		return s.handler.record(currentCall(ctx).source(), hive, ChangeDelete, key, old, nil)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &hivepb.DeleteResponse{}, nil
+}
+
+// List Lists the values of a sub-hive, sorted by name.
+func (s *HiveService) List(ctx context.Context, req *hivepb.ListRequest) (*hivepb.ListResponse, error) {
+	key := grpcKey(req.GetKey())
+	err := s.authorize(ctx, key, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	var v *cfghive.HiveValue
+	err = s.handler.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		v, err = lookup(hive, key)
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		return nil, grpcError(fmt.Errorf("%s %w", key, cfghive.ErrNotSubHive))
+	}
+	resp := &hivepb.ListResponse{Children: make([]*hivepb.Child, 0, len(sub))}
+	for name, child := range sub {
+		resp.Children = append(resp.Children, &hivepb.Child{Name: name, Type: child.TypeString()})
+	}
+	sort.Slice(resp.Children, func(i, j int) bool {
+		return resp.Children[i].Name < resp.Children[j].Name
+	})
+	return resp, nil
+}
+
+// Watch Streams the changes under a key, from the changes after the request revision if not 0.
+// The stream ends with UNAVAILABLE if the client is too slow or the server shuts down,
+// and the client resumes after the last revision it got.
+func (s *HiveService) Watch(req *hivepb.WatchRequest, stream hivepb.HiveService_WatchServer) error {
+	prefix := grpcKey(req.GetKey())
+	err := s.authorize(stream.Context(), prefix, AccessRead)
+	if err != nil {
+		return err
+	}
+	revision := req.GetRevision()
+	if revision == 0 {
+		revision = s.handler.feed.Revision()
+	}
+	missed, ch := s.handler.feed.Subscribe(revision)
+	defer s.handler.feed.Unsubscribe(ch)
+	send := func(change Change) error {
+		if !change.affects(prefix) {
+			return nil
+		}
+		change = change.within(prefix)
+		pc := &hivepb.Change{Revision: change.Revision, Op: change.Op, Key: change.Key, Time: timestamppb.New(change.Time)}
+		if change.Value != nil {
+			v, err := hivepb.NewValue(change.Value)
+			if err != nil {
+				return grpcError(err)
+			}
+			pc.Value = v
+		}
+		return stream.Send(pc)
+	}
+	for _, change := range missed {
+		err := send(change)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case change, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "watch ended, resume after the last revision")
+			}
+			err := send(change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// holds Reports whether a transaction condition holds, with the hive lock held.
+func (s *HiveService) holds(hive cfghive.Hive, cond *hivepb.Condition) (bool, error) {
+	key := grpcKey(cond.GetKey())
+	_, err := hive.Get(key)
+	if err != nil && !errors.Is(err, cfghive.ErrKeyNotFound) {
+		return false, err
+	}
+	exists := err == nil
+	switch check := cond.GetCheck().(type) {
+	case *hivepb.Condition_Revision:
+		return exists && s.handler.feed.KeyRevision(key) == check.Revision, nil
+	case *hivepb.Condition_Exists:
+		return exists == check.Exists, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "condition on %s has no check", key)
+}
+
+// apply Applies a transaction operation, with the hive lock held.
+func apply(hive cfghive.Hive, op *hivepb.Operation, value *cfghive.HiveValue) (hiveChange, error) {
+	switch o := op.GetOp().(type) {
+	case *hivepb.Operation_Set:
+		key := grpcKey(o.Set.GetKey())
+		old, _ := hive.Get(key)
+		return hiveChange{op: ChangeSet, key: key, old: old, value: value}, hive.Set(key, value.Value())
+	case *hivepb.Operation_Delete:
+		key := grpcKey(o.Delete)
+		old, err := hive.Get(key)
+		if err != nil {
+			return hiveChange{}, err
+		}
+		hive.Delete(key)
+		return hiveChange{op: ChangeDelete, key: key, old: old}, nil
+	case *hivepb.Operation_NewSub:
+		key := grpcKey(o.NewSub)
+		return hiveChange{op: ChangeNewSub, key: key}, createSub(hive, key)
+	}
+	return hiveChange{}, status.Error(codes.InvalidArgument, "empty operation")
+}
+
+// Txn Applies operations all at once, under the hive lock, if every condition holds.
+// The operations need the access of their routes: write to set and delete keys,
+// admin to create sub-hives. If one fails, the ones applied before are undone.
+func (s *HiveService) Txn(ctx context.Context, req *hivepb.TxnRequest) (*hivepb.TxnResponse, error) {
+	for _, cond := range req.GetConditions() {
+		err := s.authorize(ctx, grpcKey(cond.GetKey()), AccessRead)
+		if err != nil {
+			return nil, err
+		}
+	}
+	values := make([]*cfghive.HiveValue, len(req.GetOperations()))
+	sets := make(map[string]*cfghive.HiveValue)
+	for i, op := range req.GetOperations() {
+		var err error
+		switch o := op.GetOp().(type) {
+		case *hivepb.Operation_Set:
+			key := grpcKey(o.Set.GetKey())
+			err = s.authorize(ctx, key, AccessWrite)
+			if err == nil {
+				values[i], err = s.requestValue(o.Set.GetValue())
+				sets[key] = values[i]
+			}
+		case *hivepb.Operation_Delete:
+			err = s.authorize(ctx, grpcKey(o.Delete), AccessWrite)
+		case *hivepb.Operation_NewSub:
+			err = s.authorize(ctx, grpcKey(o.NewSub), AccessAdmin)
+		default:
+			err = status.Errorf(codes.InvalidArgument, "operation %d is empty", i)
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	resp := &hivepb.TxnResponse{}
+	err := s.handler.hive.Do(func(hive cfghive.Hive) error {
+		for _, cond := range req.GetConditions() {
+			ok, err := s.holds(hive, cond)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		err := s.handler.checkKeys(hive, sets)
+		if err != nil {
+			return err
+		}
+		changes := make([]hiveChange, 0, len(values))
+		for i, op := range req.GetOperations() {
+			change, err := apply(hive, op, values[i])
+			if err != nil {
+				undoChanges(hive, changes)
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+			changes = append(changes, change)
+		}
+		err = s.handler.recordChanges(currentCall(ctx).source(), hive, changes)
+		if err != nil {
+			return err
+		}
+		resp.Succeeded = true
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp.Revision = s.handler.feed.Revision()
+	return resp, nil
+}
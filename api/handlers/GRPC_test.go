@@ -0,0 +1,235 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+	"github.com/melanblack/potential-framework/hivepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCClient Serves the hive of handler over an in-process gRPC connection.
+func newGRPCClient(t *testing.T, handler *handlers.HiveHandler, store *handlers.UserStore) hivepb.HiveServiceClient {
+	service := handlers.NewHiveService(handler, handlers.NewAuth(store, testSecret), handlers.NewACL(store))
+	srv := grpc.NewServer(service.ServerOptions()...)
+	service.Register(srv)
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return hivepb.NewHiveServiceClient(conn)
+}
+
+// asUser Gets a context authenticating the calls as user, with the test password.
+func asUser(user string) context.Context {
+	creds := base64.StdEncoding.EncodeToString([]byte(user + ":" + user + "-password"))
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic "+creds)
+}
+
+func stringValue(s string) *hivepb.Value {
+	return &hivepb.Value{Kind: &hivepb.Value_StringValue{StringValue: s}}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("unexpected error %v, expected %s", err, code)
+	}
+}
+
+func TestHiveService(t *testing.T) {
+	hive, _ := cfghive.NewMemHive()
+	hive.NewSub("app")
+	handler := handlers.NewHiveHandler(hive)
+	store := newUserStore(t)
+	acl := handlers.NewACL(store)
+	if err := acl.PutRole(handlers.Role{Name: "reader", Rules: []handlers.Rule{{Path: "app/**", Access: handlers.AccessRead}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.SetUserRoles("alice", []string{"reader"}); err != nil {
+		t.Fatal(err)
+	}
+	client := newGRPCClient(t, handler, store)
+	admin := asUser("admin")
+
+	_, err := client.Get(context.Background(), &hivepb.GetRequest{Key: "app"})
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.Get(asUser("mallory"), &hivepb.GetRequest{Key: "app"})
+	expectCode(t, err, codes.Unauthenticated)
+
+	set, err := client.Set(admin, &hivepb.SetRequest{Key: "app/host", Value: stringValue("db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Set(admin, &hivepb.SetRequest{Key: "app/host", Value: stringValue("db2"), IfRevision: set.Revision + 1})
+	expectCode(t, err, codes.FailedPrecondition)
+	_, err = client.Set(admin, &hivepb.SetRequest{Key: "app/host", Value: stringValue("db2"), IfAbsent: true})
+	expectCode(t, err, codes.FailedPrecondition)
+	_, err = client.Set(admin, &hivepb.SetRequest{Key: "app/host/name", Value: stringValue("db")})
+	expectCode(t, err, codes.FailedPrecondition)
+	_, err = client.Set(admin, &hivepb.SetRequest{Key: "app/port"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	get, err := client.Get(asUser("alice"), &hivepb.GetRequest{Key: "/app/host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get.Value.GetStringValue() != "db" || get.Revision != set.Revision || get.HiveRevision != set.Revision {
+		t.Fatalf("unexpected value %v", get)
+	}
+	_, err = client.Get(asUser("alice"), &hivepb.GetRequest{Key: "app/port"})
+	expectCode(t, err, codes.NotFound)
+	_, err = client.Get(asUser("alice"), &hivepb.GetRequest{})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.Set(asUser("alice"), &hivepb.SetRequest{Key: "app/host", Value: stringValue("db2")})
+	expectCode(t, err, codes.PermissionDenied)
+
+	list, err := client.List(admin, &hivepb.ListRequest{Key: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Children) != 1 || list.Children[0].Name != "host" || list.Children[0].Type != "string" {
+		t.Fatalf("unexpected children %v", list.Children)
+	}
+	_, err = client.List(admin, &hivepb.ListRequest{Key: "app/host"})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	if _, err := client.Delete(admin, &hivepb.DeleteRequest{Key: "app/host", IfRevision: set.Revision}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Delete(admin, &hivepb.DeleteRequest{Key: "app/host"})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestHiveServiceTxn(t *testing.T) {
+	hive, _ := cfghive.NewMemHive()
+	hive.Set("port", 8080)
+	handler := handlers.NewHiveHandler(hive)
+	client := newGRPCClient(t, handler, newUserStore(t))
+	admin := asUser("admin")
+
+	txn := &hivepb.TxnRequest{
+		Conditions: []*hivepb.Condition{{Key: "db", Check: &hivepb.Condition_Exists{Exists: false}}},
+		Operations: []*hivepb.Operation{
+			{Op: &hivepb.Operation_NewSub{NewSub: "db"}},
+			{Op: &hivepb.Operation_Set{Set: &hivepb.Put{Key: "db/host", Value: stringValue("db")}}},
+			{Op: &hivepb.Operation_Delete{Delete: "port"}},
+		},
+	}
+	resp, err := client.Txn(admin, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Succeeded || resp.Revision != 3 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if v, err := hive.Get("db/host"); err != nil || v.Value() != "db" {
+		t.Fatalf("unexpected value %v, %v", v, err)
+	}
+
+	// The conditions no longer hold.
+	resp, err = client.Txn(admin, txn)
+	if err != nil || resp.Succeeded || resp.Revision != 3 {
+		t.Fatalf("unexpected response %v, %v", resp, err)
+	}
+
+	// A failed operation undoes the ones before.
+	_, err = client.Txn(admin, &hivepb.TxnRequest{
+		Conditions: []*hivepb.Condition{{Key: "db/host", Check: &hivepb.Condition_Revision{Revision: 2}}},
+		Operations: []*hivepb.Operation{
+			{Op: &hivepb.Operation_Set{Set: &hivepb.Put{Key: "db/host", Value: stringValue("db2")}}},
+			{Op: &hivepb.Operation_Delete{Delete: "port"}},
+		},
+	})
+	expectCode(t, err, codes.NotFound)
+	if v, err := hive.Get("db/host"); err != nil || v.Value() != "db" {
+		t.Fatalf("unexpected value %v, %v", v, err)
+	}
+	if handler.Feed().Revision() != 3 {
+		t.Fatalf("unexpected revision %d", handler.Feed().Revision())
+	}
+
+	// Creating sub-hives needs the admin access.
+	_, err = client.Txn(asUser("alice"), &hivepb.TxnRequest{Operations: []*hivepb.Operation{{Op: &hivepb.Operation_NewSub{NewSub: "cache"}}}})
+	expectCode(t, err, codes.PermissionDenied)
+}
+
+func TestHiveServiceWatch(t *testing.T) {
+	hive, _ := cfghive.NewMemHive()
+	hive.NewSub("app")
+	handler := handlers.NewHiveHandler(hive)
+	client := newGRPCClient(t, handler, newUserStore(t))
+	admin := asUser("admin")
+	if _, err := client.Set(admin, &hivepb.SetRequest{Key: "app/host", Value: stringValue("db")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(admin, 5*time.Second)
+	defer cancel()
+	// Resuming from the first change gets the ones after it, then the ones to come.
+	stream, err := client.Watch(ctx, &hivepb.WatchRequest{Key: "app", Revision: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"other", "app/port", "app/host"} {
+		if _, err := client.Set(admin, &hivepb.SetRequest{Key: key, Value: stringValue(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []struct {
+		revision uint64
+		key      string
+	}{{3, "app/port"}, {4, "app/host"}} {
+		change, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change.Revision != expected.revision || change.Op != handlers.ChangeSet || change.Key != expected.key || change.Value.GetStringValue() != expected.key {
+			t.Fatalf("unexpected change %v", change)
+		}
+	}
+
+	// The watchers of a key only get its value when a parent is set.
+	host, err := client.Watch(ctx, &hivepb.WatchRequest{Key: "app/host", Revision: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, _ := cfghive.NewHiveValue(map[string]interface{}{"host": "db2", "port": "secret"})
+	value, err := hivepb.NewValue(&app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Set(admin, &hivepb.SetRequest{Key: "app", Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	change, err := host.Recv()
+	if err != nil || change.Key != "app/host" || change.Value.GetStringValue() != "db2" {
+		t.Fatalf("unexpected change %v, %v", change, err)
+	}
+	if change, err := stream.Recv(); err != nil || change.Key != "app" || change.Value.GetSubValue() == nil {
+		t.Fatalf("unexpected change %v, %v", change, err)
+	}
+
+	// The stream ends when the feed is closed, e.g. on shutdown.
+	handler.Feed().Close()
+	_, err = stream.Recv()
+	expectCode(t, err, codes.Unavailable)
+}
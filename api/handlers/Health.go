@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Health Serves the liveness and the readiness of the api, for orchestrators:
+//
+//	GET /healthz  Always {"status": "ok"}, while the server answers.
+//	GET /readyz   Whether requests can be served, as {"status", "hives"}, with the error of
+//	              each hive that cannot be reached. 503 before SetReady, or if a hive fails.
+//
+// Both are public.
+type Health struct {
+	hives map[string]cfghive.Hive
+	ready atomic.Bool
+}
+
+// NewHealth Creates the health of a server using the hives, by name.
+// The hives are checked with cfghive.PingHive.
+func NewHealth(hives map[string]cfghive.Hive) *Health {
+	return &Health{hives: hives}
+}
+
+// SetReady Sets whether the server accepts requests, false while starting and shutting down.
+func (h *Health) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Register Adds the health routes to r.
+func (h *Health) Register(r gin.IRoutes) {
+	r.GET("/healthz", h.live)
+	r.GET("/readyz", h.readiness)
+}
+
+func (h *Health) live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Health) readiness(c *gin.Context) {
+	status, code := "ready", http.StatusOK
+	if !h.ready.Load() {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	hives := make(map[string]string, len(h.hives))
+	for name, hive := range h.hives {
+		hives[name] = "ok"
+		if err := cfghive.PingHive(hive); err != nil {
+			hives[name] = err.Error()
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{"status": status, "hives": hives})
+}
@@ -0,0 +1,60 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func TestHealth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bolt := cfghive.NewBoltHive(filepath.Join(t.TempDir(), "hive.db"))
+	if err := bolt.Load(); err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := cfghive.NewMemHive()
+	health := handlers.NewHealth(map[string]cfghive.Hive{"hive": cfghive.NewSyncHive(bolt), "values": mem})
+	engine := gin.New()
+	health.Register(engine)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	readiness := func(status int, want string) map[string]string {
+		t.Helper()
+		resp := userRequest(t, srv, "", http.MethodGet, "/readyz", "")
+		var body struct {
+			Status string            `json:"status"`
+			Hives  map[string]string `json:"hives"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != status || body.Status != want {
+			t.Fatalf("status %d %q", resp.StatusCode, body.Status)
+		}
+		return body.Hives
+	}
+
+	if resp := userRequest(t, srv, "", http.MethodGet, "/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	readiness(http.StatusServiceUnavailable, "not ready")
+	health.SetReady(true)
+	hives := readiness(http.StatusOK, "ready")
+	if hives["hive"] != "ok" || hives["values"] != "ok" {
+		t.Fatalf("hives %v", hives)
+	}
+	bolt.Close()
+	hives = readiness(http.StatusServiceUnavailable, "unavailable")
+	if hives["hive"] == "ok" || hives["values"] != "ok" {
+		t.Fatalf("hives %v", hives)
+	}
+	// Still alive
+	if resp := userRequest(t, srv, "", http.MethodGet, "/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
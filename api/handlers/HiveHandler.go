@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// HiveHandler Serves a hive over HTTP, with the protocol used by cfghive.RemoteHive.
+//
+// Values are typed JSON values, see cfghive.HiveValue.MarshalJSON:
+//
+//	GET    /hive/*path  Gets a value, or the whole hive at /hive/. ?children lists a sub-hive instead.
+//	                    The ETag is the revision of the key, see ChangeFeed, and the
+//	                    X-Hive-Revision header is the revision of the last change, see /watch.
+//	PUT    /hive/*path  Sets a value. If-Match and If-None-Match: * make it conditional.
+//	DELETE /hive/*path  Deletes a value or a sub-hive. If-Match makes it conditional.
+//	POST   /hive/*path  Creates an empty sub-hive.
+//	GET    /export      Exports the hive as plain JSON, or as typed JSON with ?typed.
+//	POST   /import      Sets every top level key of a plain JSON object, or typed JSON with ?typed.
+//	POST   /commit, /rollback, /save
+//	GET    /watch/*path Streams the changes under a path as server-sent events, see ChangeFeed.
+//
+// Errors are {"error": "..."} bodies, with a status mapped from the hive error.
+type HiveHandler struct {
+	hive *cfghive.SyncHive
+	feed *ChangeFeed
+	// Audit Records every change with the principal and client IP, if not nil.
+	Audit *cfghive.AuditLog
+	// Limiter Limits the size of the values set and the values of the hive,
+	// see Limits.MaxValue and Limits.MaxKeys, if not nil.
+	Limiter *Limiter
+	// Tenant The tenant owning the hive, recorded in the audit log, see Tenants.
+	Tenant string
+	// Environment The environment owning the hive, recorded in the audit log, see Environments.
+	Environment string
+}
+
+// NewHiveHandler Creates a handler serving hive, which is locked for every request.
+func NewHiveHandler(hive cfghive.Hive) *HiveHandler {
+	sh, ok := hive.(*cfghive.SyncHive)
+	if !ok {
+		sh = cfghive.NewSyncHive(hive)
+	}
+	return &HiveHandler{hive: sh, feed: NewChangeFeed(1024)}
+}
+
+// Feed Gets the feed of the changes made through the handler.
+func (h *HiveHandler) Feed() *ChangeFeed {
+	return h.feed
+}
+
+// hiveRoute A route of the hive, see HiveHandler.Register.
+type hiveRoute struct {
+	method  string
+	path    string
+	handler gin.HandlerFunc
+}
+
+// routes Gets the hive routes, always in the same order, so the routes of several handlers can be
+// served by one route each, see Environments.
+func (h *HiveHandler) routes() []hiveRoute {
+	return []hiveRoute{
+		{http.MethodGet, "/hive/*path", h.get},
+		{http.MethodPut, "/hive/*path", h.put},
+		{http.MethodDelete, "/hive/*path", h.delete},
+		{http.MethodPost, "/hive/*path", h.newSub},
+		{http.MethodGet, "/export", h.export},
+		{http.MethodPost, "/import", h.importData},
+		{http.MethodPost, "/commit", h.commit},
+		{http.MethodPost, "/rollback", h.rollback},
+		{http.MethodPost, "/save", h.save},
+		{http.MethodGet, "/watch/*path", h.watch},
+	}
+}
+
+// Register Adds the hive routes to r.
+func (h *HiveHandler) Register(r gin.IRoutes) {
+	for _, route := range h.routes() {
+		r.Handle(route.method, route.path, route.handler)
+	}
+}
+
+// errKeyExists Is returned when creating a sub-hive over an existing key.
+var errKeyExists = errors.New("already exists")
+
+// errPreconditionFailed Is returned when the If-Match or If-None-Match header of a change does not match.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// changeSource Who makes a change and from where, as recorded in the audit log.
+type changeSource struct {
+	client    string
+	requestID string
+	user      string
+	logger    *slog.Logger
+}
+
+// ginSource Gets the source of the changes made by a request.
+func ginSource(c *gin.Context) changeSource {
+	src := changeSource{client: c.ClientIP(), requestID: CurrentRequestID(c), logger: Logger(c)}
+	if p := CurrentPrincipal(c); p != nil {
+		src.user = p.Name
+	}
+	return src
+}
+
+// changed Records a change made by a request in the audit log, and publishes it, with the hive lock held.
+// If the change cannot be recorded it is undone, except for rollbacks.
+func (h *HiveHandler) changed(c *gin.Context, hive cfghive.Hive, op string, key string, old *cfghive.HiveValue, value *cfghive.HiveValue) error {
+	return h.record(ginSource(c), hive, op, key, old, value)
+}
+
+// record Records a change in the audit log, and publishes it, with the hive lock held, see changed.
+func (h *HiveHandler) record(src changeSource, hive cfghive.Hive, op string, key string, old *cfghive.HiveValue, value *cfghive.HiveValue) error {
+	change := hiveChange{op: op, key: key, old: old, value: value}
+	err := h.audit(src, change)
+	if err != nil {
+		undo(hive, key, old)
+		return fmt.Errorf("audit: %w", err)
+	}
+	h.publish(src, change)
+	return nil
+}
+
+// audit Appends a change to the audit log, if any.
+func (h *HiveHandler) audit(src changeSource, change hiveChange) error {
+	if h.Audit == nil {
+		return nil
+	}
+	_, err := h.Audit.Append(cfghive.AuditEntry{Client: src.client, RequestID: src.requestID, User: src.user, Tenant: h.Tenant,
+		Environment: h.Environment, Op: change.op, Key: change.key, Old: change.old, New: change.value})
+	return err
+}
+
+// publish Logs a change, and publishes it on the feed.
+func (h *HiveHandler) publish(src changeSource, change hiveChange) {
+	src.logger.Info("hive change", "op", change.op, "key", change.key)
+	h.feed.Publish(change.op, change.key, change.value)
+}
+
+// undo Restores the old value of a key, deleting it if there was none, with the hive lock held.
+// The root key is left as is.
+func undo(hive cfghive.Hive, key string, old *cfghive.HiveValue) {
+	if key != "" && old == nil {
+		hive.Delete(key)
+	} else if key != "" {
+		hive.Set(key, old.Value())
+	}
+}
+
+// hiveChange A change applied to the hive, to record it or undo it.
+type hiveChange struct {
+	op    string
+	key   string
+	old   *cfghive.HiveValue
+	value *cfghive.HiveValue
+}
+
+// undoChanges Undoes applied changes, the last first, with the hive lock held.
+func undoChanges(hive cfghive.Hive, changes []hiveChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		undo(hive, changes[i].key, changes[i].old)
+	}
+}
+
+// reverse Gets the change undoing c, see undo.
+func (c hiveChange) reverse() hiveChange {
+	if c.old == nil {
+		return hiveChange{op: ChangeDelete, key: c.key, old: c.value}
+	}
+	return hiveChange{op: ChangeSet, key: c.key, old: c.value, value: c.old}
+}
+
+// recordChanges Records applied changes, see record, with the hive lock held. They are all audited
+// before any is published. If one cannot be audited, they are all undone, and the undoing of the ones
+// audited before is audited too, so the audit log matches the hive as far as it can be written.
+func (h *HiveHandler) recordChanges(src changeSource, hive cfghive.Hive, changes []hiveChange) error {
+	for i, change := range changes {
+		err := h.audit(src, change)
+		if err != nil {
+			undoChanges(hive, changes)
+			for j := i - 1; j >= 0; j-- {
+				if uerr := h.audit(src, changes[j].reverse()); uerr != nil {
+					src.logger.Error("cannot audit an undone change", "key", changes[j].key, "err", uerr)
+				}
+			}
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+	for _, change := range changes {
+		h.publish(src, change)
+	}
+	return nil
+}
+
+// hiveErrorStatus Maps a hive error to a response status.
+func hiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, cfghive.ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, cfghive.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cfghive.ErrReadOnly):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, cfghive.ErrNotSubHive), errors.Is(err, errKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, cfghive.ErrInvalidType):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errQuotaExceeded):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// abortWithError Answers an error, 413 if the body was larger than Limits.MaxBody.
+// The error is also added to the context errors, which are logged, see RequestLogger.
+func abortWithError(c *gin.Context, status int, err error) {
+	c.Error(err)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+func abortWithHiveError(c *gin.Context, err error) {
+	abortWithError(c, hiveErrorStatus(err), err)
+}
+
+// checkValueSize Checks the size of a value set, see Limiter.
+func (h *HiveHandler) checkValueSize(v *cfghive.HiveValue) error {
+	if h.Limiter == nil {
+		return nil
+	}
+	return h.Limiter.checkValueSize(v)
+}
+
+// checkKeys Checks the hive values once changes are set, with the hive lock held, see Limiter.
+func (h *HiveHandler) checkKeys(hive cfghive.Hive, changes map[string]*cfghive.HiveValue) error {
+	if h.Limiter == nil {
+		return nil
+	}
+	return h.Limiter.checkKeys(hive, changes)
+}
+
+func hiveKey(c *gin.Context) string {
+	return strings.Trim(c.Param("path"), "/")
+}
+
+// lookup Gets the value of key, or the whole hive for the root key.
+func lookup(hive cfghive.Hive, key string) (*cfghive.HiveValue, error) {
+	if key == "" {
+		v, err := cfghive.NewHiveValue(*hive.GetData())
+		return &v, err
+	}
+	return hive.Get(key)
+}
+
+// etagMatches Reports whether an If-Match or If-None-Match header lists etag.
+func etagMatches(header string, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrecondition Checks the If-Match and If-None-Match headers of a change against the current key,
+// with the hive lock held.
+func (h *HiveHandler) checkPrecondition(c *gin.Context, hive cfghive.Hive, key string) error {
+	ifMatch, ifNoneMatch := c.GetHeader("If-Match"), c.GetHeader("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	_, err := hive.Get(key)
+	if err != nil && !errors.Is(err, cfghive.ErrKeyNotFound) {
+		return err
+	}
+	exists := err == nil
+	if ifMatch != "" && (!exists || !etagMatches(ifMatch, h.feed.ETag(key))) {
+		return fmt.Errorf("key %s has changed: %w", key, errPreconditionFailed)
+	}
+	if ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, h.feed.ETag(key)) {
+		return fmt.Errorf("key %s exists: %w", key, errPreconditionFailed)
+	}
+	return nil
+}
+
+// writeValue Writes a JSON body with its ETag, or 304 if the client has it already.
+func writeValue(c *gin.Context, v interface{}, etag string) {
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+type hiveChild struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (h *HiveHandler) get(c *gin.Context) {
+	key := hiveKey(c)
+	var v *cfghive.HiveValue
+	var etag string
+	err := h.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		v, err = lookup(hive, key)
+		// Changes are published under the hive lock, so the revisions match the value.
+		etag = h.feed.ETag(key)
+		c.Header("X-Hive-Revision", strconv.FormatUint(h.feed.Revision(), 10))
+		return err
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	if _, ok := c.GetQuery("children"); !ok {
+		writeValue(c, v, etag)
+		return
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		abortWithHiveError(c, fmt.Errorf("%s %w", key, cfghive.ErrNotSubHive))
+		return
+	}
+	children := make([]hiveChild, 0, len(sub))
+	for name, child := range sub {
+		children = append(children, hiveChild{Name: name, Type: child.TypeString()})
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+	// The listing is another representation of the sub-hive, with its own tag.
+	writeValue(c, gin.H{"children": children}, strings.TrimSuffix(etag, `"`)+`-children"`)
+}
+
+func (h *HiveHandler) put(c *gin.Context) {
+	var v cfghive.HiveValue
+	err := c.ShouldBindJSON(&v)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	err = h.checkValueSize(&v)
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	key := hiveKey(c)
+	err = h.hive.Do(func(hive cfghive.Hive) error {
+		err := h.checkPrecondition(c, hive, key)
+		if err != nil {
+			return err
+		}
+		err = h.checkKeys(hive, map[string]*cfghive.HiveValue{key: &v})
+		if err != nil {
+			return err
+		}
+		old, _ := hive.Get(key)
+		err = hive.Set(key, v.Value())
+		if err != nil {
+			return err
+		}
+		err = h.changed(c, hive, ChangeSet, key, old, &v)
+		if err != nil {
+			return err
+		}
+		c.Header("ETag", h.feed.ETag(key))
+		return nil
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *HiveHandler) delete(c *gin.Context) {
+	key := hiveKey(c)
+	err := h.hive.Do(func(hive cfghive.Hive) error {
+		old, err := hive.Get(key)
+		if err != nil {
+			return err
+		}
+		err = h.checkPrecondition(c, hive, key)
+		if err != nil {
+			return err
+		}
+		hive.Delete(key)
+		return h.changed(c, hive, ChangeDelete, key, old, nil)
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// createSub Creates an empty sub-hive, with the hive lock held. The key must not exist, and its parent must.
+func createSub(hive cfghive.Hive, key string) error {
+	_, err := hive.Get(key)
+	if err == nil {
+		return fmt.Errorf("key %s %w", key, errKeyExists)
+	}
+	if !errors.Is(err, cfghive.ErrKeyNotFound) {
+		return err
+	}
+	// NewSub reports no error, so check the parent exists first.
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		parent, err := hive.Get(key[:i])
+		if err != nil {
+			return err
+		}
+		if !parent.IsStoredType(cfghive.HiveTypeSub) {
+			return fmt.Errorf("%s is not at the path leaf, and %w", key[:i], cfghive.ErrNotSubHive)
+		}
+	}
+	hive.NewSub(key)
+	return nil
+}
+
+func (h *HiveHandler) newSub(c *gin.Context) {
+	key := hiveKey(c)
+	err := h.hive.Do(func(hive cfghive.Hive) error {
+		err := createSub(hive, key)
+		if err != nil {
+			return err
+		}
+		return h.changed(c, hive, ChangeNewSub, key, nil, nil)
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+func (h *HiveHandler) export(c *gin.Context) {
+	data := *h.hive.GetData()
+	if _, ok := c.GetQuery("typed"); ok {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	c.JSON(http.StatusOK, cfghive.HiveMapToGeneric(data))
+}
+
+// importData Sets every top level key of the body, all or nothing.
+func (h *HiveHandler) importData(c *gin.Context) {
+	data := make(map[string]cfghive.HiveValue)
+	var err error
+	if _, ok := c.GetQuery("typed"); ok {
+		err = c.ShouldBindJSON(&data)
+	} else {
+		generic := make(map[string]interface{})
+		err = c.ShouldBindJSON(&generic)
+		if err == nil {
+			data, err = cfghive.GenericMapToSubMap(generic)
+		}
+	}
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	err = h.hive.Do(func(hive cfghive.Hive) error {
+		changes := make(map[string]*cfghive.HiveValue, len(data))
+		for k := range data {
+			if strings.Contains(k, "/") || k == "" {
+				return fmt.Errorf("%w: %q is not a top level key", cfghive.ErrInvalidKey, k)
+			}
+			v := data[k]
+			err := h.checkValueSize(&v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			changes[k] = &v
+		}
+		err := h.checkKeys(hive, changes)
+		if err != nil {
+			return err
+		}
+		applied := make([]hiveChange, 0, len(data))
+		for k, v := range data {
+			old, _ := hive.Get(k)
+			err := hive.Set(k, v.Value())
+			if err != nil {
+				undoChanges(hive, applied)
+				return err
+			}
+			v := v
+			applied = append(applied, hiveChange{op: ChangeSet, key: k, old: old, value: &v})
+		}
+		return h.recordChanges(ginSource(c), hive, applied)
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imported": len(data)})
+}
+
+func (h *HiveHandler) commit(c *gin.Context) {
+	done, err := h.hive.Commit()
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	Logger(c).Info("hive commit", "done", done)
+	c.JSON(http.StatusOK, gin.H{"done": done})
+}
+
+func (h *HiveHandler) rollback(c *gin.Context) {
+	var done bool
+	err := h.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		done, err = hive.Rollback()
+		if err != nil || !done {
+			return err
+		}
+		return h.changed(c, hive, ChangeRollback, "", nil, nil)
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"done": done})
+}
+
+func (h *HiveHandler) save(c *gin.Context) {
+	err := h.hive.Save()
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	Logger(c).Info("hive save")
+	c.JSON(http.StatusOK, gin.H{"done": true})
+}
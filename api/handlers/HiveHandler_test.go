@@ -0,0 +1,272 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func newHiveServer(t *testing.T) (*httptest.Server, cfghive.Hive) {
+	gin.SetMode(gin.TestMode)
+	hive, _ := cfghive.NewMemHive()
+	engine := gin.New()
+	handlers.NewHiveHandler(hive).Register(engine)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv, hive
+}
+
+func request(t *testing.T, method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHiveHandlerStatus(t *testing.T) {
+	srv, _ := newHiveServer(t)
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/hive/app", "", http.StatusCreated},
+		{http.MethodPost, "/hive/app", "", http.StatusConflict},
+		{http.MethodPost, "/hive/missing/sub", "", http.StatusNotFound},
+		{http.MethodPut, "/hive/app/port", `{"type":"int","value":8080}`, http.StatusNoContent},
+		{http.MethodPut, "/hive/app/port/x", `{"type":"int","value":1}`, http.StatusConflict},
+		{http.MethodPut, "/hive/app/port", `{"type":"byte","value":256}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/hive/app/port", `8080`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/hive/", `{"type":"int","value":1}`, http.StatusBadRequest},
+		{http.MethodGet, "/hive/app/port", "", http.StatusOK},
+		{http.MethodGet, "/hive/app/host", "", http.StatusNotFound},
+		{http.MethodGet, "/hive/app/port?children", "", http.StatusConflict},
+		{http.MethodDelete, "/hive/app/host", "", http.StatusNotFound},
+		{http.MethodPost, "/import", `{"db":{"host":"localhost"}}`, http.StatusOK},
+		{http.MethodPost, "/import", `{"list":[1]}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/import?typed", `{"db":{"type":"list","value":[]}}`, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/hive/db/host", "", http.StatusNoContent},
+	} {
+		resp := request(t, tc.method, srv.URL+tc.path, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: status %d, expected %d", tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+		if resp.StatusCode >= 400 {
+			var body struct {
+				Error string `json:"error"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&body); body.Error == "" {
+				t.Errorf("%s %s: no error message", tc.method, tc.path)
+			}
+		}
+	}
+}
+
+func TestHiveHandlerChildren(t *testing.T) {
+	srv, hive := newHiveServer(t)
+	hive.NewSub("app")
+	hive.SetString("app/name", "potential")
+	hive.NewSub("app/db")
+
+	resp := request(t, http.MethodGet, srv.URL+"/hive/app?children", "")
+	var body struct {
+		Children []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"children"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Children) != 2 || body.Children[0].Name != "db" || body.Children[0].Type != "sub" ||
+		body.Children[1].Name != "name" || body.Children[1].Type != "string" {
+		t.Fatalf("unexpected children %+v", body.Children)
+	}
+}
+
+func TestHiveHandlerExportImport(t *testing.T) {
+	srv, hive := newHiveServer(t)
+	hive.NewSub("app")
+	hive.Set("app/port", uint64(8080))
+	hive.Set("app/key", []byte{1, 2})
+
+	resp := request(t, http.MethodGet, srv.URL+"/export?typed", "")
+	exported, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hive.Delete("app")
+
+	resp = request(t, http.MethodPost, srv.URL+"/import?typed", string(exported))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import status %d", resp.StatusCode)
+	}
+	v, err := hive.Get("app/port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := v.Uint64(); err != nil || u != 8080 {
+		t.Fatalf("unexpected value %v %v", v.Value(), err)
+	}
+	v, err = hive.Get("app/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := v.Bytes(); err != nil || len(b) != 2 {
+		t.Fatalf("unexpected value %v %v", v.Value(), err)
+	}
+}
+
+// TestHiveHandlerRemoteHive Checks the server speaks the protocol of cfghive.RemoteHive.
+func TestHiveHandlerRemoteHive(t *testing.T) {
+	srv, _ := newHiveServer(t)
+	h := cfghive.NewRemoteHive(srv.URL)
+	h.NewSub("app")
+	err := h.SetFloat("app/ratio", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		f, err := h.GetFloat("app/ratio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != 0.5 {
+			t.Fatalf("ratio is %f, expected 0.5", f)
+		}
+	}
+	if size := cfghive.HiveSize(*h.GetData()); size != 1 {
+		t.Fatalf("hive size is %d, expected 1", size)
+	}
+	_, err = h.Get("app/missing")
+	if !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = h.Set("app/ratio/x", 1)
+	if hiveStatus(err) != http.StatusConflict {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := h.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hiveStatus(err error) int {
+	var remoteErr *cfghive.RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.StatusCode
+	}
+	return 0
+}
+
+func conditional(t *testing.T, method string, url string, body string, header string, etag string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(header, etag)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHiveHandlerRevisions(t *testing.T) {
+	srv, _ := newHiveServer(t)
+	request(t, http.MethodPost, srv.URL+"/hive/app", "")
+	request(t, http.MethodPut, srv.URL+"/hive/app/port", `{"type":"int","value":80}`)
+	etag := func(key string) string {
+		return request(t, http.MethodGet, srv.URL+"/hive/"+key, "").Header.Get("ETag")
+	}
+	app, port := etag("app"), etag("app/port")
+
+	resp := conditional(t, http.MethodPut, srv.URL+"/hive/app/port", `{"type":"int","value":81}`, "If-Match", port)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp.Header.Get("ETag") != etag("app/port") || etag("app/port") == port {
+		t.Fatal("the revision of a set key did not change")
+	}
+	if etag("app") == app {
+		t.Fatal("the revision of a parent did not change")
+	}
+	resp = conditional(t, http.MethodPut, srv.URL+"/hive/app/port", `{"type":"int","value":82}`, "If-Match", port)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	resp = conditional(t, http.MethodDelete, srv.URL+"/hive/app/port", "", "If-Match", port)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	resp = conditional(t, http.MethodPut, srv.URL+"/hive/app/port", `{"type":"int","value":82}`, "If-None-Match", "*")
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	resp = conditional(t, http.MethodGet, srv.URL+"/hive/app/port", "", "If-None-Match", etag("app/port"))
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusNotModified)
+	}
+
+	// Replacing a parent changes the revision of its children.
+	port = etag("app/port")
+	request(t, http.MethodPut, srv.URL+"/hive/app", `{"type":"sub","value":{"port":{"type":"int","value":81}}}`)
+	if etag("app/port") == port {
+		t.Fatal("the revision of a replaced child did not change")
+	}
+}
+
+func TestHiveHandlerCompareAndSet(t *testing.T) {
+	srv, hive := newHiveServer(t)
+	hive.SetInt("n", 0)
+	h := cfghive.NewRemoteHive(srv.URL)
+
+	// Concurrent increments only succeed once the value read is still current.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					v, err := h.Get("n")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n, _ := v.Int()
+					swapped, err := h.CompareAndSet("n", v, n+1)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := hive.GetInt("n"); n != 40 {
+		t.Fatalf("n is %d, expected 40", n)
+	}
+}
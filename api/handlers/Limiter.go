@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// errValueTooLarge Is returned for hive values larger than Limits.MaxValue.
+var errValueTooLarge = errors.New("value is too large")
+
+// errQuotaExceeded Is returned for changes making a hive hold more than Limits.MaxKeys values.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// Limits The limits enforced by a Limiter. A zero limit is no limit.
+type Limits struct {
+	// The requests per second of each user, and how many can be made at once.
+	UserRate  float64
+	UserBurst int
+	// The requests per second of each client IP, and how many can be made at once.
+	IPRate  float64
+	IPBurst int
+	// The size of the request bodies, in bytes.
+	MaxBody int64
+	// The size of a hive value, as typed JSON, in bytes. See HiveHandler.Limiter.
+	MaxValue int64
+	// The values held by a hive, counted as cfghive.HiveSize does. See HiveHandler.Limiter.
+	MaxKeys int
+}
+
+// tokenBucket Allows rate requests per second on average, and up to burst at once.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill Adds the tokens earned since the last request.
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// take Takes a token, or returns how long to wait until one is available.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	b.refill(now, rate, burst)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimit The token buckets of the users or of the client IPs.
+type rateLimit struct {
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// Limiter Limits the rate and the size of the requests, see Limits:
+//
+//   - Requests over the rate of their client IP or of their user are answered 429, with a Retry-After header.
+//   - Request bodies larger than MaxBody are answered 413.
+//
+// Buckets left full for a minute are forgotten.
+type Limiter struct {
+	lock   sync.Mutex
+	limits Limits
+	ips    rateLimit
+	users  rateLimit
+}
+
+// NewLimiter Creates a limiter enforcing limits.
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{
+		limits: limits,
+		ips:    rateLimit{buckets: make(map[string]*tokenBucket)},
+		users:  rateLimit{buckets: make(map[string]*tokenBucket)},
+	}
+}
+
+// SetLimits Sets the limits enforced from now on.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits = limits
+}
+
+// Limits Gets the limits enforced.
+func (l *Limiter) Limits() Limits {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limits
+}
+
+// allow Takes a token from the bucket of key, if the rate is limited.
+func (l *Limiter) allow(r *rateLimit, key string, rate float64, burst int) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	burst = max(burst, 1)
+	now := time.Now()
+	if now.Sub(r.swept) > time.Minute {
+		for k, b := range r.buckets {
+			if b.refill(now, rate, burst); b.tokens >= float64(burst) {
+				delete(r.buckets, k)
+			}
+		}
+		r.swept = now
+	}
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		r.buckets[key] = b
+	}
+	return b.take(now, rate, burst)
+}
+
+// allowIP Takes a token from the bucket of a client IP.
+func (l *Limiter) allowIP(ip string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.allow(&l.ips, ip, l.limits.IPRate, l.limits.IPBurst)
+}
+
+// allowKey Takes a token from the bucket of a user, or of another key, at the user rate.
+func (l *Limiter) allowKey(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.allow(&l.users, key, l.limits.UserRate, l.limits.UserBurst)
+}
+
+// abortRateLimited Answers 429, telling the client to retry after wait, in whole seconds.
+func abortRateLimited(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	abortWithError(c, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+}
+
+// IPMiddleware Limits the rate of each client IP, and the size of the request bodies.
+// It must come before the authentication, which it protects.
+func (l *Limiter) IPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.allowIP(c.ClientIP())
+		if !ok {
+			abortRateLimited(c, wait)
+			return
+		}
+		limits := l.Limits()
+		if limits.MaxBody > 0 {
+			if c.Request.ContentLength > limits.MaxBody {
+				abortWithError(c, http.StatusRequestEntityTooLarge, &http.MaxBytesError{Limit: limits.MaxBody})
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBody)
+		}
+		c.Next()
+	}
+}
+
+// UserMiddleware Limits the rate of each user. It must come after the authentication.
+func (l *Limiter) UserMiddleware() gin.HandlerFunc {
+	return l.KeyMiddleware(func(c *gin.Context) string {
+		return CurrentPrincipal(c).Name
+	})
+}
+
+// KeyMiddleware Limits the rate of the requests sharing a key at the user rate,
+// e.g. all the requests of a tenant, see Tenants.
+func (l *Limiter) KeyMiddleware(key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.allowKey(key(c))
+		if !ok {
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkValueSize Checks a hive value is at most Limits.MaxValue bytes as typed JSON.
+func (l *Limiter) checkValueSize(v *cfghive.HiveValue) error {
+	limit := l.Limits().MaxValue
+	if limit <= 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > limit {
+		return fmt.Errorf("%w: %d bytes, at most %d", errValueTooLarge, len(b), limit)
+	}
+	return nil
+}
+
+// valueKeys Counts the values of v as cfghive.HiveSize does.
+func valueKeys(v *cfghive.HiveValue) int {
+	sub, err := v.Sub()
+	if err != nil {
+		return 1
+	}
+	return int(cfghive.HiveSize(sub))
+}
+
+// checkKeys Checks a hive holds at most Limits.MaxKeys values once changes are set, with the hive lock held.
+func (l *Limiter) checkKeys(hive cfghive.Hive, changes map[string]*cfghive.HiveValue) error {
+	limit := l.Limits().MaxKeys
+	if limit <= 0 {
+		return nil
+	}
+	n := int(cfghive.HiveSize(*hive.GetData()))
+	for key, v := range changes {
+		if old, err := hive.Get(key); err == nil {
+			n -= valueKeys(old)
+		}
+		n += valueKeys(v)
+	}
+	if n > limit {
+		return fmt.Errorf("%w: %d values, at most %d", errQuotaExceeded, n, limit)
+	}
+	return nil
+}
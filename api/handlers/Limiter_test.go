@@ -0,0 +1,98 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func newLimitedServer(t *testing.T, limiter *handlers.Limiter) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	auth := handlers.NewAuth(store, testSecret)
+	hive, _ := cfghive.NewMemHive()
+	hiveHandler := handlers.NewHiveHandler(hive)
+	hiveHandler.Limiter = limiter
+	engine := gin.New()
+	limited := engine.Group("/", limiter.IPMiddleware())
+	auth.RegisterPublic(limited)
+	hiveHandler.Register(limited.Group("/", auth.Middleware(), limiter.UserMiddleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLimiterRate(t *testing.T) {
+	srv := newLimitedServer(t, handlers.NewLimiter(handlers.Limits{UserRate: 0.5, UserBurst: 2, IPRate: 0.5, IPBurst: 4}))
+
+	for i, tc := range []struct {
+		user       string
+		method     string
+		path       string
+		status     int
+		retryAfter string
+	}{
+		{"alice", http.MethodGet, "/hive/", http.StatusOK, ""},
+		{"alice", http.MethodGet, "/hive/", http.StatusOK, ""},
+		// The user rate
+		{"alice", http.MethodGet, "/hive/", http.StatusTooManyRequests, "2"},
+		{"admin", http.MethodGet, "/hive/", http.StatusOK, ""},
+		// The IP rate, before authenticating
+		{"admin", http.MethodGet, "/hive/", http.StatusTooManyRequests, "2"},
+		{"", http.MethodPost, "/auth/login", http.StatusTooManyRequests, "2"},
+	} {
+		resp := userRequest(t, srv, tc.user, tc.method, tc.path, "")
+		if resp.StatusCode != tc.status || resp.Header.Get("Retry-After") != tc.retryAfter {
+			t.Fatalf("%d: status %d, Retry-After %q", i, resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+	}
+}
+
+func TestLimiterSize(t *testing.T) {
+	limiter := handlers.NewLimiter(handlers.Limits{MaxBody: 100, MaxValue: 50})
+	srv := newLimitedServer(t, limiter)
+	large := `{"type":"string","value":"` + strings.Repeat("x", 100) + `"}`
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, "/hive/a", `{"type":"string","value":"small"}`, http.StatusNoContent},
+		// Larger than the value limit
+		{http.MethodPut, "/hive/a", `{"type":"string","value":"` + strings.Repeat("x", 50) + `"}`, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/import", `{"a":"` + strings.Repeat("x", 50) + `"}`, http.StatusRequestEntityTooLarge},
+		// Larger than the body limit
+		{http.MethodPut, "/hive/a", large, http.StatusRequestEntityTooLarge},
+	} {
+		resp := userRequest(t, srv, "alice", tc.method, tc.path, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s %s: status %d", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+
+	// Without a Content-Length
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/hive/a", io.MultiReader(strings.NewReader(large)))
+	req.SetBasicAuth("alice", "alice-password")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	// Reloaded limits
+	limiter.SetLimits(handlers.Limits{})
+	if resp := userRequest(t, srv, "alice", http.MethodPut, "/hive/a", large); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader The header holding the id of a request, which is also sent back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey The context key of the request id.
+const RequestIDKey = "handlers.request_id"
+
+// requestIDRe The request ids accepted from clients, others are replaced.
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// newRequestID Generates a random request id.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CurrentRequestID Gets the id of a request, empty if RequestID is not used.
+func CurrentRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// RequestID Sets the id of the requests, from their X-Request-ID header if it is valid,
+// so the requests of a client or of a proxy can be followed through the logs,
+// or else a random one. The id is sent back in the X-Request-ID header.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Logger Gets the default logger, with the request id, the tenant and the principal name of the request.
+func Logger(c *gin.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := CurrentRequestID(c); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if tenant := c.GetString(TenantKey); tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	if p := CurrentPrincipal(c); p != nil {
+		logger = logger.With("user", p.Name)
+	}
+	return logger
+}
+
+// RequestLogger Logs every request once served, with the hive key of the hive routes,
+// at the error level for server errors and at the warning level for client errors.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []interface{}{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if _, ok := c.Params.Get("path"); ok {
+			attrs = append(attrs, "key", hiveKey(c))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", strings.Join(c.Errors.Errors(), "; "))
+		}
+		Logger(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery Answers 500 to the requests that panic, and logs the panic with its stack.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		Logger(c).Error("panic", "err", err, "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
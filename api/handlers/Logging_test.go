@@ -0,0 +1,124 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+// logBuffer Collects the JSON log lines.
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// records Gets the logged records with the given message.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if r["msg"] == msg {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func TestRequestLogging(t *testing.T) {
+	logs := &logBuffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	audit, err := cfghive.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	hive, _ := cfghive.NewMemHive()
+	hiveHandler := handlers.NewHiveHandler(hive)
+	hiveHandler.Audit = audit
+	engine := gin.New()
+	engine.Use(handlers.RequestID(), handlers.RequestLogger(), handlers.Recovery())
+	engine.GET("/panic", func(c *gin.Context) { panic("oops") })
+	hiveHandler.Register(engine.Group("/", handlers.NewAuth(store, testSecret).Middleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/hive/app", strings.NewReader(`{"type":"int","value":1}`))
+	req.SetBasicAuth("admin", "admin-password")
+	req.Header.Set(handlers.RequestIDHeader, "client-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(handlers.RequestIDHeader) != "client-42" {
+		t.Fatalf("status %d, request id %q", resp.StatusCode, resp.Header.Get(handlers.RequestIDHeader))
+	}
+	// Invalid ids are replaced
+	resp = userRequest(t, srv, "alice", http.MethodGet, "/hive/missing", "")
+	generated := resp.Header.Get(handlers.RequestIDHeader)
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(generated) {
+		t.Fatalf("request id %q", generated)
+	}
+	userRequest(t, srv, "", http.MethodGet, "/panic", "")
+
+	requests := logs.records(t, "request")
+	if len(requests) != 3 {
+		t.Fatalf("logged requests %v", requests)
+	}
+	for i, expected := range []map[string]interface{}{
+		{"level": "INFO", "request_id": "client-42", "user": "admin", "key": "app", "status": 204.0, "route": "/hive/*path"},
+		{"level": "WARN", "request_id": generated, "user": "alice", "key": "missing", "status": 404.0},
+		{"level": "ERROR", "status": 500.0},
+	} {
+		for k, v := range expected {
+			if requests[i][k] != v {
+				t.Errorf("request %d: %s is %v, expected %v", i, k, requests[i][k], v)
+			}
+		}
+	}
+	if requests[1]["error"] == nil {
+		t.Errorf("no error logged: %v", requests[1])
+	}
+	changes := logs.records(t, "hive change")
+	if len(changes) != 1 || changes[0]["request_id"] != "client-42" || changes[0]["op"] != "set" {
+		t.Fatalf("logged changes %v", changes)
+	}
+	if panics := logs.records(t, "panic"); len(panics) != 1 || panics[0]["err"] != "oops" {
+		t.Fatalf("logged panics %v", panics)
+	}
+
+	entries, err := audit.Query(cfghive.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].RequestID != "client-42" {
+		t.Fatalf("audit entries %+v", entries)
+	}
+}
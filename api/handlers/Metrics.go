@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics Collects the metrics of the api, served in the Prometheus format:
+//
+//	GET /metrics  Gets the metrics. Public, for the scrapers.
+//
+// Requests are counted and timed by route, see Middleware, and the hives by name, see Hive:
+//
+//	api_http_requests_total{method, route, status}
+//	api_http_request_duration_seconds{method, route}
+//	api_hive_keys{hive}                       The number of values, see cfghive.HiveSize.
+//	api_hive_load_duration_seconds{hive}
+//	api_hive_save_duration_seconds{hive}      Saves, and commits of pending changes.
+//	api_hive_commit_failures_total{hive}
+//
+// The Go runtime and process metrics are also served.
+type Metrics struct {
+	registry       *prometheus.Registry
+	requests       *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	loads          *prometheus.HistogramVec
+	saves          *prometheus.HistogramVec
+	commitFailures *prometheus.CounterVec
+}
+
+// NewMetrics Creates the metrics, in their own registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "api_http_requests_total",
+			Help: "The number of HTTP requests, by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "api_http_request_duration_seconds",
+			Help:    "The time taken to serve HTTP requests, by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		loads: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "api_hive_load_duration_seconds",
+			Help:    "The time taken to load the hives.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"hive"}),
+		saves: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "api_hive_save_duration_seconds",
+			Help:    "The time taken to save the hives, or commit their changes.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"hive"}),
+		commitFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "api_hive_commit_failures_total",
+			Help: "The number of failed hive commits and saves.",
+		}, []string{"hive"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.latency, m.loads, m.saves, m.commitFailures,
+	)
+	return m
+}
+
+// Register Adds the metrics route to r.
+func (m *Metrics) Register(r gin.IRoutes) {
+	r.GET("/metrics", gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})))
+}
+
+// Middleware Counts and times the requests. Requests matching no route are counted as "unmatched".
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.latency.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveLoad Records the time taken to load a hive, e.g. when it was opened.
+func (m *Metrics) ObserveLoad(name string, d time.Duration) {
+	m.loads.WithLabelValues(name).Observe(d.Seconds())
+}
+
+// Hive Wraps a loaded hive so its loads, saves and commits are measured, and its size
+// is reported as the hive name.
+// The hive is locked. Its size is counted once, then kept up to date by its writes,
+// so it is not read whole when the metrics are scraped.
+func (m *Metrics) Hive(name string, hive cfghive.Hive) *cfghive.SyncHive {
+	h := &meteredHive{Hive: hive, name: name, metrics: m}
+	h.count()
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "api_hive_keys",
+		Help:        "The number of values in the hives.",
+		ConstLabels: prometheus.Labels{"hive": name},
+	}, func() float64 {
+		return float64(h.keys.Load())
+	}))
+	return cfghive.NewSyncHive(h)
+}
+
+// meteredHive A hive measuring its loads, saves and commits, and counting its values, see Metrics.Hive.
+type meteredHive struct {
+	cfghive.Hive
+	name    string
+	metrics *Metrics
+	keys    atomic.Int64
+}
+
+// count Counts the values of the whole hive, once they may all have changed.
+func (h *meteredHive) count() {
+	h.keys.Store(int64(cfghive.HiveSize(*h.Hive.GetData())))
+}
+
+// valueSize Gets the number of values of v, see cfghive.HiveSize, 0 if it is nil.
+func valueSize(v *cfghive.HiveValue) int64 {
+	if v == nil {
+		return 0
+	}
+	if sub, err := v.Sub(); err == nil {
+		return int64(cfghive.HiveSize(sub))
+	}
+	return 1
+}
+
+// write Writes key, counting the values it adds or removes.
+func (h *meteredHive) write(key string, fn func() error) error {
+	old, _ := h.Hive.Get(key)
+	err := fn()
+	if err != nil {
+		return err
+	}
+	v, _ := h.Hive.Get(key)
+	h.keys.Add(valueSize(v) - valueSize(old))
+	return nil
+}
+
+func (h *meteredHive) Set(key string, value interface{}) error {
+	return h.write(key, func() error { return h.Hive.Set(key, value) })
+}
+
+func (h *meteredHive) SetBool(key string, value bool) error {
+	return h.write(key, func() error { return h.Hive.SetBool(key, value) })
+}
+
+func (h *meteredHive) SetInt(key string, value int) error {
+	return h.write(key, func() error { return h.Hive.SetInt(key, value) })
+}
+
+func (h *meteredHive) SetFloat(key string, value float64) error {
+	return h.write(key, func() error { return h.Hive.SetFloat(key, value) })
+}
+
+func (h *meteredHive) SetString(key string, value string) error {
+	return h.write(key, func() error { return h.Hive.SetString(key, value) })
+}
+
+func (h *meteredHive) CompareAndSet(key string, old *cfghive.HiveValue, value interface{}) (bool, error) {
+	var ok bool
+	err := h.write(key, func() error {
+		var err error
+		ok, err = h.Hive.CompareAndSet(key, old, value)
+		return err
+	})
+	return ok, err
+}
+
+func (h *meteredHive) Delete(key string) {
+	h.write(key, func() error {
+		h.Hive.Delete(key)
+		return nil
+	})
+}
+
+func (h *meteredHive) NewSub(key string) {
+	h.write(key, func() error {
+		h.Hive.NewSub(key)
+		return nil
+	})
+}
+
+func (h *meteredHive) Load() error {
+	start := time.Now()
+	err := h.Hive.Load()
+	if err == nil {
+		h.metrics.ObserveLoad(h.name, time.Since(start))
+		h.count()
+	}
+	return err
+}
+
+func (h *meteredHive) Rollback() (bool, error) {
+	done, err := h.Hive.Rollback()
+	if done {
+		h.count()
+	}
+	return done, err
+}
+
+func (h *meteredHive) Commit() (bool, error) {
+	start := time.Now()
+	done, err := h.Hive.Commit()
+	if err != nil {
+		h.metrics.commitFailures.WithLabelValues(h.name).Inc()
+	} else if done {
+		h.metrics.saves.WithLabelValues(h.name).Observe(time.Since(start).Seconds())
+	}
+	return done, err
+}
+
+func (h *meteredHive) Save() error {
+	start := time.Now()
+	err := h.Hive.Save()
+	if err != nil {
+		h.metrics.commitFailures.WithLabelValues(h.name).Inc()
+	} else {
+		h.metrics.saves.WithLabelValues(h.name).Observe(time.Since(start).Seconds())
+	}
+	return err
+}
+
+// Ping Pings the measured hive, see cfghive.PingHive.
+func (h *meteredHive) Ping() error {
+	return cfghive.PingHive(h.Hive)
+}
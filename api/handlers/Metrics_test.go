@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+// failingHive A hive whose commits save it, and fail once failing is set.
+type failingHive struct {
+	*cfghive.MemHive
+	failing bool
+}
+
+func (h *failingHive) Commit() (bool, error) {
+	if h.failing {
+		return false, errors.New("disk full")
+	}
+	return true, nil
+}
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	metrics := handlers.NewMetrics()
+	mem, _ := cfghive.NewMemHive()
+	values := &failingHive{MemHive: mem}
+	engine := gin.New()
+	engine.Use(metrics.Middleware())
+	metrics.Register(engine)
+	hive := metrics.Hive("values", values)
+	handlers.NewValueHandler(hive).Register(engine.Group("/", handlers.NewAuth(store, testSecret).Middleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	for _, user := range []string{"admin", "alice"} {
+		if resp := userRequest(t, srv, user, http.MethodPost, "/admin", `{"value":"v"}`); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+	}
+	values.failing = true
+	if resp := userRequest(t, srv, "alice", http.MethodPost, "/admin", `{"value":"w"}`); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	userRequest(t, srv, "alice", http.MethodGet, "/nowhere", "")
+	// The values are counted as they are written.
+	hive.Delete("admin")
+	if err := hive.Set("app", map[string]interface{}{"host": "db", "port": 8080}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := userRequest(t, srv, "", http.MethodGet, "/metrics", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		`api_http_requests_total{method="POST",route="/admin",status="200"} 2`,
+		`api_http_requests_total{method="POST",route="/admin",status="500"} 1`,
+		`api_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`api_http_request_duration_seconds_count{method="POST",route="/admin"} 3`,
+		`api_hive_keys{hive="values"} 3`,
+		`api_hive_save_duration_seconds_count{hive="values"} 2`,
+		`api_hive_commit_failures_total{hive="values"} 1`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("no %s in the metrics", line)
+		}
+	}
+}
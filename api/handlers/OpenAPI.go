@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIDocument The OpenAPI 3 document of the api routes, see the client package for a Go client.
+// It must be updated with the routes.
+//
+//go:embed openapi.json
+var OpenAPIDocument []byte
+
+// RegisterOpenAPI Adds GET /openapi.json, serving OpenAPIDocument, to r. Public.
+func RegisterOpenAPI(r gin.IRoutes) {
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", OpenAPIDocument)
+	})
+}
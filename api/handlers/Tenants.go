@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// Errors returned by Tenants.
+var (
+	ErrTenantNotFound = errors.New("tenant does not exist")
+	ErrTenantExists   = errors.New("tenant already exists")
+	ErrInvalidTenant  = errors.New("invalid tenant")
+)
+
+// TenantKey The context key of the tenant name of a request, see Tenants.
+const TenantKey = "handlers.tenant"
+
+// tenantsKey The key of the tenants in the user store hive. It is not a user name, so it never clashes with users.
+const tenantsKey = "_tenants"
+
+// tenantClientIPHeader The header passing the client IP of a request to its tenant, see Tenants.serve.
+const tenantClientIPHeader = "X-Tenant-Client-IP"
+
+// tenantNameRe The tenant names, which are also part of the hive specs, see TenantOpener.
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// TenantQuota The limits of a tenant. A zero limit is no limit.
+type TenantQuota struct {
+	// The values of the tenant hive, see Limits.MaxKeys.
+	MaxKeys int `json:"max_keys"`
+	// The size of a value, see Limits.MaxValue.
+	MaxValue int64 `json:"max_value"`
+	// The requests per second of all the users of the tenant together, and how many can be made at once.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// limits Gets the limits enforcing the quota.
+func (q TenantQuota) limits() Limits {
+	return Limits{UserRate: q.Rate, UserBurst: q.Burst, MaxValue: q.MaxValue, MaxKeys: q.MaxKeys}
+}
+
+func checkQuota(q TenantQuota) error {
+	if q.MaxKeys < 0 || q.MaxValue < 0 || q.Rate < 0 || q.Burst < 0 {
+		return fmt.Errorf("%w: quota must not be negative", ErrInvalidTenant)
+	}
+	if q.Rate > 0 && q.Burst < 1 {
+		return fmt.Errorf("%w: burst must be at least 1 with a rate", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// Tenant A tenant of the api, with its own hive, users and roles.
+type Tenant struct {
+	Name    string      `json:"name"`
+	Created time.Time   `json:"created"`
+	Quota   TenantQuota `json:"quota"`
+	// Keys The values of the tenant hive, see cfghive.HiveSize.
+	Keys int `json:"keys"`
+}
+
+// TenantOpener Opens the hive of a tenant, or the hive storing its users if users is true.
+type TenantOpener func(tenant string, users bool) (cfghive.Hive, error)
+
+// tenant A tenant being served.
+type tenant struct {
+	Tenant
+	rawHive  cfghive.Hive
+	rawUsers cfghive.Hive
+	hive     *cfghive.SyncHive
+	auth     *Auth
+	limiter  *Limiter
+	handler  *HiveHandler
+	engine   *gin.Engine
+}
+
+// close Commits the hives of a tenant and closes them.
+func (t *tenant) close() error {
+	t.handler.Feed().Close()
+	_, err := t.hive.Commit()
+	for _, hive := range []cfghive.Hive{t.rawHive, t.rawUsers} {
+		if c, ok := hive.(io.Closer); ok {
+			err = errors.Join(err, c.Close())
+		}
+	}
+	return err
+}
+
+// Tenants Serves isolated tenants, each with its own hive, users, roles and quota, under /t/:tenant:
+//
+//	/t/:tenant/auth/login, /auth/refresh, /auth/keys  The tokens and api keys of the tenant users, see Auth.
+//	/t/:tenant/hive/*path, /export, /import, /commit,
+//	/rollback, /save, /watch/*path                    The tenant hive, see HiveHandler and ACL.
+//	/t/:tenant/acl/...                                The tenant roles, see ACL.
+//	/t/:tenant/users/...                              The tenant users, see UserHandler.
+//
+// The tenant users only exist in their tenant, and their tokens are signed with a key derived
+// from the tenant name, so neither the users of the main hive nor the ones of another tenant
+// are accepted. The tenant admins manage the tenant users and roles.
+//
+// The tenants are provisioned by the admins of the main hive:
+//
+//	GET    /tenants               Lists the tenants, as {"tenants"}.
+//	POST   /tenants               Creates a tenant from {"name", "admin_password", "quota"}, with an
+//	                              admin user. A generated admin password is only returned once.
+//	GET    /tenants/:tenant       Gets a tenant.
+//	PUT    /tenants/:tenant/quota Sets the quota of a tenant, see TenantQuota.
+//	DELETE /tenants/:tenant       Stops serving a tenant. The data of file backends is kept.
+//
+// The tenants are stored in the user store of the main hive, along the users.
+type Tenants struct {
+	registry *UserStore
+	secret   []byte
+	open     TenantOpener
+	// Audit Records the changes of the tenant hives, with the tenant name, if not nil. Set it before Load.
+	Audit *cfghive.AuditLog
+
+	lock    sync.RWMutex
+	tenants map[string]*tenant
+	// How long the tenant tokens are valid, see Auth.SetTTL. Zero for the Auth defaults.
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTenants Creates the tenants stored in registry, opening their hives with open,
+// and deriving the keys signing their tokens from secret.
+func NewTenants(registry *UserStore, secret []byte, open TenantOpener) *Tenants {
+	return &Tenants{registry: registry, secret: secret, open: open, tenants: make(map[string]*tenant)}
+}
+
+func checkTenantName(name string) error {
+	if !tenantNameRe.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be 1 to 63 lowercase letters, digits or -", ErrInvalidTenant, name)
+	}
+	return nil
+}
+
+// readTenant Reads a tenant of the registry, with the hive lock held.
+func readTenant(hive cfghive.Hive, name string) (*Tenant, error) {
+	if checkTenantName(name) != nil {
+		return nil, ErrTenantNotFound
+	}
+	v, err := hive.Get(tenantsKey + "/" + name)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		return nil, err
+	}
+	t := &Tenant{Name: name}
+	created, maxKeys, maxValue, rate, burst := sub["created"], sub["max_keys"], sub["max_value"], sub["rate"], sub["burst"]
+	if s, err := created.Int64(); err == nil {
+		t.Created = time.Unix(s, 0).UTC()
+	}
+	n, _ := maxKeys.Int64()
+	t.Quota.MaxKeys = int(n)
+	t.Quota.MaxValue, _ = maxValue.Int64()
+	t.Quota.Rate, _ = rate.Float64()
+	n, _ = burst.Int64()
+	t.Quota.Burst = int(n)
+	return t, nil
+}
+
+// writeTenant Writes a tenant to the registry, with the hive lock held.
+func writeTenant(hive cfghive.Hive, t *Tenant) error {
+	if _, err := hive.Get(tenantsKey); err != nil {
+		hive.NewSub(tenantsKey)
+	}
+	return hive.Set(tenantsKey+"/"+t.Name, map[string]interface{}{
+		"created":   t.Created.Unix(),
+		"max_keys":  int64(t.Quota.MaxKeys),
+		"max_value": t.Quota.MaxValue,
+		"rate":      t.Quota.Rate,
+		"burst":     int64(t.Quota.Burst),
+	})
+}
+
+// tenantSecret Derives the key signing the tokens of a tenant.
+func tenantSecret(secret []byte, name string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("tenant/" + name))
+	return mac.Sum(nil)
+}
+
+// outerContextKey The request context key of the *gin.Context of the /t/:tenant request a tenant request is served for.
+type outerContextKey struct{}
+
+// tenantRequest Sets the tenant of the requests of a tenant, and reports their principal
+// and errors to the /t/:tenant request, so they are logged, see RequestLogger.
+func tenantRequest(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(TenantKey, name)
+		c.Next()
+		outer, ok := c.Request.Context().Value(outerContextKey{}).(*gin.Context)
+		if !ok {
+			return
+		}
+		if p := CurrentPrincipal(c); p != nil {
+			outer.Set(PrincipalKey, p)
+		}
+		for _, err := range c.Errors {
+			outer.Error(err.Err)
+		}
+	}
+}
+
+// start Opens the hives of a tenant, and creates its routes.
+func (t *Tenants) start(info Tenant) (*tenant, error) {
+	rawHive, err := t.open(info.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	rawUsers, err := t.open(info.Name, true)
+	if err != nil {
+		if c, ok := rawHive.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, err
+	}
+	tn := &tenant{Tenant: info, rawHive: rawHive, rawUsers: rawUsers, limiter: NewLimiter(info.Quota.limits())}
+	tn.hive = cfghive.NewSyncHive(rawHive)
+	users := NewUserStore(rawUsers)
+	users.Cost = t.registry.Cost
+	tn.auth = NewAuth(users, tenantSecret(t.secret, info.Name))
+	if t.accessTTL > 0 {
+		tn.auth.SetTTL(t.accessTTL, t.refreshTTL)
+	}
+	tn.handler = NewHiveHandler(tn.hive)
+	tn.handler.Limiter = tn.limiter
+	tn.handler.Tenant = info.Name
+	tn.handler.Audit = t.Audit
+
+	tn.engine = gin.New()
+	// The client IP was found by the api router, which knows the trusted proxies.
+	tn.engine.TrustedPlatform = tenantClientIPHeader
+	tn.engine.Use(tenantRequest(info.Name), RequestID(), tn.limiter.KeyMiddleware(func(*gin.Context) string {
+		return info.Name
+	}))
+	// The routes keep their /t/:tenant prefix, so the redirects of the engine are right.
+	routes := tn.engine.Group("/t/" + info.Name)
+	tn.auth.RegisterPublic(routes)
+	authorized := routes.Group("/", tn.auth.Middleware())
+	acl := NewACL(users)
+	tn.handler.Register(authorized.Group("/", acl.Middleware()))
+	acl.Register(authorized)
+	NewUserHandler(users).Register(authorized)
+	tn.auth.Register(authorized)
+	return tn, nil
+}
+
+// Load Starts serving the tenants of the registry.
+func (t *Tenants) Load() error {
+	var infos []Tenant
+	err := t.registry.hive.Do(func(hive cfghive.Hive) error {
+		v, err := hive.Get(tenantsKey)
+		if errors.Is(err, cfghive.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sub, err := v.Sub()
+		if err != nil {
+			return err
+		}
+		for name := range sub {
+			info, err := readTenant(hive, name)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", name, err)
+			}
+			infos = append(infos, *info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, info := range infos {
+		if _, ok := t.tenants[info.Name]; ok {
+			continue
+		}
+		tn, err := t.start(info)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", info.Name, err)
+		}
+		t.tenants[info.Name] = tn
+	}
+	return nil
+}
+
+// Close Commits the tenant hives and closes them.
+func (t *Tenants) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var err error
+	for name, tn := range t.tenants {
+		err = errors.Join(err, tn.close())
+		delete(t.tenants, name)
+	}
+	return err
+}
+
+// CloseFeeds Ends the watch streams of the tenants, which would hold the server shutdown, see ChangeFeed.Close.
+func (t *Tenants) CloseFeeds() {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for _, tn := range t.tenants {
+		tn.handler.Feed().Close()
+	}
+}
+
+// SetTTL Sets how long the tokens issued by the tenants from now on are valid, see Auth.SetTTL.
+func (t *Tenants) SetTTL(access time.Duration, refresh time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.accessTTL = access
+	t.refreshTTL = refresh
+	for _, tn := range t.tenants {
+		tn.auth.SetTTL(access, refresh)
+	}
+}
+
+// get Gets a tenant being served, nil if there is none.
+func (t *Tenants) get(name string) *tenant {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.tenants[name]
+}
+
+// info Gets a tenant with its current number of values.
+func (tn *tenant) info() Tenant {
+	info := tn.Tenant
+	info.Keys = int(cfghive.HiveSize(*tn.hive.GetData()))
+	return info
+}
+
+// Create Creates a tenant and starts serving it. If adminPassword is empty, a random one is generated
+// and returned. The admin user is not created if the tenant user store already has users,
+// e.g. the one of a tenant deleted before, and the password returned is then empty.
+func (t *Tenants) Create(name string, adminPassword string, quota TenantQuota) (*Tenant, string, error) {
+	err := checkTenantName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	err = checkQuota(quota)
+	if err != nil {
+		return nil, "", err
+	}
+	if adminPassword == "" {
+		adminPassword, err = randomString(18)
+		if err != nil {
+			return nil, "", err
+		}
+	} else if err := checkPassword(adminPassword); err != nil {
+		return nil, "", err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.tenants[name]; ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrTenantExists, name)
+	}
+	info := Tenant{Name: name, Created: time.Now().UTC().Truncate(time.Second), Quota: quota}
+	tn, err := t.start(info)
+	if err != nil {
+		return nil, "", err
+	}
+	users := tn.auth.users
+	if users.Empty() {
+		_, err = users.Create("admin", adminPassword, true)
+	} else {
+		adminPassword = ""
+	}
+	if err != nil {
+		tn.close()
+		return nil, "", err
+	}
+	err = t.registry.commit(func(hive cfghive.Hive) error {
+		if _, err := readTenant(hive, name); err == nil {
+			return fmt.Errorf("%w: %s", ErrTenantExists, name)
+		}
+		return writeTenant(hive, &info)
+	})
+	if err != nil {
+		tn.close()
+		return nil, "", err
+	}
+	t.tenants[name] = tn
+	result := tn.info()
+	return &result, adminPassword, nil
+}
+
+// List Lists the tenants, sorted by name.
+func (t *Tenants) List() []Tenant {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	list := make([]Tenant, 0, len(t.tenants))
+	for _, tn := range t.tenants {
+		list = append(list, tn.info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get Gets a tenant.
+func (t *Tenants) Get(name string) (*Tenant, error) {
+	tn := t.get(name)
+	if tn == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, name)
+	}
+	info := tn.info()
+	return &info, nil
+}
+
+// SetQuota Sets the quota of a tenant, enforced from now on.
+func (t *Tenants) SetQuota(name string, quota TenantQuota) (*Tenant, error) {
+	err := checkQuota(quota)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tn, ok := t.tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, name)
+	}
+	info := tn.Tenant
+	info.Quota = quota
+	err = t.registry.commit(func(hive cfghive.Hive) error {
+		return writeTenant(hive, &info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	tn.Quota = quota
+	tn.limiter.SetLimits(quota.limits())
+	result := tn.info()
+	return &result, nil
+}
+
+// Delete Stops serving a tenant, and removes it from the registry. Its hives are committed and closed,
+// but their data is kept.
+func (t *Tenants) Delete(name string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tn, ok := t.tenants[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTenantNotFound, name)
+	}
+	err := t.registry.commit(func(hive cfghive.Hive) error {
+		hive.Delete(tenantsKey + "/" + name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	delete(t.tenants, name)
+	return tn.close()
+}
+
+// Register Adds the tenant provisioning routes to r, which must authenticate the principal.
+func (t *Tenants) Register(r gin.IRouter) {
+	tenants := r.Group("/tenants", RequireAdmin())
+	tenants.GET("", t.list)
+	tenants.POST("", t.create)
+	tenants.GET("/:tenant", t.getTenant)
+	tenants.PUT("/:tenant/quota", t.setQuota)
+	tenants.DELETE("/:tenant", t.delete)
+}
+
+// RegisterServe Adds the /t/:tenant routes to r, which must not require authentication,
+// as the tenants authenticate their own users.
+func (t *Tenants) RegisterServe(r gin.IRoutes) {
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodPatch} {
+		r.Handle(method, "/t/:tenant/*route", t.serve)
+	}
+}
+
+// serve Serves a request with the routes of its tenant.
+func (t *Tenants) serve(c *gin.Context) {
+	name := c.Param("tenant")
+	tn := t.get(name)
+	if tn == nil {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("%w: %s", ErrTenantNotFound, name))
+		return
+	}
+	c.Set(TenantKey, name)
+	req := c.Request.Clone(context.WithValue(c.Request.Context(), outerContextKey{}, c))
+	// The client certificates are verified against the CA of the main server, they name main users,
+	// not the ones of the tenant.
+	req.TLS = nil
+	req.Header.Set(tenantClientIPHeader, c.ClientIP())
+	if id := CurrentRequestID(c); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	tn.engine.ServeHTTP(c.Writer, req)
+}
+
+func tenantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidTenant), errors.Is(err, ErrInvalidUser):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTenantNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTenantExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func abortWithTenantError(c *gin.Context, err error) {
+	abortWithError(c, tenantErrorStatus(err), err)
+}
+
+func (t *Tenants) list(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tenants": t.List()})
+}
+
+func (t *Tenants) create(c *gin.Context) {
+	var body struct {
+		Name          string      `json:"name" binding:"required"`
+		AdminPassword string      `json:"admin_password"`
+		Quota         TenantQuota `json:"quota"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	info, password, err := t.Create(body.Name, body.AdminPassword, body.Quota)
+	if err != nil {
+		abortWithTenantError(c, err)
+		return
+	}
+	Logger(c).Info("tenant created", "name", info.Name)
+	response := gin.H{"name": info.Name, "created": info.Created, "quota": info.Quota, "keys": info.Keys}
+	// A given password is not sent back.
+	if body.AdminPassword == "" && password != "" {
+		response["admin_password"] = password
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+func (t *Tenants) getTenant(c *gin.Context) {
+	info, err := t.Get(c.Param("tenant"))
+	if err != nil {
+		abortWithTenantError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func (t *Tenants) setQuota(c *gin.Context) {
+	var quota TenantQuota
+	err := c.ShouldBindJSON(&quota)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	info, err := t.SetQuota(c.Param("tenant"), quota)
+	if err != nil {
+		abortWithTenantError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func (t *Tenants) delete(c *gin.Context) {
+	err := t.Delete(c.Param("tenant"))
+	if err != nil {
+		abortWithTenantError(c, err)
+		return
+	}
+	Logger(c).Info("tenant deleted", "name", c.Param("tenant"))
+	c.Status(http.StatusNoContent)
+}
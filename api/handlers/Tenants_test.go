@@ -0,0 +1,168 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+// memTenantOpener Opens the tenant hives in memory, keeping them so they can be opened again.
+func memTenantOpener() handlers.TenantOpener {
+	hives := make(map[string]cfghive.Hive)
+	return func(tenant string, users bool) (cfghive.Hive, error) {
+		name := tenant
+		if users {
+			name += ".users"
+		}
+		if hive, ok := hives[name]; ok {
+			return hive, nil
+		}
+		hive, err := cfghive.NewMemHive()
+		hives[name] = hive
+		return hive, err
+	}
+}
+
+func newTenantsServer(t *testing.T, tenants *handlers.Tenants, store *handlers.UserStore) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	auth := handlers.NewAuth(store, testSecret)
+	engine := gin.New()
+	engine.Use(handlers.RequestID())
+	auth.RegisterPublic(engine)
+	tenants.RegisterServe(engine)
+	tenants.Register(engine.Group("/", auth.Middleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTenants(t *testing.T) {
+	store := newUserStore(t)
+	open := memTenantOpener()
+	tenants := handlers.NewTenants(store, testSecret, open)
+	srv := newTenantsServer(t, tenants, store)
+
+	for _, tc := range []struct {
+		user   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		// Provisioning
+		{"alice", http.MethodPost, "/tenants", `{"name": "acme"}`, http.StatusForbidden},
+		{"admin", http.MethodPost, "/tenants", `{"name": "Acme"}`, http.StatusBadRequest},
+		{"admin", http.MethodPost, "/tenants", `{"name": "acme", "quota": {"rate": 1}}`, http.StatusBadRequest},
+		{"admin", http.MethodPost, "/tenants", `{"name": "acme", "admin_password": "admin-password", "quota": {"max_keys": 2}}`, http.StatusCreated},
+		{"admin", http.MethodPost, "/tenants", `{"name": "acme", "admin_password": "admin-password"}`, http.StatusConflict},
+		{"admin", http.MethodPost, "/tenants", `{"name": "globex", "admin_password": "admin-password"}`, http.StatusCreated},
+		{"admin", http.MethodGet, "/tenants/initech", "", http.StatusNotFound},
+		// The tenant users, who are not the main ones
+		{"admin", http.MethodPost, "/t/acme/users", `{"name": "bob", "password": "bob-password"}`, http.StatusCreated},
+		{"alice", http.MethodGet, "/t/acme/hive/", "", http.StatusUnauthorized},
+		{"bob", http.MethodGet, "/hive/", "", http.StatusNotFound},
+		{"bob", http.MethodGet, "/tenants", "", http.StatusUnauthorized},
+		{"bob", http.MethodGet, "/t/globex/hive/", "", http.StatusUnauthorized},
+		{"", http.MethodGet, "/t/initech/hive/", "", http.StatusNotFound},
+		// The tenant ACL
+		{"admin", http.MethodPut, "/t/acme/acl/roles/reader", `{"rules": [{"path": "**", "access": "read"}]}`, http.StatusOK},
+		{"admin", http.MethodPut, "/t/acme/acl/users/bob", `{"roles": ["reader"]}`, http.StatusOK},
+		{"bob", http.MethodPut, "/t/acme/hive/port", `{"type": "int", "value": 1}`, http.StatusForbidden},
+		// The tenant hives, with the key quota
+		{"admin", http.MethodPut, "/t/acme/hive/port", `{"type": "int", "value": 8080}`, http.StatusNoContent},
+		{"bob", http.MethodGet, "/t/acme/hive/port", "", http.StatusOK},
+		{"admin", http.MethodGet, "/t/globex/hive/port", "", http.StatusNotFound},
+		{"admin", http.MethodPut, "/t/acme/hive/host", `{"type": "string", "value": "db"}`, http.StatusNoContent},
+		{"admin", http.MethodPut, "/t/acme/hive/user", `{"type": "string", "value": "app"}`, http.StatusForbidden},
+		{"admin", http.MethodPost, "/t/acme/import", `{"port": 8081, "user": "app"}`, http.StatusForbidden},
+		{"admin", http.MethodPut, "/t/acme/hive/host", `{"type": "string", "value": "db2"}`, http.StatusNoContent},
+		{"admin", http.MethodPut, "/tenants/acme/quota", `{"max_keys": 3, "max_value": 40}`, http.StatusOK},
+		{"admin", http.MethodPut, "/t/acme/hive/user", `{"type": "string", "value": "a rather long user name"}`, http.StatusRequestEntityTooLarge},
+		{"admin", http.MethodPut, "/t/acme/hive/user", `{"type": "string", "value": "app"}`, http.StatusNoContent},
+		{"admin", http.MethodPut, "/t/globex/hive/user", `{"type": "string", "value": "a rather long user name"}`, http.StatusNoContent},
+	} {
+		resp := userRequest(t, srv, tc.user, tc.method, tc.path, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s %s %s is %d, expected %d", tc.user, tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+	}
+
+	list := tenants.List()
+	if len(list) != 2 || list[0].Name != "acme" || list[0].Keys != 3 || list[0].Quota.MaxKeys != 3 || list[1].Keys != 1 {
+		t.Fatalf("unexpected tenants %+v", list)
+	}
+
+	// The tokens of a tenant are only accepted by it.
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	status := authRequest(t, http.MethodPost, srv.URL+"/t/acme/auth/login", `{"name": "admin", "password": "admin-password"}`, nil, &tokens)
+	if status != http.StatusOK {
+		t.Fatalf("login is %d", status)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+	for path, expected := range map[string]int{"/t/acme/hive/": http.StatusOK, "/t/globex/hive/": http.StatusUnauthorized, "/tenants": http.StatusUnauthorized} {
+		if status := authRequest(t, http.MethodGet, srv.URL+path, "", bearer, nil); status != expected {
+			t.Fatalf("%s is %d, expected %d", path, status, expected)
+		}
+	}
+
+	// A generated admin password is returned once.
+	var created struct {
+		AdminPassword string `json:"admin_password"`
+	}
+	resp := userRequest(t, srv, "admin", http.MethodPost, "/tenants", `{"name": "initech"}`)
+	json.NewDecoder(resp.Body).Decode(&created)
+	if resp.StatusCode != http.StatusCreated || created.AdminPassword == "" {
+		t.Fatalf("create is %d, %+v", resp.StatusCode, created)
+	}
+
+	// The tenants are served again from the registry.
+	reloaded := handlers.NewTenants(store, testSecret, open)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := reloaded.Get("acme")
+	if err != nil || info.Keys != 3 || info.Quota.MaxValue != 40 {
+		t.Fatalf("unexpected tenant %+v, %v", info, err)
+	}
+
+	if resp := userRequest(t, srv, "admin", http.MethodDelete, "/tenants/globex", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete is %d", resp.StatusCode)
+	}
+	if _, err := tenants.Get("globex"); !errors.Is(err, handlers.ErrTenantNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if resp := userRequest(t, srv, "admin", http.MethodGet, "/t/globex/hive/", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleted tenant is %d", resp.StatusCode)
+	}
+}
+
+func TestTenantRate(t *testing.T) {
+	store := newUserStore(t)
+	tenants := handlers.NewTenants(store, testSecret, memTenantOpener())
+	srv := newTenantsServer(t, tenants, store)
+	_, _, err := tenants.Create("acme", "admin-password", handlers.TenantQuota{Rate: 0.5, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		user := "admin"
+		if i == 1 {
+			user = "alice"
+		}
+		if resp := userRequest(t, srv, user, http.MethodGet, "/t/acme/hive/", ""); resp.StatusCode != expected {
+			t.Fatalf("request %d is %d, expected %d", i, resp.StatusCode, expected)
+		}
+	}
+	// The other tenants and the main routes have their own rates.
+	if resp := userRequest(t, srv, "admin", http.MethodGet, "/tenants/acme", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("tenant is %d", resp.StatusCode)
+	}
+}
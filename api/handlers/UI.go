@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiFiles The admin UI, a single page app calling the api routes.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiModified When the UI files were built, for the Last-Modified headers, the embedded files having none.
+var uiModified = time.Now()
+
+// RegisterUI Adds GET /ui/*path to r, serving the admin UI, index.html for /ui/. Public.
+//
+// The UI logs in with /auth/login, and calls the /hive, /users and /audit routes with the access token,
+// so its users have the access they have with the api, see ACL. It browses the hive as a tree with the
+// value types, edits the values with an input for their type, creates and deletes sub-hives, shows the
+// changes before saving them, and the history of a key from the audit log, for admins.
+func RegisterUI(r gin.IRoutes) {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	r.GET("/ui/*path", func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("path"), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		b, err := fs.ReadFile(files, name)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			abortWithError(c, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		// The UI only loads its own files, and is never framed.
+		c.Header("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Cache-Control", "no-cache")
+		http.ServeContent(c.Writer, c.Request, name, uiModified, bytes.NewReader(b))
+	})
+}
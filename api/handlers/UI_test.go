@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func TestUI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	handlers.RegisterUI(engine)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"/ui/", http.StatusOK, "text/html", `<script src="app.js"`},
+		{"/ui/app.js", http.StatusOK, "text/javascript", "function parseJSON"},
+		{"/ui/app.css", http.StatusOK, "text/css", ".diff"},
+		{"/ui/missing.js", http.StatusNotFound, "application/json", "error"},
+		{"/ui/../openapi.json", http.StatusNotFound, "application/json", "error"},
+	} {
+		resp := userRequest(t, srv, "", http.MethodGet, tc.path, "")
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tc.status || !strings.HasPrefix(resp.Header.Get("Content-Type"), tc.contentType) || !strings.Contains(string(body), tc.contains) {
+			t.Fatalf("%s is %d %s", tc.path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if tc.status == http.StatusOK && !strings.Contains(resp.Header.Get("Content-Security-Policy"), "default-src 'self'") {
+			t.Fatalf("%s has no content security policy", tc.path)
+		}
+	}
+	// /ui redirects to the page.
+	resp := userRequest(t, srv, "", http.MethodGet, "/ui", "")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/ui/" {
+		t.Fatalf("/ui is %d at %s", resp.StatusCode, resp.Request.URL.Path)
+	}
+}
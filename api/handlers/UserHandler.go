@@ -1,3 +1,164 @@
 package handlers
 
+import (
+	"errors"
+	"net/http"
 
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler Serves the user management endpoints:
+//
+//	GET    /users                 Lists the users. Admin only.
+//	POST   /users                 Creates a user from {"name", "password", "admin"}. Admin only.
+//	GET    /users/:name           Gets a user. Admins, or the user itself.
+//	PATCH  /users/:name           Grants or revokes the admin role with {"admin"}. Admin only.
+//	DELETE /users/:name           Deletes a user. Admin only.
+//	PUT    /users/:name/password  Changes a password with {"old_password", "password"}.
+//	                              Admins may reset the password of other users without the old one.
+type UserHandler struct {
+	store *UserStore
+}
+
+// NewUserHandler Creates a handler managing the users of store.
+func NewUserHandler(store *UserStore) *UserHandler {
+	return &UserHandler{store: store}
+}
+
+// Register Adds the user routes to r, which must authenticate the user.
+func (h *UserHandler) Register(r gin.IRouter) {
+	users := r.Group("/users")
+	users.GET("/:name", h.get)
+	users.PUT("/:name/password", h.setPassword)
+
+	admin := users.Group("", RequireAdmin())
+	admin.GET("", h.list)
+	admin.POST("", h.create)
+	admin.PATCH("/:name", h.update)
+	admin.DELETE("/:name", h.delete)
+}
+
+// userErrorStatus Maps a user store error to a response status.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUserExists), errors.Is(err, ErrLastAdmin):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidUser):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrBadCredentials):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func abortWithUserError(c *gin.Context, err error) {
+	abortWithError(c, userErrorStatus(err), err)
+}
+
+func (h *UserHandler) list(c *gin.Context) {
+	users, err := h.store.List()
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func (h *UserHandler) create(c *gin.Context) {
+	var body struct {
+		Name     string `json:"name" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Admin    bool   `json:"admin"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	u, err := h.store.Create(body.Name, body.Password, body.Admin)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, u)
+}
+
+func (h *UserHandler) get(c *gin.Context) {
+	name := c.Param("name")
+	if me := CurrentPrincipal(c); me == nil || !me.Admin && me.Name != name {
+		abortWithError(c, http.StatusForbidden, errors.New("admin role required"))
+		return
+	}
+	u, err := h.store.Get(name)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+func (h *UserHandler) update(c *gin.Context) {
+	var body struct {
+		Admin *bool `json:"admin" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	name := c.Param("name")
+	err = h.store.SetAdmin(name, *body.Admin)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	u, err := h.store.Get(name)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+func (h *UserHandler) delete(c *gin.Context) {
+	err := h.store.Delete(c.Param("name"))
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UserHandler) setPassword(c *gin.Context) {
+	var body struct {
+		OldPassword string `json:"old_password"`
+		Password    string `json:"password" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	name := c.Param("name")
+	me := CurrentPrincipal(c)
+	if me == nil || !me.Admin && me.Name != name {
+		abortWithError(c, http.StatusForbidden, errors.New("admin role required"))
+		return
+	}
+	// Users prove they know their password, even with a stolen session.
+	if me.Name == name {
+		_, err = h.store.Authenticate(name, body.OldPassword)
+		if err != nil {
+			abortWithUserError(c, err)
+			return
+		}
+	}
+	err = h.store.SetPassword(name, body.Password)
+	if err != nil {
+		abortWithUserError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
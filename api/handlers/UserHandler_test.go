@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newUserStore(t *testing.T) *handlers.UserStore {
+	hive, _ := cfghive.NewMemHive()
+	store := handlers.NewUserStore(hive)
+	store.Cost = bcrypt.MinCost
+	if _, err := store.Create("admin", "admin-password", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("alice", "alice-password", false); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestUserStore(t *testing.T) {
+	store := newUserStore(t)
+	if _, err := store.Create("alice", "other-password", false); !errors.Is(err, handlers.ErrUserExists) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.Create("bob", "short", false); !errors.Is(err, handlers.ErrInvalidUser) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.Create("../bob", "bob-password", false); !errors.Is(err, handlers.ErrInvalidUser) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	u, err := store.Authenticate("alice", "alice-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "alice" || u.Admin || u.Created.IsZero() {
+		t.Fatalf("unexpected user %+v", u)
+	}
+	if _, err := store.Authenticate("alice", "admin-password"); !errors.Is(err, handlers.ErrBadCredentials) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.Authenticate("nobody", "admin-password"); !errors.Is(err, handlers.ErrBadCredentials) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := store.SetPassword("alice", "new-alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate("alice", "new-alice-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete("admin"); !errors.Is(err, handlers.ErrLastAdmin) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.SetAdmin("admin", false); !errors.Is(err, handlers.ErrLastAdmin) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.SetAdmin("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("admin"); err != nil {
+		t.Fatal(err)
+	}
+	users, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "alice" || !users[0].Admin {
+		t.Fatalf("unexpected users %+v", users)
+	}
+}
+
+func userRequest(t *testing.T, srv *httptest.Server, user string, method string, path string, body string) *http.Response {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, user+"-password")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestUserHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newUserStore(t)
+	engine := gin.New()
+	handlers.NewUserHandler(store).Register(engine.Group("/", handlers.NewAuth(store, testSecret).Middleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		user   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"", http.MethodGet, "/users", "", http.StatusUnauthorized},
+		{"alice", http.MethodGet, "/users", "", http.StatusForbidden},
+		{"alice", http.MethodGet, "/users/alice", "", http.StatusOK},
+		{"alice", http.MethodGet, "/users/admin", "", http.StatusForbidden},
+		{"alice", http.MethodPost, "/users", `{"name":"bob","password":"bob-password"}`, http.StatusForbidden},
+		{"admin", http.MethodGet, "/users", "", http.StatusOK},
+		{"admin", http.MethodPost, "/users", `{"name":"bob","password":"bob-password"}`, http.StatusCreated},
+		{"admin", http.MethodPost, "/users", `{"name":"bob","password":"bob-password"}`, http.StatusConflict},
+		{"admin", http.MethodPost, "/users", `{"name":"carol","password":"short"}`, http.StatusUnprocessableEntity},
+		{"bob", http.MethodGet, "/users/bob", "", http.StatusOK},
+		{"bob", http.MethodPut, "/users/bob/password", `{"old_password":"wrong-password","password":"bob-password-2"}`, http.StatusForbidden},
+		{"bob", http.MethodPut, "/users/alice/password", `{"password":"alice-password-2"}`, http.StatusForbidden},
+		{"admin", http.MethodPatch, "/users/bob", `{"admin":true}`, http.StatusOK},
+		{"bob", http.MethodDelete, "/users/alice", "", http.StatusNoContent},
+		{"admin", http.MethodDelete, "/users/alice", "", http.StatusNotFound},
+		{"admin", http.MethodPut, "/users/bob/password", `{"password":"bob-password-2"}`, http.StatusNoContent},
+		{"bob", http.MethodGet, "/users/bob", "", http.StatusUnauthorized},
+	} {
+		resp := userRequest(t, srv, tc.user, tc.method, tc.path, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s %s: status %d, expected %d", tc.user, tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+	}
+
+	resp := userRequest(t, srv, "admin", http.MethodGet, "/users", "")
+	var body struct {
+		Users []handlers.User `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Users) != 2 || body.Users[1].Name != "bob" || !body.Users[1].Admin {
+		t.Fatalf("unexpected users %+v", body.Users)
+	}
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by UserStore.
+var (
+	ErrUserNotFound   = errors.New("user does not exist")
+	ErrUserExists     = errors.New("user already exists")
+	ErrInvalidUser    = errors.New("invalid user")
+	ErrLastAdmin      = errors.New("the last admin cannot be removed")
+	ErrBadCredentials = errors.New("invalid user name or password")
+	ErrAPIKeyNotFound = errors.New("api key does not exist")
+)
+
+// apiKeyPrefix Starts every api key, so they are told apart from other tokens.
+const apiKeyPrefix = "hk_"
+
+// MinPasswordLength The length passwords must have at least.
+const MinPasswordLength = 8
+
+var userNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// User A user of the api.
+type User struct {
+	Name    string    `json:"name"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+	// Tokens issued for an older generation are revoked, it increases when the password changes.
+	tokenGen int64
+	// Random, set when the user is created, so the tokens of a deleted user are not valid for a new one
+	// with the same name.
+	nonce string
+}
+
+// APIKey A long-lived key a service authenticates with, acting as the user owning it.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Label   string    `json:"label"`
+	Created time.Time `json:"created"`
+}
+
+// UserStore Stores the users in a hive, one sub-hive per user holding its bcrypt password hash,
+// and its api keys in the "keys" sub-hive, by id, holding the SHA-256 hash of their secret.
+// Every change is committed.
+type UserStore struct {
+	hive *cfghive.SyncHive
+	// The bcrypt cost of new password hashes.
+	Cost int
+}
+
+// NewUserStore Creates a store of the users in hive.
+func NewUserStore(hive cfghive.Hive) *UserStore {
+	sh, ok := hive.(*cfghive.SyncHive)
+	if !ok {
+		sh = cfghive.NewSyncHive(hive)
+	}
+	return &UserStore{hive: sh, Cost: bcrypt.DefaultCost}
+}
+
+func checkUserName(name string) error {
+	if !userNameRe.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be 1 to 64 letters, digits or ._@-", ErrInvalidUser, name)
+	}
+	return nil
+}
+
+func checkPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: password must be at least %d characters", ErrInvalidUser, MinPasswordLength)
+	}
+	// bcrypt ignores what is after 72 bytes.
+	if len(password) > 72 {
+		return fmt.Errorf("%w: password must be at most 72 bytes", ErrInvalidUser)
+	}
+	return nil
+}
+
+// readUser Reads a user and its hash, with the hive lock held.
+func readUser(hive cfghive.Hive, name string) (*User, []byte, error) {
+	if checkUserName(name) != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	v, err := hive.Get(name)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		return nil, nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		return nil, nil, err
+	}
+	u := &User{Name: name}
+	hash := sub["hash"]
+	h, err := hash.String()
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has no password hash", name)
+	}
+	admin := sub["admin"]
+	u.Admin, _ = admin.Bool()
+	created := sub["created"]
+	if t, err := created.Int64(); err == nil {
+		u.Created = time.Unix(t, 0).UTC()
+	}
+	tokenGen := sub["token_gen"]
+	u.tokenGen, _ = tokenGen.Int64()
+	nonce := sub["nonce"]
+	u.nonce, _ = nonce.String()
+	return u, []byte(h), nil
+}
+
+// adminCount Counts the admins, with the hive lock held.
+func adminCount(hive cfghive.Hive) int {
+	n := 0
+	for name, v := range *hive.GetData() {
+		sub, err := v.Sub()
+		// Other keys, like the ACL, are not users.
+		if err != nil || checkUserName(name) != nil {
+			continue
+		}
+		if admin, ok := sub["admin"]; ok {
+			if b, _ := admin.Bool(); b {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// commit Commits the changes of fn, or rolls them back if it fails.
+func (s *UserStore) commit(fn func(hive cfghive.Hive) error) error {
+	return s.hive.Do(func(hive cfghive.Hive) error {
+		err := fn(hive)
+		if err != nil {
+			hive.Rollback()
+			return err
+		}
+		_, err = hive.Commit()
+		return err
+	})
+}
+
+// Create Adds a user.
+func (s *UserStore) Create(name string, password string, admin bool) (*User, error) {
+	err := checkUserName(name)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.Cost)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(12)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{Name: name, Admin: admin, Created: time.Now().UTC().Truncate(time.Second), nonce: nonce}
+	err = s.commit(func(hive cfghive.Hive) error {
+		_, err := hive.Get(name)
+		if err == nil {
+			return fmt.Errorf("%s: %w", name, ErrUserExists)
+		}
+		return hive.Set(name, map[string]interface{}{
+			"hash":    string(hash),
+			"admin":   admin,
+			"created": u.Created.Unix(),
+			"nonce":   nonce,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get Gets a user.
+func (s *UserStore) Get(name string) (*User, error) {
+	var u *User
+	err := s.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		u, _, err = readUser(hive, name)
+		return err
+	})
+	return u, err
+}
+
+// List Gets the users, sorted by name.
+func (s *UserStore) List() ([]User, error) {
+	var users []User
+	err := s.hive.Do(func(hive cfghive.Hive) error {
+		for name := range *hive.GetData() {
+			// Other keys, like the ACL, are not user names.
+			if checkUserName(name) != nil {
+				continue
+			}
+			u, _, err := readUser(hive, name)
+			if err != nil {
+				return err
+			}
+			users = append(users, *u)
+		}
+		return nil
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users, err
+}
+
+// Empty Reports whether there are no users.
+func (s *UserStore) Empty() bool {
+	for name := range *s.hive.GetData() {
+		if checkUserName(name) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAdmin Grants or revokes the admin role of a user. The last admin cannot be revoked.
+func (s *UserStore) SetAdmin(name string, admin bool) error {
+	return s.commit(func(hive cfghive.Hive) error {
+		u, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		if u.Admin && !admin && adminCount(hive) == 1 {
+			return ErrLastAdmin
+		}
+		return hive.SetBool(name+"/admin", admin)
+	})
+}
+
+// SetPassword Changes the password of a user, and revokes the tokens issued to the user.
+func (s *UserStore) SetPassword(name string, password string) error {
+	err := checkPassword(password)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.Cost)
+	if err != nil {
+		return err
+	}
+	return s.commit(func(hive cfghive.Hive) error {
+		u, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		err = hive.SetString(name+"/hash", string(hash))
+		if err != nil {
+			return err
+		}
+		return hive.Set(name+"/token_gen", u.tokenGen+1)
+	})
+}
+
+// Delete Removes a user and its roles, see ACL. The last admin cannot be removed.
+func (s *UserStore) Delete(name string) error {
+	return s.commit(func(hive cfghive.Hive) error {
+		u, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		if u.Admin && adminCount(hive) == 1 {
+			return ErrLastAdmin
+		}
+		hive.Delete(name)
+		hive.Delete(aclUsersKey + "/" + name)
+		return nil
+	})
+}
+
+// Authenticate Gets the user if the password is right.
+func (s *UserStore) Authenticate(name string, password string) (*User, error) {
+	var u *User
+	var hash []byte
+	err := s.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		u, hash, err = readUser(hive, name)
+		return err
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		// Hash anyway, so the response time does not reveal the user does not exist.
+		bcrypt.GenerateFromPassword([]byte(password), s.Cost)
+		return nil, ErrBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrBadCredentials
+	}
+	return u, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateAPIKey Creates an api key for a user.
+// Returns the key, which is only known by its hash afterwards.
+func (s *UserStore) CreateAPIKey(name string, label string) (*APIKey, string, error) {
+	if len(label) > 64 {
+		return nil, "", fmt.Errorf("%w: label must be at most 64 characters", ErrInvalidUser)
+	}
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	k := &APIKey{ID: hex.EncodeToString(b), Label: label, Created: time.Now().UTC().Truncate(time.Second)}
+	err = s.commit(func(hive cfghive.Hive) error {
+		_, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		if _, err := hive.Get(name + "/keys"); err != nil {
+			hive.NewSub(name + "/keys")
+		}
+		return hive.Set(name+"/keys/"+k.ID, map[string]interface{}{
+			"hash":    hashAPIKeySecret(secret),
+			"label":   label,
+			"created": k.Created.Unix(),
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return k, apiKeyPrefix + name + "." + k.ID + "." + secret, nil
+}
+
+// readAPIKeys Reads the api keys of a user, and their hash, with the hive lock held.
+func readAPIKeys(hive cfghive.Hive, name string) (map[string]APIKey, map[string]string) {
+	keys := make(map[string]APIKey)
+	hashes := make(map[string]string)
+	v, err := hive.Get(name + "/keys")
+	if err != nil {
+		return keys, hashes
+	}
+	sub, _ := v.Sub()
+	for id, kv := range sub {
+		ksub, err := kv.Sub()
+		if err != nil {
+			continue
+		}
+		k := APIKey{ID: id}
+		label := ksub["label"]
+		k.Label, _ = label.String()
+		created := ksub["created"]
+		if t, err := created.Int64(); err == nil {
+			k.Created = time.Unix(t, 0).UTC()
+		}
+		hash := ksub["hash"]
+		hashes[id], _ = hash.String()
+		keys[id] = k
+	}
+	return keys, hashes
+}
+
+// ListAPIKeys Gets the api keys of a user, sorted by creation.
+func (s *UserStore) ListAPIKeys(name string) ([]APIKey, error) {
+	var keys []APIKey
+	err := s.hive.Do(func(hive cfghive.Hive) error {
+		_, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		byID, _ := readAPIKeys(hive, name)
+		for _, k := range byID {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, err
+}
+
+// DeleteAPIKey Revokes an api key of a user.
+func (s *UserStore) DeleteAPIKey(name string, id string) error {
+	return s.commit(func(hive cfghive.Hive) error {
+		_, _, err := readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		keys, _ := readAPIKeys(hive, name)
+		if _, ok := keys[id]; !ok {
+			return fmt.Errorf("%s: %w", id, ErrAPIKeyNotFound)
+		}
+		hive.Delete(name + "/keys/" + id)
+		return nil
+	})
+}
+
+// AuthenticateAPIKey Gets the user owning an api key, and the key.
+func (s *UserStore) AuthenticateAPIKey(key string) (*User, *APIKey, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return nil, nil, ErrBadCredentials
+	}
+	// User names may contain dots, ids and secrets do not.
+	i := strings.LastIndexByte(rest, '.')
+	j := -1
+	if i > 0 {
+		j = strings.LastIndexByte(rest[:i], '.')
+	}
+	if j <= 0 {
+		return nil, nil, ErrBadCredentials
+	}
+	name, id, secret := rest[:j], rest[j+1:i], rest[i+1:]
+	var u *User
+	var k APIKey
+	var hash string
+	err := s.hive.Do(func(hive cfghive.Hive) error {
+		var err error
+		u, _, err = readUser(hive, name)
+		if err != nil {
+			return err
+		}
+		keys, hashes := readAPIKeys(hive, name)
+		k, ok = keys[id]
+		hash = hashes[id]
+		return nil
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrBadCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, nil, ErrBadCredentials
+	}
+	return u, &k, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// ValueHandler Serves a string value per user, stored in a hive by user name:
+//
+//	POST /admin       Sets the value of the principal with {"value"}.
+//	GET  /user/:name  Gets the value of a user, as {"user", "value"}, or {"user", "status": "no value"}.
+//	                  Admins, or the user itself.
+//
+// Every change is committed.
+type ValueHandler struct {
+	hive *cfghive.SyncHive
+}
+
+// NewValueHandler Creates a handler storing the values in hive.
+func NewValueHandler(hive cfghive.Hive) *ValueHandler {
+	sh, ok := hive.(*cfghive.SyncHive)
+	if !ok {
+		sh = cfghive.NewSyncHive(hive)
+	}
+	return &ValueHandler{hive: sh}
+}
+
+// Register Adds the value routes to r, which must authenticate the principal.
+func (h *ValueHandler) Register(r gin.IRoutes) {
+	r.POST("/admin", h.set)
+	r.GET("/user/:name", h.get)
+}
+
+func (h *ValueHandler) set(c *gin.Context) {
+	user := CurrentPrincipal(c).Name
+
+	// Parse JSON
+	var json struct {
+		Value string `json:"value" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.hive.Do(func(hive cfghive.Hive) error {
+		err := hive.SetString(user, json.Value)
+		if err != nil {
+			hive.Rollback()
+			return err
+		}
+		_, err = hive.Commit()
+		return err
+	})
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *ValueHandler) get(c *gin.Context) {
+	name := c.Param("name")
+	if me := CurrentPrincipal(c); !me.Admin && me.Name != name {
+		abortWithError(c, http.StatusForbidden, errors.New("admin role required"))
+		return
+	}
+	if checkUserName(name) != nil {
+		c.JSON(http.StatusOK, gin.H{"user": name, "status": "no value"})
+		return
+	}
+	value, err := h.hive.GetString(name)
+	if errors.Is(err, cfghive.ErrKeyNotFound) {
+		c.JSON(http.StatusOK, gin.H{"user": name, "status": "no value"})
+		return
+	}
+	if err != nil {
+		abortWithHiveError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": name, "value": *value})
+}
@@ -0,0 +1,76 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+func newValueServer(t *testing.T, store *handlers.UserStore, spec string) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	hive, err := cfghive.OpenHive(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	handlers.NewValueHandler(hive).Register(engine.Group("/", handlers.NewAuth(store, testSecret).Middleware()))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestValueHandler(t *testing.T) {
+	store := newUserStore(t)
+	spec := "bin:" + filepath.Join(t.TempDir(), "values.bin")
+	srv := newValueServer(t, store, spec)
+
+	var wg sync.WaitGroup
+	for _, user := range []string{"admin", "alice", "alice", "admin"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			resp := userRequest(t, srv, user, http.MethodPost, "/admin", `{"value":"`+user+`-value"}`)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s: status %d", user, resp.StatusCode)
+			}
+		}(user)
+	}
+	wg.Wait()
+	if resp := userRequest(t, srv, "alice", http.MethodPost, "/admin", `{}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if resp := userRequest(t, srv, "alice", http.MethodGet, "/user/admin", ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	// The values survive a restart.
+	srv = newValueServer(t, store, spec)
+	for _, tc := range []struct {
+		path   string
+		value  string
+		status string
+	}{
+		{"/user/alice", "alice-value", ""},
+		{"/user/admin", "admin-value", ""},
+		{"/user/nobody", "", "no value"},
+	} {
+		resp := userRequest(t, srv, "admin", http.MethodGet, tc.path, "")
+		var body struct {
+			Value  string `json:"value"`
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || body.Value != tc.value || body.Status != tc.status {
+			t.Errorf("%s: status %d, body %+v", tc.path, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+// The admin UI of the api server, see handlers.RegisterUI.
+//
+// It calls the api routes with the access token of the user, so the user has the access
+// they have with the api. The values are typed JSON values, see cfghive.HiveValue.MarshalJSON,
+// whose numbers are kept as text, as int64 values do not fit in a JS number.
+"use strict";
+
+const INT_TYPES = ["byte", "int", "int64", "uint", "uint64"];
+const FLOAT_TYPES = ["float32", "float64"];
+// The types of the values, sub-hives being created on their own.
+const VALUE_TYPES = ["string", "bool", ...INT_TYPES, ...FLOAT_TYPES, "bytes"];
+const INT_RANGES = {
+  byte: [0n, 255n],
+  int: [-(2n ** 63n), 2n ** 63n - 1n],
+  int64: [-(2n ** 63n), 2n ** 63n - 1n],
+  uint: [0n, 2n ** 64n - 1n],
+  uint64: [0n, 2n ** 64n - 1n],
+};
+
+const $ = (selector) => document.querySelector(selector);
+
+// el Creates an element, with its attributes and children. "text" sets the text,
+// and "on<event>" attributes add listeners.
+function el(tag, attrs = {}, ...children) {
+  const e = document.createElement(tag);
+  for (const [name, value] of Object.entries(attrs)) {
+    if (name === "text") {
+      e.textContent = value;
+    } else if (name.startsWith("on")) {
+      e.addEventListener(name.slice(2), value);
+    } else if (value === true) {
+      e.setAttribute(name, "");
+    } else if (value !== false && value !== undefined) {
+      e.setAttribute(name, value);
+    }
+  }
+  e.append(...children);
+  return e;
+}
+
+// Num A JSON number, as its text.
+class Num {
+  constructor(text) {
+    this.text = text;
+  }
+
+  toString() {
+    return this.text;
+  }
+}
+
+// parseJSON Parses JSON, keeping the numbers as Num.
+function parseJSON(text) {
+  let i = 0;
+  const tokens = {
+    string: /"(?:[^"\\]|\\.)*"/y,
+    number: /-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?/y,
+    space: /\s*/y,
+  };
+  const match = (re) => {
+    re.lastIndex = i;
+    const m = re.exec(text);
+    if (m) {
+      i = re.lastIndex;
+    }
+    return m && m[0];
+  };
+  const fail = () => {
+    throw new SyntaxError("invalid JSON at " + i);
+  };
+  const expect = (c) => {
+    match(tokens.space);
+    if (text[i] !== c) {
+      fail();
+    }
+    i++;
+  };
+  const string = () => {
+    match(tokens.space);
+    const s = match(tokens.string);
+    return s === null ? fail() : JSON.parse(s);
+  };
+  const value = () => {
+    match(tokens.space);
+    if (text[i] === "{" || text[i] === "[") {
+      const isObject = text[i++] === "{";
+      const close = isObject ? "}" : "]";
+      const out = isObject ? {} : [];
+      match(tokens.space);
+      if (text[i] === close) {
+        i++;
+        return out;
+      }
+      for (;;) {
+        if (isObject) {
+          const key = string();
+          expect(":");
+          out[key] = value();
+        } else {
+          out.push(value());
+        }
+        match(tokens.space);
+        if (text[i] !== ",") {
+          break;
+        }
+        i++;
+      }
+      expect(close);
+      return out;
+    }
+    if (text[i] === '"') {
+      return string();
+    }
+    const n = match(tokens.number);
+    if (n !== null) {
+      return new Num(n);
+    }
+    for (const [word, v] of [["true", true], ["false", false], ["null", null]]) {
+      if (text.startsWith(word, i)) {
+        i += word.length;
+        return v;
+      }
+    }
+    return fail();
+  };
+  const v = value();
+  match(tokens.space);
+  if (i !== text.length) {
+    fail();
+  }
+  return v;
+}
+
+// stringify Encodes a value parsed by parseJSON.
+function stringify(v) {
+  if (v instanceof Num) {
+    return v.text;
+  }
+  if (Array.isArray(v)) {
+    return "[" + v.map(stringify).join(",") + "]";
+  }
+  if (v !== null && typeof v === "object") {
+    return "{" + Object.entries(v).map(([k, x]) => JSON.stringify(k) + ":" + stringify(x)).join(",") + "}";
+  }
+  return JSON.stringify(v);
+}
+
+// The tokens of the user, kept for the browser tab.
+const session = {
+  get: () => JSON.parse(sessionStorage.getItem("session") || "null"),
+  set: (s) => sessionStorage.setItem("session", JSON.stringify(s)),
+  clear: () => sessionStorage.removeItem("session"),
+};
+
+class APIError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+// post Posts a JSON body to a public route, and gets the JSON response.
+async function post(path, body) {
+  const resp = await fetch(".." + path, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(body),
+  });
+  if (!resp.ok) {
+    throw await responseError(resp);
+  }
+  return resp.json();
+}
+
+// responseError Gets the error of a failed response.
+async function responseError(resp) {
+  let message = resp.statusText;
+  try {
+    message = (await resp.json()).error || message;
+  } catch (e) {
+    // Not a JSON error.
+  }
+  if (resp.status === 412) {
+    message = "The key was changed since it was loaded, reload it. (" + message + ")";
+  }
+  return new APIError(resp.status, message);
+}
+
+// api Calls a route with the access token, refreshing the tokens once if it expired.
+async function api(method, path, { body, headers = {} } = {}) {
+  for (let retry = false; ; retry = true) {
+    const s = session.get();
+    if (!s) {
+      throw new APIError(401, "Not logged in.");
+    }
+    const h = { ...headers, Authorization: "Bearer " + s.access };
+    if (body !== undefined) {
+      h["Content-Type"] = "application/json";
+    }
+    const resp = await fetch(".." + path, { method, headers: h, body });
+    if (resp.status === 401 && !retry) {
+      try {
+        const t = await post("/auth/refresh", { refresh_token: s.refresh });
+        session.set({ ...s, access: t.access_token, refresh: t.refresh_token });
+        continue;
+      } catch (e) {
+        logout();
+      }
+    }
+    if (!resp.ok) {
+      throw await responseError(resp);
+    }
+    return resp;
+  }
+}
+
+function showError(e) {
+  const box = $("#error");
+  box.textContent = e.message;
+  box.hidden = false;
+  clearTimeout(showError.timer);
+  showError.timer = setTimeout(() => (box.hidden = true), 10000);
+}
+
+// guard Runs an async event handler, showing its errors.
+function guard(fn) {
+  return (event) => {
+    event.preventDefault();
+    fn(event).catch(showError);
+  };
+}
+
+// keyPath Gets the /hive route of a key.
+function keyPath(key) {
+  return "/hive/" + key.split("/").filter(Boolean).map(encodeURIComponent).join("/");
+}
+
+function joinKey(parent, name) {
+  return parent ? parent + "/" + name : name;
+}
+
+function parentKey(key) {
+  return key.includes("/") ? key.slice(0, key.lastIndexOf("/")) : "";
+}
+
+// show Formats a typed value other than a sub-hive.
+function show(tv) {
+  return tv.type === "string" ? JSON.stringify(tv.value) : String(tv.value);
+}
+
+// lines Gets the lines showing a typed value, one per value of the sub-hives, for the diffs.
+function lines(tv, name = "") {
+  if (!tv) {
+    return [];
+  }
+  const label = name ? name + " = " : "";
+  if (tv.type === "sub") {
+    const keys = Object.keys(tv.value).sort();
+    if (keys.length === 0) {
+      return [label + "sub (empty)"];
+    }
+    return keys.flatMap((k) => lines(tv.value[k], joinKey(name, k)));
+  }
+  return [label + tv.type + " " + show(tv)];
+}
+
+// diff Gets the lines removed from a, added from b, and kept, in order.
+function diff(a, b) {
+  const n = a.length;
+  const m = b.length;
+  if (n * m > 4e6) {
+    return [...a.map((l) => ["del", l]), ...b.map((l) => ["add", l])];
+  }
+  // lcs[i][j] The length of the longest common subsequence of a[i:] and b[j:].
+  const lcs = Array.from({ length: n + 1 }, () => new Uint32Array(m + 1));
+  for (let i = n - 1; i >= 0; i--) {
+    for (let j = m - 1; j >= 0; j--) {
+      lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+    }
+  }
+  const out = [];
+  let i = 0;
+  let j = 0;
+  while (i < n || j < m) {
+    if (i < n && j < m && a[i] === b[j]) {
+      out.push(["same", a[i]]);
+      i++;
+      j++;
+    } else if (i < n && (j === m || lcs[i + 1][j] >= lcs[i][j + 1])) {
+      out.push(["del", a[i++]]);
+    } else {
+      out.push(["add", b[j++]]);
+    }
+  }
+  return out;
+}
+
+// diffView Shows the changes from the typed value old to now, either of them null if there is none.
+function diffView(old, now) {
+  const view = el("div", { class: "diff" });
+  const marks = { add: "+ ", del: "- ", same: "  " };
+  for (const [kind, line] of diff(lines(old), lines(now))) {
+    view.append(el("span", { class: kind, text: marks[kind] + line }));
+  }
+  if (!view.hasChildNodes()) {
+    view.append("No value.");
+  }
+  return view;
+}
+
+// editor Creates the input of a value of type, with the value of tv if any.
+// Its read method gets the typed value, or throws if the input is not valid.
+function editor(type, tv) {
+  const value = tv ? tv.value : undefined;
+  if (type === "bool") {
+    const input = el("input", { type: "checkbox", checked: value === true });
+    return { node: el("label", {}, input, " true"), read: () => ({ type, value: input.checked }) };
+  }
+  if (type === "string" || type === "bytes") {
+    const input = el("textarea", { spellcheck: "false" });
+    input.value = value === undefined ? "" : value;
+    const node = type === "bytes" ? el("label", {}, "Base64 ", input) : input;
+    return {
+      node,
+      read: () => {
+        if (type === "bytes") {
+          const b64 = input.value.replace(/\s/g, "");
+          try {
+            atob(b64);
+          } catch (e) {
+            throw new Error("The bytes are not valid base64.");
+          }
+          return { type, value: b64 };
+        }
+        return { type, value: input.value };
+      },
+    };
+  }
+  const input = el("input", { inputmode: "decimal", spellcheck: "false" });
+  input.value = value === undefined ? "0" : String(value);
+  return {
+    node: input,
+    read: () => {
+      const text = input.value.trim();
+      if (INT_TYPES.includes(type)) {
+        const [min, max] = INT_RANGES[type];
+        if (!/^-?\d+$/.test(text) || BigInt(text) < min || BigInt(text) > max) {
+          throw new Error(`${text} is not a ${type}, from ${min} to ${max}.`);
+        }
+        return { type, value: new Num(BigInt(text).toString()) };
+      }
+      const f = Number(text);
+      if (text === "" || !Number.isFinite(f) || (type === "float32" && Math.abs(f) > 3.4028234663852886e38)) {
+        throw new Error(`${text} is not a ${type}.`);
+      }
+      return { type, value: new Num(String(f)) };
+    },
+  };
+}
+
+// typeBadge Shows the type of a value.
+function typeBadge(type) {
+  return el("span", { class: "type", text: type });
+}
+
+// treeItems The tree items, by key.
+const treeItems = new Map();
+
+// treeItem Creates the tree item of a key, expandable for sub-hives.
+function treeItem(key, name, type) {
+  const toggle = el("span", { class: "toggle", text: type === "sub" ? "▸" : "" });
+  const label = el("span", {}, toggle, name, typeBadge(type));
+  const item = el("li", {}, label);
+  item.label = label;
+  item.toggle = toggle;
+  treeItems.set(key, item);
+  label.addEventListener("click", guard(() => select(key)));
+  if (type === "sub") {
+    toggle.addEventListener("click", guard((event) => {
+      event.stopPropagation();
+      return expand(key, !item.querySelector(":scope > ul"));
+    }));
+  }
+  return item;
+}
+
+// expand Lists the children of a sub-hive in the tree, or hides them.
+async function expand(key, open) {
+  const item = treeItems.get(key);
+  const list = item.querySelector(":scope > ul");
+  if (list) {
+    list.remove();
+  }
+  item.toggle.textContent = open ? "▾" : "▸";
+  if (!open) {
+    return;
+  }
+  const resp = await api("GET", keyPath(key) + "?children");
+  const { children } = await resp.json();
+  const ul = el("ul", { class: "tree" });
+  for (const child of children) {
+    ul.append(treeItem(joinKey(key, child.name), child.name, child.type));
+  }
+  item.append(ul);
+}
+
+// reloadTree Lists the children of a sub-hive again, if they are shown.
+async function reloadTree(key) {
+  const item = treeItems.get(key);
+  if (item && item.querySelector(":scope > ul")) {
+    await expand(key, true);
+  }
+}
+
+// openTree Shows the tree of a key.
+async function openTree(key) {
+  key = key.split("/").filter(Boolean).join("/");
+  treeItems.clear();
+  $("#tree").replaceChildren();
+  $("#panel").replaceChildren();
+  const resp = await api("GET", keyPath(key));
+  const tv = parseJSON(await resp.text());
+  $("#tree").append(treeItem(key, "/" + key, tv.type));
+  if (tv.type === "sub") {
+    await expand(key, true);
+  }
+  await select(key);
+}
+
+// select Shows a key in the panel.
+async function select(key) {
+  for (const item of treeItems.values()) {
+    item.label.classList.remove("selected");
+  }
+  const item = treeItems.get(key);
+  if (item) {
+    item.label.classList.add("selected");
+  }
+  const resp = await api("GET", keyPath(key));
+  const etag = resp.headers.get("ETag");
+  const tv = parseJSON(await resp.text());
+  const panel = $("#panel");
+  panel.replaceChildren(el("h2", { text: "/" + key }), el("p", {}, "Type ", typeBadge(tv.type)));
+  const actions = el("div", { class: "actions" });
+  const details = el("div");
+  if (tv.type === "sub") {
+    panel.append(el("p", { text: Object.keys(tv.value).length + " values" }));
+    panel.append(newValueForm(key), newSubForm(key));
+  } else {
+    const edit = editor(tv.type, tv);
+    panel.append(el("div", { class: "editor" }, edit.node));
+    actions.append(el("button", {
+      type: "button",
+      text: "Review changes",
+      onclick: guard(async () => {
+        const now = edit.read();
+        details.replaceChildren(el("h3", { text: "Changes" }), diffView(tv, now), el("button", {
+          type: "button",
+          text: "Save",
+          onclick: guard(async () => {
+            await api("PUT", keyPath(key), { body: stringify(edit.read()), headers: { "If-Match": etag } });
+            await select(key);
+          }),
+        }));
+      }),
+    }));
+  }
+  if (key !== "") {
+    actions.append(el("button", {
+      type: "button",
+      text: tv.type === "sub" ? "Delete sub-hive" : "Delete",
+      onclick: guard(async () => {
+        if (!confirm(`Delete /${key} and everything under it?`)) {
+          return;
+        }
+        await api("DELETE", keyPath(key), { headers: { "If-Match": etag } });
+        treeItems.get(key)?.remove();
+        treeItems.delete(key);
+        await select(parentKey(key));
+      }),
+    }));
+  }
+  if (session.get().admin) {
+    actions.append(el("button", { type: "button", text: "History", onclick: guard(() => showHistory(key, details)) }));
+  }
+  panel.append(actions, details);
+}
+
+// newValueForm Creates the form adding a value to a sub-hive.
+function newValueForm(key) {
+  const name = el("input", { name: "name", required: true });
+  const type = el("select", {}, ...VALUE_TYPES.map((t) => el("option", { value: t, text: t })));
+  let edit = editor(type.value);
+  const slot = el("div", { class: "editor" }, edit.node);
+  type.addEventListener("change", () => {
+    edit = editor(type.value);
+    slot.replaceChildren(edit.node);
+  });
+  return el("form", {
+    onsubmit: guard(async () => {
+      const child = joinKey(key, name.value.trim());
+      await api("PUT", keyPath(child), { body: stringify(edit.read()), headers: { "If-None-Match": "*" } });
+      await reloadTree(key);
+      await select(child);
+    }),
+  }, el("fieldset", {}, el("legend", { text: "New value" }),
+    el("label", {}, "Name ", name), " ", el("label", {}, "Type ", type), slot,
+    el("button", { type: "submit", text: "Add" })));
+}
+
+// newSubForm Creates the form adding a sub-hive to a sub-hive.
+function newSubForm(key) {
+  const name = el("input", { name: "name", required: true });
+  return el("form", {
+    onsubmit: guard(async () => {
+      const child = joinKey(key, name.value.trim());
+      await api("POST", keyPath(child));
+      await reloadTree(key);
+      await select(child);
+    }),
+  }, el("fieldset", {}, el("legend", { text: "New sub-hive" }),
+    el("label", {}, "Name ", name), " ", el("button", { type: "submit", text: "Create" })));
+}
+
+// showHistory Shows the changes of a key and of its children from the audit log, the last first.
+async function showHistory(key, box) {
+  let entries;
+  try {
+    const resp = await api("GET", "/audit?tenant=-&limit=1000&key=" + encodeURIComponent(key));
+    entries = parseJSON(await resp.text()).entries;
+  } catch (e) {
+    if (e.status !== 404) {
+      throw e;
+    }
+    box.replaceChildren(el("p", { text: "The server keeps no audit log." }));
+    return;
+  }
+  const rows = entries.reverse().map((e) => el("tr", {},
+    el("td", { text: new Date(e.time).toLocaleString() }),
+    el("td", { text: e.user || "" }),
+    el("td", { text: e.op }),
+    el("td", { text: "/" + (e.key || "") }),
+    el("td", {}, e.op === "rollback" ? "The hive was rolled back." : diffView(e.old, e.new))));
+  box.replaceChildren(el("h3", { text: "History" }), rows.length === 0 ? el("p", { text: "No changes." }) :
+    el("table", { class: "history" },
+      el("thead", {}, el("tr", {}, ...["Time", "User", "Change", "Key", "Values"].map((h) => el("th", { text: h })))),
+      el("tbody", {}, ...rows)));
+}
+
+// start Shows the hive, or the login form if the user is not logged in.
+async function start() {
+  const s = session.get();
+  $("#login").hidden = !!s;
+  $("#app").hidden = !s;
+  $("#logout").hidden = !s;
+  $("#whoami").textContent = s ? s.name + (s.admin ? " (admin)" : "") : "";
+  if (!s) {
+    return;
+  }
+  try {
+    await openTree("");
+  } catch (e) {
+    if (e.status !== 403) {
+      throw e;
+    }
+    // The roles of the user may only cover some keys, e.g. app/**, see ACL.
+    $("#panel").replaceChildren(el("p", { text: "You cannot read the whole hive, open a key you can read." }));
+    $("#open").elements.key.focus();
+  }
+}
+
+function logout() {
+  session.clear();
+  start().catch(showError);
+}
+
+$("#login").addEventListener("submit", guard(async (event) => {
+  const form = event.target;
+  const name = form.elements.name.value;
+  const t = await post("/auth/login", { name, password: form.elements.password.value });
+  form.reset();
+  session.set({ name, access: t.access_token, refresh: t.refresh_token, admin: false });
+  const resp = await api("GET", "/users/" + encodeURIComponent(name));
+  session.set({ ...session.get(), admin: (await resp.json()).admin === true });
+  await start();
+}));
+$("#open").addEventListener("submit", guard(() => openTree($("#open").elements.key.value)));
+$("#logout").addEventListener("click", logout);
+$("#error").addEventListener("click", () => ($("#error").hidden = true));
+start().catch(showError);
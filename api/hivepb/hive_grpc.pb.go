@@ -0,0 +1,362 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v4.25.3
+// source: hive.proto
+
+package hivepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	HiveService_Get_FullMethodName    = "/potentialframework.hive.v1.HiveService/Get"
+	HiveService_Set_FullMethodName    = "/potentialframework.hive.v1.HiveService/Set"
+	HiveService_Delete_FullMethodName = "/potentialframework.hive.v1.HiveService/Delete"
+	HiveService_List_FullMethodName   = "/potentialframework.hive.v1.HiveService/List"
+	HiveService_Watch_FullMethodName  = "/potentialframework.hive.v1.HiveService/Watch"
+	HiveService_Txn_FullMethodName    = "/potentialframework.hive.v1.HiveService/Txn"
+)
+
+// HiveServiceClient is the client API for HiveService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// HiveService Serves the hive of the api server, as the /hive routes do, with the same users,
+// roles and limits. Requests are authenticated with the "authorization" metadata, a bearer
+// access token or api key, or basic credentials, with the "x-api-key" metadata, or with a TLS
+// client certificate.
+//
+// Errors are gRPC statuses: NOT_FOUND for missing keys, INVALID_ARGUMENT for invalid keys and
+// values, FAILED_PRECONDITION for read-only hives, for parents that are not sub-hives and for
+// revisions that do not match, ALREADY_EXISTS for new sub-hives over existing keys,
+// UNAUTHENTICATED, PERMISSION_DENIED, and RESOURCE_EXHAUSTED for rate limits, values too large
+// and key quotas.
+type HiveServiceClient interface {
+	// Get Gets a value, or the whole hive for the empty key.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Set Sets a value.
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+	// Delete Deletes a value or a sub-hive.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// List Lists the values of a sub-hive, sorted by name.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Watch Streams the changes under a key.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (HiveService_WatchClient, error)
+	// Txn Applies operations all at once if every condition holds.
+	Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error)
+}
+
+type hiveServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHiveServiceClient(cc grpc.ClientConnInterface) HiveServiceClient {
+	return &hiveServiceClient{cc}
+}
+
+func (c *hiveServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, HiveService_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hiveServiceClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetResponse)
+	err := c.cc.Invoke(ctx, HiveService_Set_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hiveServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, HiveService_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hiveServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, HiveService_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hiveServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (HiveService_WatchClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &HiveService_ServiceDesc.Streams[0], HiveService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hiveServiceWatchClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type HiveService_WatchClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type hiveServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *hiveServiceWatchClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *hiveServiceClient) Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TxnResponse)
+	err := c.cc.Invoke(ctx, HiveService_Txn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HiveServiceServer is the server API for HiveService service.
+// All implementations must embed UnimplementedHiveServiceServer
+// for forward compatibility
+//
+// HiveService Serves the hive of the api server, as the /hive routes do, with the same users,
+// roles and limits. Requests are authenticated with the "authorization" metadata, a bearer
+// access token or api key, or basic credentials, with the "x-api-key" metadata, or with a TLS
+// client certificate.
+//
+// Errors are gRPC statuses: NOT_FOUND for missing keys, INVALID_ARGUMENT for invalid keys and
+// values, FAILED_PRECONDITION for read-only hives, for parents that are not sub-hives and for
+// revisions that do not match, ALREADY_EXISTS for new sub-hives over existing keys,
+// UNAUTHENTICATED, PERMISSION_DENIED, and RESOURCE_EXHAUSTED for rate limits, values too large
+// and key quotas.
+type HiveServiceServer interface {
+	// Get Gets a value, or the whole hive for the empty key.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Set Sets a value.
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	// Delete Deletes a value or a sub-hive.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// List Lists the values of a sub-hive, sorted by name.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Watch Streams the changes under a key.
+	Watch(*WatchRequest, HiveService_WatchServer) error
+	// Txn Applies operations all at once if every condition holds.
+	Txn(context.Context, *TxnRequest) (*TxnResponse, error)
+	mustEmbedUnimplementedHiveServiceServer()
+}
+
+// UnimplementedHiveServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedHiveServiceServer struct {
+}
+
+func (UnimplementedHiveServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedHiveServiceServer) Set(context.Context, *SetRequest) (*SetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedHiveServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedHiveServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedHiveServiceServer) Watch(*WatchRequest, HiveService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedHiveServiceServer) Txn(context.Context, *TxnRequest) (*TxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Txn not implemented")
+}
+func (UnimplementedHiveServiceServer) mustEmbedUnimplementedHiveServiceServer() {}
+
+// UnsafeHiveServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HiveServiceServer will
+// result in compilation errors.
+type UnsafeHiveServiceServer interface {
+	mustEmbedUnimplementedHiveServiceServer()
+}
+
+func RegisterHiveServiceServer(s grpc.ServiceRegistrar, srv HiveServiceServer) {
+	s.RegisterService(&HiveService_ServiceDesc, srv)
+}
+
+func _HiveService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HiveServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HiveService_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HiveServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HiveService_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HiveServiceServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HiveService_Set_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HiveServiceServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HiveService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HiveServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HiveService_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HiveServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HiveService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HiveServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HiveService_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HiveServiceServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HiveService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HiveServiceServer).Watch(m, &hiveServiceWatchServer{ServerStream: stream})
+}
+
+type HiveService_WatchServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type hiveServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *hiveServiceWatchServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _HiveService_Txn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HiveServiceServer).Txn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HiveService_Txn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HiveServiceServer).Txn(ctx, req.(*TxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HiveService_ServiceDesc is the grpc.ServiceDesc for HiveService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HiveService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "potentialframework.hive.v1.HiveService",
+	HandlerType: (*HiveServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _HiveService_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _HiveService_Set_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _HiveService_Delete_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _HiveService_List_Handler,
+		},
+		{
+			MethodName: "Txn",
+			Handler:    _HiveService_Txn_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _HiveService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "hive.proto",
+}
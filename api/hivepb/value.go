@@ -0,0 +1,92 @@
+// Package hivepb The protobuf messages and the gRPC HiveService of the api, see hive.proto.
+//
+// The hive values are Value messages, converted from and to cfghive.HiveValue
+// with NewValue and Value.HiveValue.
+package hivepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative hive.proto
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// NewValue Converts a hive value.
+func NewValue(v *cfghive.HiveValue) (*Value, error) {
+	switch x := v.Value().(type) {
+	case bool:
+		return &Value{Kind: &Value_BoolValue{BoolValue: x}}, nil
+	case byte:
+		return &Value{Kind: &Value_ByteValue{ByteValue: uint32(x)}}, nil
+	case int64:
+		return &Value{Kind: &Value_Int64Value{Int64Value: x}}, nil
+	case uint64:
+		return &Value{Kind: &Value_Uint64Value{Uint64Value: x}}, nil
+	case float64:
+		return &Value{Kind: &Value_Float64Value{Float64Value: x}}, nil
+	case int:
+		return &Value{Kind: &Value_IntValue{IntValue: int64(x)}}, nil
+	case uint:
+		return &Value{Kind: &Value_UintValue{UintValue: uint64(x)}}, nil
+	case float32:
+		return &Value{Kind: &Value_Float32Value{Float32Value: x}}, nil
+	case string:
+		return &Value{Kind: &Value_StringValue{StringValue: x}}, nil
+	case []byte:
+		return &Value{Kind: &Value_BytesValue{BytesValue: x}}, nil
+	case map[string]cfghive.HiveValue:
+		sub := &SubHive{Values: make(map[string]*Value, len(x))}
+		for name, child := range x {
+			child := child
+			value, err := NewValue(&child)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			sub.Values[name] = value
+		}
+		return &Value{Kind: &Value_SubValue{SubValue: sub}}, nil
+	}
+	return nil, fmt.Errorf("%w: %T", cfghive.ErrInvalidType, v.Value())
+}
+
+// HiveValue Converts the value to a hive value.
+func (x *Value) HiveValue() (cfghive.HiveValue, error) {
+	switch k := x.GetKind().(type) {
+	case *Value_BoolValue:
+		return cfghive.NewHiveValue(k.BoolValue)
+	case *Value_ByteValue:
+		if k.ByteValue > math.MaxUint8 {
+			return cfghive.HiveValue{}, fmt.Errorf("%w: byte %d is larger than 255", cfghive.ErrInvalidType, k.ByteValue)
+		}
+		return cfghive.NewHiveValue(byte(k.ByteValue))
+	case *Value_Int64Value:
+		return cfghive.NewHiveValue(k.Int64Value)
+	case *Value_Uint64Value:
+		return cfghive.NewHiveValue(k.Uint64Value)
+	case *Value_Float64Value:
+		return cfghive.NewHiveValue(k.Float64Value)
+	case *Value_IntValue:
+		return cfghive.NewHiveValue(int(k.IntValue))
+	case *Value_UintValue:
+		return cfghive.NewHiveValue(uint(k.UintValue))
+	case *Value_Float32Value:
+		return cfghive.NewHiveValue(k.Float32Value)
+	case *Value_StringValue:
+		return cfghive.NewHiveValue(k.StringValue)
+	case *Value_BytesValue:
+		return cfghive.NewHiveValue(k.BytesValue)
+	case *Value_SubValue:
+		sub := make(map[string]cfghive.HiveValue, len(k.SubValue.GetValues()))
+		for name, child := range k.SubValue.GetValues() {
+			v, err := child.HiveValue()
+			if err != nil {
+				return cfghive.HiveValue{}, fmt.Errorf("%s: %w", name, err)
+			}
+			sub[name] = v
+		}
+		return cfghive.NewHiveValue(sub)
+	}
+	return cfghive.HiveValue{}, fmt.Errorf("%w: no value", cfghive.ErrInvalidType)
+}
@@ -0,0 +1,49 @@
+package hivepb_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/hivepb"
+)
+
+func TestValue(t *testing.T) {
+	for _, v := range []interface{}{
+		true, byte(7), int64(-1), uint64(1 << 63), 1.5, -2, uint(2), float32(0.5), "text", []byte{1, 2},
+		map[string]cfghive.HiveValue{},
+	} {
+		hv, err := cfghive.NewHiveValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pv, err := hivepb.NewValue(&hv)
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		back, err := pv.HiveValue()
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if !reflect.DeepEqual(back.Value(), v) {
+			t.Fatalf("%T: %v is %v", v, v, back.Value())
+		}
+	}
+
+	port, _ := cfghive.NewHiveValue(8080)
+	sub, _ := cfghive.NewHiveValue(map[string]cfghive.HiveValue{"port": port})
+	pv, err := hivepb.NewValue(&sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv.GetSubValue().GetValues()["port"].GetIntValue() != 8080 {
+		t.Fatalf("unexpected sub-hive %v", pv)
+	}
+
+	for _, pv := range []*hivepb.Value{nil, {Kind: &hivepb.Value_ByteValue{ByteValue: 256}}} {
+		if _, err := pv.HiveValue(); !errors.Is(err, cfghive.ErrInvalidType) {
+			t.Fatalf("%v: unexpected error %v", pv, err)
+		}
+	}
+}
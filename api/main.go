@@ -1,19 +1,36 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"io"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+	"google.golang.org/grpc"
 )
 
-var db = make(map[string]string)
-
-func setupRouter() *gin.Engine {
-	// Disable Console Color
-	engine := gin.Default()
-	// Trust all proxies for running behind ingress
-	gin.Default().SetTrustedProxies(nil)
+func setupRouter(settings *Settings, hiveHandler *handlers.HiveHandler, values cfghive.Hive, users *handlers.UserStore, auth *handlers.Auth, audit *cfghive.AuditLog, health *handlers.Health, metrics *handlers.Metrics, limiter *handlers.Limiter, tenants *handlers.Tenants, environments *handlers.Environments) *gin.Engine {
+	engine := gin.New()
+	// Log the requests with their id, and count and time them by route, see handlers.RequestLogger and handlers.Metrics
+	engine.Use(handlers.RequestID(), handlers.RequestLogger(), handlers.Recovery(), metrics.Middleware())
+	// Only trust the configured proxies for the client IP, which is audited
+	err := engine.SetTrustedProxies(settings.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Ping test
 	engine.GET("/ping", func(c *gin.Context) {
@@ -24,22 +41,28 @@ func setupRouter() *gin.Engine {
 		c.JSON(http.StatusOK, data)
 	})
 
-	// Get user value
+	// Liveness, readiness and metrics, for the orchestrator and the dashboards
+	health.Register(engine)
+	metrics.Register(engine)
+	// The OpenAPI document of the routes, see handlers.OpenAPIDocument
+	handlers.RegisterOpenAPI(engine)
+	// The admin UI, calling the routes with the tokens of its users, see handlers.RegisterUI
+	handlers.RegisterUI(engine)
+
+	// Rate and size limits of the client IPs, checked before authenticating, see handlers.Limiter
+	limited := engine.Group("/", limiter.IPMiddleware())
+
+	// Login and token refresh, see handlers.Auth
+	auth.RegisterPublic(limited)
+	// The tenants, authenticating their own users, see handlers.Tenants
+	tenants.RegisterServe(limited)
 
-	// Authorized group (uses gin.BasicAuth() middleware)
-	// Same than:
-	// authorized := r.Group("/")
-	// authorized.Use(gin.BasicAuth(gin.Credentials{
-	//	  "foo":  "bar",
-	//	  "manu": "123",
-	//}))
-	authorized := engine.Group("/", gin.BasicAuth(gin.Accounts{
-		"foo":  "bar", // user:foo password:bar
-		"manu": "123", // user:manu password:123
-	}))
+	// Authorized group, authenticating with a password, a token, an api key or a client certificate,
+	// then limiting the rate of each user
+	authorized := limited.Group("/", auth.Middleware(), limiter.UserMiddleware())
 
 	/* example curl for /admin with basicauth header
-	   Zm9vOmJhcg== is base64("foo:bar")
+	   Zm9vOmJhcg== is base64("foo:bar"), for a user foo with password bar
 
 		curl -X POST \
 	  	http://localhost:8080/admin \
@@ -47,25 +70,305 @@ func setupRouter() *gin.Engine {
 	  	-H 'content-type: application/json' \
 	  	-d '{"value":"bar"}'
 	*/
-	authorized.POST("admin", func(c *gin.Context) {
-		user := c.MustGet(gin.AuthUserKey).(string)
+	// Set and get user values, see handlers.ValueHandler
+	handlers.NewValueHandler(values).Register(authorized)
+
+	// Hive REST resource, checking the roles of the users, see handlers.ACL
+	acl := handlers.NewACL(users)
+	hiveHandler.Limiter = limiter
+	hiveHandler.Register(authorized.Group("/", acl.Middleware()))
+	acl.Register(authorized)
+	// Audit log of the hive changes, see handlers.AuditHandler
+	if audit != nil {
+		hiveHandler.Audit = audit
+		handlers.NewAuditHandler(audit).Register(authorized)
+	}
+	// User management, see handlers.UserHandler
+	handlers.NewUserHandler(users).Register(authorized)
+	// API keys, see handlers.Auth
+	auth.Register(authorized)
+	// Tenant provisioning, see handlers.Tenants
+	tenants.Register(authorized)
+	// The environment hives and the promotions between them, see handlers.Environments
+	environments.Register(authorized, acl)
+
+	return engine
+}
+
+// openHive Opens a hive from its spec, exiting on failure. The load time is recorded as the hive name.
+func openHive(spec string, name string, metrics *handlers.Metrics) cfghive.Hive {
+	start := time.Now()
+	hive, err := cfghive.OpenHive(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	metrics.ObserveLoad(name, time.Since(start))
+	return hive
+}
+
+// tenantPlaceholder The part of the storage/tenants spec replaced by the tenant name.
+const tenantPlaceholder = "{tenant}"
 
-		// Parse JSON
-		var json struct {
-			Value string `json:"value" binding:"required"`
+// tenantOpener Opens the tenant hives from the storage/tenants spec, e.g. bolt:/var/lib/api/{tenant}.db,
+// the hive of the users of a tenant being named after the tenant with a .users suffix.
+func tenantOpener(spec string) handlers.TenantOpener {
+	return func(tenant string, users bool) (cfghive.Hive, error) {
+		if users {
+			tenant += ".users"
 		}
+		return cfghive.OpenHive(strings.ReplaceAll(spec, tenantPlaceholder, tenant))
+	}
+}
+
+// environmentPlaceholder The part of the storage/environments spec replaced by the environment name.
+const environmentPlaceholder = "{environment}"
 
-		if c.Bind(&json) == nil {
-			db[user] = json.Value
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+func closeHive(hive cfghive.Hive) {
+	if c, ok := hive.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// bootstrapAdmin Creates the admin user of an empty user store, with the password
+// of the auth/admin_password setting, or a random one that is logged.
+func bootstrapAdmin(users *handlers.UserStore, password string) error {
+	if !users.Empty() {
+		return nil
+	}
+	generated := password == ""
+	if generated {
+		b := make([]byte, 18)
+		_, err := rand.Read(b)
+		if err != nil {
+			return err
 		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+	}
+	_, err := users.Create("admin", password, true)
+	if err != nil {
+		return err
+	}
+	if generated {
+		log.Printf("created user admin with password %s", password)
+	} else {
+		log.Print("created user admin")
+	}
+	return nil
+}
+
+// tokenSecret Gets the key signing the tokens, from the auth/jwt_secret setting.
+// Without it a random key is used, and the tokens do not survive a restart.
+func tokenSecret(s *Settings) ([]byte, error) {
+	if s.JWTSecret != "" {
+		return []byte(s.JWTSecret), nil
+	}
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// logLevel The level of the logs, which can change while serving.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging Sends the logs, including the ones of the log package, to stderr as JSON objects,
+// or as text lines if format is "text", filtering them by logLevel.
+func setupLogging(format string) {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if format == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// applySettings Applies the settings that can change while serving.
+func applySettings(s *Settings, auth *handlers.Auth, tenants *handlers.Tenants, limiter *handlers.Limiter) {
+	var level slog.Level
+	level.UnmarshalText([]byte(s.LogLevel))
+	logLevel.Set(level)
+	auth.SetTTL(s.AccessTTL, s.RefreshTTL)
+	tenants.SetTTL(s.AccessTTL, s.RefreshTTL)
+	limiter.SetLimits(handlers.Limits{
+		UserRate:  s.UserRate,
+		UserBurst: s.UserBurst,
+		IPRate:    s.IPRate,
+		IPBurst:   s.IPBurst,
+		MaxBody:   s.MaxBody,
+		MaxValue:  s.MaxValue,
 	})
+}
 
-	return engine
+// watchSettings Loads the settings again every reload interval and on SIGHUP, applying the changes
+// that are safe while serving, and logging the ones that need a restart.
+func watchSettings(loader *settingsLoader, last *Settings, auth *handlers.Auth, tenants *handlers.Tenants, limiter *handlers.Limiter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if last.ReloadInterval > 0 {
+		ticker := time.NewTicker(last.ReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+		}
+		s, err := loader.Load()
+		if err != nil {
+			slog.Error("invalid settings, keeping the current ones", "err", err)
+			continue
+		}
+		reload, restart := changedSettings(last, s)
+		if len(restart) > 0 {
+			slog.Warn("settings changed, restart to apply them", "settings", restart)
+		}
+		if len(reload) > 0 {
+			applySettings(s, auth, tenants, limiter)
+			slog.Info("settings reloaded", "settings", reload)
+		}
+		last = s
+	}
 }
 
 func main() {
-	r := setupRouter()
-	// Listen and Server in 0.0.0.0:8080
-	r.Run(":8080")
+	config := flag.String("config", "", "the hive holding the settings, as backend:location, e.g. bolt:api.db")
+	flag.String("hive", "mem:", "the hive served on /hive, as backend:location, overrides storage/hive")
+	flag.String("users", "mem:", "the hive storing the users, as backend:location, overrides storage/users")
+	flag.String("values", "bolt:values.db", "the hive storing the user values, as backend:location, mem: losing them on restart, overrides storage/values")
+	flag.String("audit", "", "the file the hive changes are audited to, none if empty, overrides storage/audit")
+	flag.String("tenants", "mem:", "the tenant hives, as backend:location with {tenant} in the location, overrides storage/tenants")
+	flag.String("environment-hives", "mem:", "the environment hives, as backend:location with {environment} in the location, overrides storage/environments")
+	flag.String("environments", "", "comma separated names of the environments served with their own hive, overrides environments/names")
+	flag.String("protected-environments", "", "comma separated environments only changed by promotions and admins, overrides environments/protected")
+	flag.String("grpc-addr", "", "the address of the gRPC hive service, not served if empty, overrides server/grpc_addr")
+	flag.String("trusted-proxies", "", "comma separated addresses or CIDRs of the proxies trusted for the client IP, overrides server/trusted_proxies")
+	flag.Parse()
+	setupLogging("json")
+
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	loader := &settingsLoader{spec: *config, env: os.Getenv, flags: flags}
+	settings, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	setupLogging(settings.LogFormat)
+
+	metrics := handlers.NewMetrics()
+	rawHive := openHive(settings.Hive, "hive", metrics)
+	defer closeHive(rawHive)
+	rawUsers := openHive(settings.Users, "users", metrics)
+	defer closeHive(rawUsers)
+	rawValues := openHive(settings.Values, "values", metrics)
+	defer closeHive(rawValues)
+	// Measured, and locked so the final commit waits for the requests still changing them
+	hive := metrics.Hive("hive", rawHive)
+	usersHive := metrics.Hive("users", rawUsers)
+	values := metrics.Hive("values", rawValues)
+	checked := map[string]cfghive.Hive{"hive": hive, "users": usersHive, "values": values}
+	// The environments, each with its own hive, e.g. bolt:/var/lib/api/{environment}.db
+	envHives := make(map[string]cfghive.Hive, len(settings.Environments))
+	for _, name := range settings.Environments {
+		raw := openHive(strings.ReplaceAll(settings.EnvironmentHives, environmentPlaceholder, name), "environments/"+name, metrics)
+		defer closeHive(raw)
+		envHives[name] = metrics.Hive("environments/"+name, raw)
+		checked["environments/"+name] = envHives[name]
+	}
+	health := handlers.NewHealth(checked)
+	users := handlers.NewUserStore(usersHive)
+	err = bootstrapAdmin(users, settings.AdminPassword)
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret, err := tokenSecret(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth := handlers.NewAuth(users, secret)
+
+	var audit *cfghive.AuditLog
+	if settings.Audit != "" {
+		audit, err = cfghive.OpenAuditLog(settings.Audit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer audit.Close()
+	}
+	tenants := handlers.NewTenants(users, secret, tenantOpener(settings.Tenants))
+	tenants.Audit = audit
+	err = tenants.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := tenants.Close(); err != nil {
+			slog.Error("cannot close the tenants", "err", err)
+		}
+	}()
+
+	limiter := handlers.NewLimiter(handlers.Limits{})
+	applySettings(settings, auth, tenants, limiter)
+	if *config != "" {
+		go watchSettings(loader, settings, auth, tenants, limiter)
+	}
+
+	environments := handlers.NewEnvironments(users)
+	environments.Audit = audit
+	environments.Limiter = limiter
+	for _, name := range settings.Environments {
+		err = environments.Add(name, envHives[name], contains(settings.ProtectedEnvironments, name))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	hiveHandler := handlers.NewHiveHandler(hive)
+	srv, err := newServer(settings, setupRouter(settings, hiveHandler, values, users, auth, audit, health, metrics, limiter, tenants, environments))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// End the watch streams, which would hold the shutdown, and stop reporting ready
+	srv.RegisterOnShutdown(hiveHandler.Feed().Close)
+	srv.RegisterOnShutdown(tenants.CloseFeeds)
+	srv.RegisterOnShutdown(environments.CloseFeeds)
+	srv.RegisterOnShutdown(func() { health.SetReady(false) })
+	ln, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The hive over gRPC, with the users, roles and limits of the routes, see handlers.HiveService
+	var grpcSrv *grpc.Server
+	if settings.GRPCAddr != "" {
+		grpcSrv, err = newGRPCServer(settings, handlers.NewHiveService(hiveHandler, auth, handlers.NewACL(users)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcLn, err := net.Listen("tcp", settings.GRPCAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := grpcSrv.Serve(grpcLn); err != nil {
+				slog.Error("grpc server stopped", "err", err)
+			}
+		}()
+	}
+	health.SetReady(true)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = serve(ctx, srv, ln, settings)
+	if err != nil {
+		slog.Error("server stopped", "err", err)
+	}
+	if grpcSrv != nil {
+		stopGRPC(grpcSrv, settings.ShutdownTimeout)
+	}
+	commitHives(hive, values)
+	for _, envHive := range envHives {
+		commitHives(envHive)
+	}
 }
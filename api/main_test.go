@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+// TestOpenAPIRoutes Checks the OpenAPI document describes every route, and only them.
+func TestOpenAPIRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := func() cfghive.Hive {
+		h, _ := cfghive.NewMemHive()
+		return h
+	}
+	users := handlers.NewUserStore(mem())
+	audit, err := cfghive.OpenAuditLog(t.TempDir() + "/audit.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	engine := setupRouter(defaultSettings(), handlers.NewHiveHandler(mem()), mem(), users, handlers.NewAuth(users, nil),
+		audit, handlers.NewHealth(nil), handlers.NewMetrics(), handlers.NewLimiter(handlers.Limits{}),
+		handlers.NewTenants(users, nil, tenantOpener("mem:")), handlers.NewEnvironments(users))
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err = json.Unmarshal(handlers.OpenAPIDocument, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	param := regexp.MustCompile(`[:*](\w+)`)
+	for _, r := range engine.Routes() {
+		route := r.Method + " " + param.ReplaceAllString(r.Path, "{$1}")
+		if !documented[route] {
+			t.Errorf("%s is not documented", route)
+		}
+		delete(documented, route)
+	}
+	for route := range documented {
+		t.Errorf("%s is not a route", route)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newServer Creates the http server of the settings, with their timeouts and TLS config.
+func newServer(settings *Settings, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              settings.Addr,
+		Handler:           handler,
+		ReadTimeout:       settings.ReadTimeout,
+		ReadHeaderTimeout: settings.ReadHeaderTimeout,
+		WriteTimeout:      settings.WriteTimeout,
+		IdleTimeout:       settings.IdleTimeout,
+		MaxHeaderBytes:    1 << 20,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	var err error
+	srv.TLSConfig, err = tlsConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// tlsConfig Gets the TLS config of the settings, verifying the client certificates with their CA, nil without TLS.
+// The server certificate is not loaded.
+func tlsConfig(settings *Settings) (*tls.Config, error) {
+	if settings.TLSCert == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings.TLSClientCA != "" {
+		pem, err := os.ReadFile(settings.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("server/tls/client_ca: no certificate in %s", settings.TLSClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if settings.TLSClientAuth == "require" {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+// newGRPCServer Creates the gRPC server of the settings, serving service with their TLS config.
+func newGRPCServer(settings *Settings, service *handlers.HiveService) (*grpc.Server, error) {
+	opts := service.ServerOptions()
+	config, err := tlsConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		cert, err := tls.LoadX509KeyPair(settings.TLSCert, settings.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	srv := grpc.NewServer(opts...)
+	service.Register(srv)
+	return srv, nil
+}
+
+// stopGRPC Stops a gRPC server, waiting for the calls in flight at most timeout.
+// The watch streams must be ended first, see handlers.ChangeFeed.Close.
+func stopGRPC(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		srv.Stop()
+	}
+}
+
+// serve Serves on ln until ctx is done, then stops accepting connections and waits for
+// the requests in flight, at most the shutdown timeout.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, settings *Settings) error {
+	errs := make(chan error, 1)
+	go func() {
+		if settings.TLSCert != "" {
+			errs <- srv.ServeTLS(ln, settings.TLSCert, settings.TLSKey)
+		} else {
+			errs <- srv.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down, waiting for the requests in flight")
+	shutdown, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdown)
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.Close()
+	}
+	return err
+}
+
+// commitHives Commits the changes the hives may still hold, before exiting.
+func commitHives(hives ...cfghive.Hive) {
+	for _, hive := range hives {
+		_, err := hive.Commit()
+		if err != nil {
+			slog.Error("cannot commit the hive", "err", err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+	"github.com/melanblack/potential-framework/hivepb"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// testCert Creates a certificate signed by parent, or self-signed if parent is nil.
+func testCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert Writes the PEM certificate and key files of cert, and gets their paths.
+func writeCert(t *testing.T, dir string, name string, cert tls.Certificate) (string, string) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// startServer Serves the api with settings until the test ends, and gets its address.
+func startServer(t *testing.T, settings *Settings) (string, *handlers.HiveHandler, context.CancelFunc, chan error) {
+	gin.SetMode(gin.TestMode)
+	usersHive, _ := cfghive.NewMemHive()
+	users := handlers.NewUserStore(usersHive)
+	users.Cost = bcrypt.MinCost
+	if _, err := users.Create("alice", "alice-password", true); err != nil {
+		t.Fatal(err)
+	}
+	hive, _ := cfghive.NewMemHive()
+	values, _ := cfghive.NewMemHive()
+	hiveHandler := handlers.NewHiveHandler(hive)
+	auth := handlers.NewAuth(users, []byte("0123456789abcdef0123456789abcdef"))
+	srv, err := newServer(settings, setupRouter(settings, hiveHandler, values, users, auth, nil, handlers.NewHealth(nil), handlers.NewMetrics(), handlers.NewLimiter(handlers.Limits{}),
+		handlers.NewTenants(users, nil, tenantOpener("mem:")), handlers.NewEnvironments(users)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.RegisterOnShutdown(hiveHandler.Feed().Close)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, srv, ln, settings)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String(), hiveHandler, cancel, done
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, "ca", nil)
+	caPath, _ := writeCert(t, dir, "ca", ca)
+	certPath, keyPath := writeCert(t, dir, "server", testCert(t, "server", &ca))
+	alice := testCert(t, "alice", &ca)
+	mallory := testCert(t, "mallory", &ca)
+	stranger := testCert(t, "alice", nil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	client := func(cert *tls.Certificate) *http.Client {
+		config := &tls.Config{RootCAs: pool}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+
+	for _, clientAuth := range []string{"optional", "require"} {
+		t.Run(clientAuth, func(t *testing.T) {
+			settings := defaultSettings()
+			settings.TLSCert, settings.TLSKey, settings.TLSClientCA, settings.TLSClientAuth = certPath, keyPath, caPath, clientAuth
+			if err := settings.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			addr, _, _, _ := startServer(t, settings)
+			url := "https://" + addr + "/users/alice"
+
+			for _, tc := range []struct {
+				cert   *tls.Certificate
+				status int
+			}{
+				{&alice, http.StatusOK},
+				{&mallory, http.StatusUnauthorized},
+				{nil, http.StatusUnauthorized},
+				// Not signed by the CA, so the client does not present it.
+				{&stranger, http.StatusUnauthorized},
+			} {
+				if tc.cert != &alice && tc.cert != &mallory && clientAuth == "require" {
+					tc.status = 0
+				}
+				resp, err := client(tc.cert).Get(url)
+				if tc.status == 0 {
+					if err == nil {
+						resp.Body.Close()
+						t.Errorf("request without a valid certificate got status %d", resp.StatusCode)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tc.status {
+					t.Errorf("status %d, expected %d", resp.StatusCode, tc.status)
+				}
+			}
+		})
+	}
+}
+
+func TestServerTenantCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, "ca", nil)
+	caPath, _ := writeCert(t, dir, "ca", ca)
+	certPath, keyPath := writeCert(t, dir, "server", testCert(t, "server", &ca))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	client := func(cert tls.Certificate) *http.Client {
+		config := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+	settings := defaultSettings()
+	settings.TLSCert, settings.TLSKey, settings.TLSClientCA, settings.TLSClientAuth = certPath, keyPath, caPath, "optional"
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	addr, _, _, _ := startServer(t, settings)
+
+	resp, err := client(testCert(t, "alice", &ca)).Post("https://"+addr+"/tenants", "application/json",
+		strings.NewReader(`{"name": "x", "admin_password": "admin-password"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusCreated)
+	}
+	// The certificates of the main server do not name the users of the tenants, whose admin included.
+	resp, err = client(testCert(t, "admin", &ca)).Get("https://" + addr + "/t/x/hive/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestGRPCServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, "ca", nil)
+	caPath, _ := writeCert(t, dir, "ca", ca)
+	settings := defaultSettings()
+	settings.TLSCert, settings.TLSKey = writeCert(t, dir, "server", testCert(t, "server", &ca))
+	settings.TLSClientCA = caPath
+	usersHive, _ := cfghive.NewMemHive()
+	users := handlers.NewUserStore(usersHive)
+	if _, err := users.Create("alice", "alice-password", true); err != nil {
+		t.Fatal(err)
+	}
+	hive, _ := cfghive.NewMemHive()
+	hive.Set("port", 8080)
+	service := handlers.NewHiveService(handlers.NewHiveHandler(hive), handlers.NewAuth(users, nil), handlers.NewACL(users))
+	srv, err := newGRPCServer(settings, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { stopGRPC(srv, time.Second) })
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	alice := testCert(t, "alice", &ca)
+	for _, tc := range []struct {
+		cert *tls.Certificate
+		code codes.Code
+	}{
+		{&alice, codes.OK},
+		{nil, codes.Unauthenticated},
+	} {
+		config := &tls.Config{RootCAs: pool}
+		if tc.cert != nil {
+			config.Certificates = []tls.Certificate{*tc.cert}
+		}
+		conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		resp, err := hivepb.NewHiveServiceClient(conn).Get(context.Background(), &hivepb.GetRequest{Key: "port"})
+		if status.Code(err) != tc.code {
+			t.Fatalf("unexpected error %v, expected %s", err, tc.code)
+		}
+		if err == nil && resp.Value.GetIntValue() != 8080 {
+			t.Fatalf("unexpected value %v", resp.Value)
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	settings := defaultSettings()
+	settings.ShutdownTimeout = 5 * time.Second
+	addr, _, cancel, done := startServer(t, settings)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/watch/", nil)
+	req.SetBasicAuth("alice", "alice-password")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("watch status %d", resp.StatusCode)
+	}
+
+	// The watch stream ends, so the shutdown does not wait for the timeout.
+	start := time.Now()
+	cancel()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	done <- err
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > settings.ShutdownTimeout/2 {
+		t.Fatalf("shutdown took %s", time.Since(start))
+	}
+	if _, err := http.Get("http://" + addr + "/ping"); err == nil {
+		t.Fatal("the server still accepts connections")
+	}
+}
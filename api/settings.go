@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+	"github.com/melanblack/potential-framework/handlers"
+)
+
+// Settings The settings of the api server.
+//
+// Each setting is read from its key in the config hive, then from its environment variable,
+// then from its command line flag if there is one, the last one found winning.
+// Durations are strings such as "30s", or integers counted in seconds, and lists are comma separated.
+// Settings tagged reload are applied when the config hive changes, the others need a restart.
+type Settings struct {
+	Addr string `hive:"server/addr" env:"API_ADDR"`
+	// GRPCAddr The address of the gRPC HiveService, with the TLS config of Addr, see handlers.HiveService.
+	// It is not served if empty.
+	GRPCAddr string `hive:"server/grpc_addr" env:"API_GRPC_ADDR" flag:"grpc-addr"`
+	TLSCert  string `hive:"server/tls/cert" env:"API_TLS_CERT"`
+	TLSKey   string `hive:"server/tls/key" env:"API_TLS_KEY"`
+	// TLSClientCA The CA certificates verifying client certificates, see Auth.
+	TLSClientCA string `hive:"server/tls/client_ca" env:"API_TLS_CLIENT_CA"`
+	// TLSClientAuth Whether clients may ("optional") or must ("require") present a certificate.
+	TLSClientAuth     string        `hive:"server/tls/client_auth" env:"API_TLS_CLIENT_AUTH"`
+	TrustedProxies    []string      `hive:"server/trusted_proxies" env:"API_TRUSTED_PROXIES" flag:"trusted-proxies"`
+	ReadTimeout       time.Duration `hive:"server/timeouts/read" env:"API_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `hive:"server/timeouts/read_header" env:"API_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `hive:"server/timeouts/write" env:"API_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `hive:"server/timeouts/idle" env:"API_IDLE_TIMEOUT"`
+	// ShutdownTimeout How long the requests in flight are waited for on SIGTERM.
+	ShutdownTimeout time.Duration `hive:"server/timeouts/shutdown" env:"API_SHUTDOWN_TIMEOUT"`
+	LogLevel        string        `hive:"log/level" env:"API_LOG_LEVEL" reload:"true"`
+	LogFormat       string        `hive:"log/format" env:"API_LOG_FORMAT"`
+	Hive            string        `hive:"storage/hive" env:"API_HIVE" flag:"hive"`
+	Users           string        `hive:"storage/users" env:"API_USERS" flag:"users"`
+	// Values The hive of the user values, see handlers.ValueHandler. It is a file by default, so the values
+	// are kept across restarts, "mem:" losing them.
+	Values  string `hive:"storage/values" env:"API_VALUES" flag:"values"`
+	Audit   string `hive:"storage/audit" env:"API_AUDIT" flag:"audit"`
+	Tenants string `hive:"storage/tenants" env:"API_TENANTS" flag:"tenants"`
+	// EnvironmentHives The hives of the environments, with {environment} replaced by their name.
+	EnvironmentHives string `hive:"storage/environments" env:"API_ENVIRONMENT_HIVES" flag:"environment-hives"`
+	// Environments The environments served with their own hive, e.g. dev,staging,prod, see handlers.Environments.
+	Environments []string `hive:"environments/names" env:"API_ENVIRONMENTS" flag:"environments"`
+	// ProtectedEnvironments The environments only changed by promotions, and by the admins, e.g. prod.
+	ProtectedEnvironments []string      `hive:"environments/protected" env:"API_PROTECTED_ENVIRONMENTS" flag:"protected-environments"`
+	AccessTTL             time.Duration `hive:"auth/access_ttl" env:"API_ACCESS_TTL" reload:"true"`
+	RefreshTTL            time.Duration `hive:"auth/refresh_ttl" env:"API_REFRESH_TTL" reload:"true"`
+	JWTSecret             string        `hive:"auth/jwt_secret" env:"API_JWT_SECRET"`
+	AdminPassword         string        `hive:"auth/admin_password" env:"API_ADMIN_PASSWORD"`
+	// Requests per second of each user and of each client IP, and how many at once, see handlers.Limiter.
+	// Unlimited if 0.
+	UserRate  float64 `hive:"limits/user_rate" env:"API_USER_RATE" reload:"true"`
+	UserBurst int     `hive:"limits/user_burst" env:"API_USER_BURST" reload:"true"`
+	IPRate    float64 `hive:"limits/ip_rate" env:"API_IP_RATE" reload:"true"`
+	IPBurst   int     `hive:"limits/ip_burst" env:"API_IP_BURST" reload:"true"`
+	// MaxBody and MaxValue The bytes of a request body, and of a hive value as typed JSON. Unlimited if 0.
+	MaxBody  int64 `hive:"limits/max_body" env:"API_MAX_BODY" reload:"true"`
+	MaxValue int64 `hive:"limits/max_value" env:"API_MAX_VALUE" reload:"true"`
+	// ReloadInterval How often the config hive is read again, never if 0. It is also read on SIGHUP.
+	ReloadInterval time.Duration `hive:"reload_interval" env:"API_RELOAD_INTERVAL"`
+}
+
+// defaultSettings Gets the settings used when nothing is configured.
+func defaultSettings() *Settings {
+	return &Settings{
+		Addr:              ":8080",
+		TLSClientAuth:     "optional",
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+		LogLevel:          "info",
+		LogFormat:         "json",
+		Hive:              "mem:",
+		Users:             "mem:",
+		Values:            "bolt:values.db",
+		Tenants:           "mem:",
+		EnvironmentHives:  "mem:",
+		AccessTTL:         15 * time.Minute,
+		RefreshTTL:        7 * 24 * time.Hour,
+		UserRate:          20,
+		UserBurst:         40,
+		IPRate:            50,
+		IPBurst:           100,
+		MaxBody:           1 << 20,
+		MaxValue:          64 << 10,
+		ReloadInterval:    30 * time.Second,
+	}
+}
+
+// logLevels The log levels of Settings.LogLevel.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// setSetting Sets a setting field from a hive value or a string.
+// Numbers are read from strings, or from hive values of any number type.
+func setSetting(field reflect.Value, value interface{}) error {
+	switch field.Interface().(type) {
+	case string:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %T is not a string", cfghive.ErrInvalidType, value)
+		}
+		field.SetString(s)
+	case []string:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %T is not a list", cfghive.ErrInvalidType, value)
+		}
+		var list []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case time.Duration:
+		var d time.Duration
+		switch v := value.(type) {
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				d = time.Duration(n) * time.Second
+				break
+			}
+			var err error
+			d, err = time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+		default:
+			rv := reflect.ValueOf(value)
+			switch {
+			case rv.CanInt():
+				d = time.Duration(rv.Int()) * time.Second
+			case rv.CanUint():
+				d = time.Duration(rv.Uint()) * time.Second
+			default:
+				return fmt.Errorf("%w: %T is not a duration", cfghive.ErrInvalidType, value)
+			}
+		}
+		field.SetInt(int64(d))
+	case int, int64:
+		var n int64
+		switch v := value.(type) {
+		case string:
+			var err error
+			n, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+		default:
+			rv := reflect.ValueOf(value)
+			switch {
+			case rv.CanInt():
+				n = rv.Int()
+			case rv.CanUint():
+				n = int64(rv.Uint())
+			default:
+				return fmt.Errorf("%w: %T is not an integer", cfghive.ErrInvalidType, value)
+			}
+		}
+		field.SetInt(n)
+	case float64:
+		var f float64
+		switch v := value.(type) {
+		case string:
+			var err error
+			f, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+		default:
+			rv := reflect.ValueOf(value)
+			switch {
+			case rv.CanFloat():
+				f = rv.Float()
+			case rv.CanInt():
+				f = float64(rv.Int())
+			case rv.CanUint():
+				f = float64(rv.Uint())
+			default:
+				return fmt.Errorf("%w: %T is not a number", cfghive.ErrInvalidType, value)
+			}
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("%w: unsupported setting type %s", cfghive.ErrInvalidType, field.Type())
+	}
+	return nil
+}
+
+// settingsLoader Loads the settings from a config hive, the environment and the flags.
+type settingsLoader struct {
+	// The config hive spec, see cfghive.OpenHive. None if empty.
+	spec string
+	env  func(key string) string
+	// The values of the flags set on the command line, by name.
+	flags map[string]string
+}
+
+// readConfig Reads the config hive values, by key.
+func (l *settingsLoader) readConfig() (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if l.spec == "" {
+		return values, nil
+	}
+	hive, err := cfghive.OpenHive(l.spec)
+	if err != nil {
+		return nil, err
+	}
+	defer closeHive(hive)
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("hive")
+		v, err := hive.Get(key)
+		if errors.Is(err, cfghive.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = v.Value()
+	}
+	return values, nil
+}
+
+// Load Loads and validates the settings.
+func (l *settingsLoader) Load() (*Settings, error) {
+	config, err := l.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	s := defaultSettings()
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag
+		key := tag.Get("hive")
+		if value, ok := config[key]; ok {
+			err = setSetting(v.Field(i), value)
+			if err != nil {
+				return nil, fmt.Errorf("setting %s: %w", key, err)
+			}
+		}
+		if value := l.env(tag.Get("env")); value != "" {
+			err = setSetting(v.Field(i), value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", tag.Get("env"), err)
+			}
+		}
+		if value, ok := l.flags[tag.Get("flag")]; ok && tag.Get("flag") != "" {
+			err = setSetting(v.Field(i), value)
+			if err != nil {
+				return nil, fmt.Errorf("-%s: %w", tag.Get("flag"), err)
+			}
+		}
+	}
+	err = s.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate Checks the settings are consistent.
+func (s *Settings) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server/addr: %w", err))
+	}
+	if s.GRPCAddr != "" {
+		if _, _, err := net.SplitHostPort(s.GRPCAddr); err != nil {
+			errs = append(errs, fmt.Errorf("server/grpc_addr: %w", err))
+		} else if s.GRPCAddr == s.Addr {
+			errs = append(errs, errors.New("server/grpc_addr: it is server/addr"))
+		}
+	}
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		errs = append(errs, errors.New("server/tls: cert and key must be set together"))
+	}
+	if s.TLSClientCA != "" && s.TLSCert == "" {
+		errs = append(errs, errors.New("server/tls: client_ca needs a cert and key"))
+	}
+	if s.TLSClientAuth != "optional" && s.TLSClientAuth != "require" {
+		errs = append(errs, fmt.Errorf("server/tls/client_auth: %q is not optional or require", s.TLSClientAuth))
+	}
+	for _, path := range []string{s.TLSCert, s.TLSKey, s.TLSClientCA} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			errs = append(errs, fmt.Errorf("server/tls: %w", err))
+		}
+	}
+	for _, p := range s.TrustedProxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				errs = append(errs, fmt.Errorf("server/trusted_proxies: %q is not an IP or a CIDR", p))
+			}
+		}
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server/timeouts/read", s.ReadTimeout},
+		{"server/timeouts/read_header", s.ReadHeaderTimeout},
+		{"server/timeouts/write", s.WriteTimeout},
+		{"server/timeouts/idle", s.IdleTimeout},
+		{"server/timeouts/shutdown", s.ShutdownTimeout},
+		{"reload_interval", s.ReloadInterval},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: %s is negative", d.key, d.value))
+		}
+	}
+	if !contains(logLevels, s.LogLevel) {
+		errs = append(errs, fmt.Errorf("log/level: %q is not one of %s", s.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	if s.LogFormat != "json" && s.LogFormat != "text" {
+		errs = append(errs, fmt.Errorf("log/format: %q is not json or text", s.LogFormat))
+	}
+	for i, spec := range []string{s.Hive, s.Users, s.Values, s.Tenants, s.EnvironmentHives} {
+		backend, _, _ := strings.Cut(spec, ":")
+		if !contains(cfghive.HiveBackends(), backend) {
+			key := []string{"storage/hive", "storage/users", "storage/values", "storage/tenants", "storage/environments"}[i]
+			errs = append(errs, fmt.Errorf("%s: unknown hive backend %q", key, backend))
+		}
+	}
+	// The tenants would share the hive otherwise.
+	if !strings.HasPrefix(s.Tenants, "mem:") && !strings.Contains(s.Tenants, tenantPlaceholder) {
+		errs = append(errs, fmt.Errorf("storage/tenants: %q has no %s", s.Tenants, tenantPlaceholder))
+	}
+	if !strings.HasPrefix(s.EnvironmentHives, "mem:") && !strings.Contains(s.EnvironmentHives, environmentPlaceholder) {
+		errs = append(errs, fmt.Errorf("storage/environments: %q has no %s", s.EnvironmentHives, environmentPlaceholder))
+	}
+	for i, name := range s.Environments {
+		if err := handlers.CheckEnvironmentName(name); err != nil {
+			errs = append(errs, fmt.Errorf("environments/names: %w", err))
+		} else if contains(s.Environments[:i], name) {
+			errs = append(errs, fmt.Errorf("environments/names: %q is listed twice", name))
+		}
+	}
+	for _, name := range s.ProtectedEnvironments {
+		if !contains(s.Environments, name) {
+			errs = append(errs, fmt.Errorf("environments/protected: %q is not in environments/names", name))
+		}
+	}
+	if s.AccessTTL <= 0 || s.RefreshTTL < s.AccessTTL {
+		errs = append(errs, errors.New("auth: access_ttl must be positive, and at most refresh_ttl"))
+	}
+	for _, l := range []struct {
+		key   string
+		rate  float64
+		burst int
+	}{
+		{"limits/user", s.UserRate, s.UserBurst},
+		{"limits/ip", s.IPRate, s.IPBurst},
+	} {
+		if l.rate < 0 || (l.rate > 0 && l.burst < 1) {
+			errs = append(errs, fmt.Errorf("%s_rate: must be 0, or positive with a positive %s_burst", l.key, l.key))
+		}
+	}
+	if s.MaxBody < 0 || s.MaxValue < 0 {
+		errs = append(errs, errors.New("limits: max_body and max_value must not be negative"))
+	}
+	if s.JWTSecret != "" && len(s.JWTSecret) < 32 {
+		errs = append(errs, errors.New("auth/jwt_secret: must be at least 32 characters"))
+	}
+	return errors.Join(errs...)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// changedSettings Gets the keys of the settings that differ, the ones that can be reloaded,
+// and the ones that need a restart.
+func changedSettings(old *Settings, s *Settings) (reload []string, restart []string) {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(s).Elem()
+	for i := 0; i < n.NumField(); i++ {
+		if reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			continue
+		}
+		tag := n.Type().Field(i).Tag
+		if tag.Get("reload") == "true" {
+			reload = append(reload, tag.Get("hive"))
+		} else {
+			restart = append(restart, tag.Get("hive"))
+		}
+	}
+	return reload, restart
+}
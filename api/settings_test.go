@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// writeConfig Writes a config hive, and gets its spec.
+func writeConfig(t *testing.T, data map[string]interface{}) string {
+	spec := "bolt:" + filepath.Join(t.TempDir(), "config.db")
+	hive, err := cfghive.OpenHive(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHive(hive)
+	for k, v := range data {
+		if err := hive.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := hive.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestSettingsLoad(t *testing.T) {
+	spec := writeConfig(t, map[string]interface{}{
+		"server": map[string]interface{}{
+			"addr":            "127.0.0.1:9090",
+			"trusted_proxies": "10.0.0.0/8, 192.168.1.1",
+			"timeouts":        map[string]interface{}{"read": 10, "write": "1m"},
+		},
+		"log":     map[string]interface{}{"level": "debug"},
+		"storage": map[string]interface{}{"hive": "bolt:hive.db", "users": "bolt:users.db"},
+		"limits":  map[string]interface{}{"user_rate": 2.5, "ip_rate": 10, "max_body": 4096},
+	})
+	env := map[string]string{"API_LOG_LEVEL": "warn", "API_ACCESS_TTL": "5m", "API_USERS": "sqlite:users.sqlite", "API_IP_BURST": "7"}
+	l := &settingsLoader{
+		spec:  spec,
+		env:   func(key string) string { return env[key] },
+		flags: map[string]string{"users": "dir:users"},
+	}
+	s, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := defaultSettings()
+	expected.Addr = "127.0.0.1:9090"
+	expected.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	expected.ReadTimeout = 10 * time.Second
+	expected.WriteTimeout = time.Minute
+	expected.LogLevel = "warn"
+	expected.Hive = "bolt:hive.db"
+	expected.Users = "dir:users"
+	expected.AccessTTL = 5 * time.Minute
+	expected.UserRate = 2.5
+	expected.IPRate = 10
+	expected.IPBurst = 7
+	expected.MaxBody = 4096
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("settings are %+v, expected %+v", s, expected)
+	}
+
+	reload, restart := changedSettings(defaultSettings(), s)
+	if !reflect.DeepEqual(reload, []string{"log/level", "auth/access_ttl", "limits/user_rate", "limits/ip_rate", "limits/ip_burst", "limits/max_body"}) {
+		t.Fatalf("reloaded settings are %v", reload)
+	}
+	if len(restart) != 6 {
+		t.Fatalf("restart settings are %v", restart)
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		env   map[string]string
+		error string
+	}{
+		{map[string]string{"API_ADDR": "8080"}, "server/addr"},
+		{map[string]string{"API_GRPC_ADDR": "9090"}, "server/grpc_addr"},
+		{map[string]string{"API_GRPC_ADDR": ":8080"}, "server/grpc_addr"},
+		{map[string]string{"API_TLS_CERT": "cert.pem"}, "cert and key"},
+		{map[string]string{"API_TLS_CERT": "missing.pem", "API_TLS_KEY": "missing.key"}, "missing.pem"},
+		{map[string]string{"API_TRUSTED_PROXIES": "proxy"}, "server/trusted_proxies"},
+		{map[string]string{"API_IDLE_TIMEOUT": "-1s"}, "server/timeouts/idle"},
+		{map[string]string{"API_READ_TIMEOUT": "soon"}, "API_READ_TIMEOUT"},
+		{map[string]string{"API_LOG_LEVEL": "verbose"}, "log/level"},
+		{map[string]string{"API_LOG_FORMAT": "xml"}, "log/format"},
+		{map[string]string{"API_HIVE": "etcd:localhost"}, "storage/hive"},
+		{map[string]string{"API_TENANTS": "bolt:tenants.db"}, "storage/tenants"},
+		{map[string]string{"API_ENVIRONMENT_HIVES": "bolt:environments.db"}, "storage/environments"},
+		{map[string]string{"API_ENVIRONMENTS": "dev,Prod"}, "environments/names"},
+		{map[string]string{"API_ENVIRONMENTS": "dev,prod,dev"}, "listed twice"},
+		{map[string]string{"API_ENVIRONMENTS": "dev", "API_PROTECTED_ENVIRONMENTS": "prod"}, "environments/protected"},
+		{map[string]string{"API_ACCESS_TTL": "30d"}, "API_ACCESS_TTL"},
+		{map[string]string{"API_ACCESS_TTL": "1000h"}, "access_ttl"},
+		{map[string]string{"API_JWT_SECRET": "secret"}, "auth/jwt_secret"},
+		{map[string]string{"API_USER_RATE": "fast"}, "API_USER_RATE"},
+		{map[string]string{"API_IP_BURST": "0"}, "limits/ip_rate"},
+		{map[string]string{"API_MAX_BODY": "-1"}, "max_body"},
+	} {
+		l := &settingsLoader{env: func(key string) string { return tc.env[key] }}
+		_, err := l.Load()
+		if err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("%v: unexpected error %v", tc.env, err)
+		}
+	}
+
+	spec := writeConfig(t, map[string]interface{}{"log": map[string]interface{}{"level": 1}})
+	l := &settingsLoader{spec: spec, env: func(string) string { return "" }}
+	if _, err := l.Load(); err == nil || !strings.Contains(err.Error(), "log/level") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package cfghive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAuditTampered The audit log was changed after it was written.
+var ErrAuditTampered = errors.New("audit log is tampered")
+
+// AuditEntry A change made to a hive, who made it, and from where.
+type AuditEntry struct {
+	// Seq The position of the entry in the log, from 1.
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	Client string    `json:"client,omitempty"`
+	// RequestID The id of the request that made the change, see the api X-Request-ID header.
+	RequestID string `json:"request_id,omitempty"`
+	// Tenant The tenant owning the hive changed, empty for the main hive, see the api /t routes.
+	Tenant string `json:"tenant,omitempty"`
+	// Environment The environment owning the hive changed, empty outside of them, see the api /environments routes.
+	Environment string `json:"environment,omitempty"`
+	Op          string `json:"op"`
+	Key         string `json:"key,omitempty"`
+	// The value before and after the change, nil if there is none.
+	Old *HiveValue `json:"old,omitempty"`
+	New *HiveValue `json:"new,omitempty"`
+	// Prev The hash of the previous entry, empty for the first one.
+	Prev string `json:"prev"`
+	// Hash The hash of the entry, set when the entry is read. It is not part of the hashed entry.
+	Hash string `json:"hash,omitempty"`
+}
+
+// auditRecord A line of the audit log. The hash covers the exact bytes of the entry,
+// which includes the hash of the previous entry.
+type auditRecord struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+func auditHash(entry []byte) string {
+	sum := sha256.Sum256(entry)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditQuery Selects audit entries. Zero fields select every entry.
+type AuditQuery struct {
+	User string
+	// Tenant Selects the entries of a tenant hive, "-" the ones of the main hive.
+	Tenant string
+	// Environment Selects the entries of an environment hive, "-" the ones outside of the environments.
+	Environment string
+	// Key Selects the entries of the key and of its children, and the ones changing the whole hive.
+	Key   string
+	Op    string
+	Since time.Time
+	Until time.Time
+	// After Selects the entries after this sequence number.
+	After uint64
+	// Limit The number of entries returned at most, the first ones.
+	Limit int
+}
+
+// Matches Reports whether the query selects e.
+func (q *AuditQuery) Matches(e *AuditEntry) bool {
+	if q.User != "" && e.User != q.User || q.Op != "" && e.Op != q.Op || e.Seq <= q.After {
+		return false
+	}
+	if q.Tenant == "-" && e.Tenant != "" || q.Tenant != "" && q.Tenant != "-" && e.Tenant != q.Tenant {
+		return false
+	}
+	if q.Environment == "-" && e.Environment != "" || q.Environment != "" && q.Environment != "-" && e.Environment != q.Environment {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) || !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	key := strings.Trim(q.Key, "/")
+	return key == "" || e.Key == "" || e.Key == key || strings.HasPrefix(e.Key, key+"/")
+}
+
+// ReadAudit Reads an audit log, checking the hash chain, and calls fn for every entry.
+// A broken chain is reported with ErrAuditTampered. Removing the last entries is not detected.
+func ReadAudit(r io.Reader, fn func(e *AuditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	prev := ""
+	for seq := uint64(1); scanner.Scan(); seq++ {
+		var rec auditRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %w", ErrAuditTampered, seq, err)
+		}
+		var e AuditEntry
+		err = json.Unmarshal(rec.Entry, &e)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %w", ErrAuditTampered, seq, err)
+		}
+		if e.Seq != seq || e.Prev != prev || auditHash(rec.Entry) != rec.Hash {
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrAuditTampered, seq)
+		}
+		e.Hash = rec.Hash
+		prev = rec.Hash
+		if fn != nil {
+			err = fn(&e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// AuditLog An append-only log of hive changes, stored as JSON lines.
+// Every entry holds the SHA-256 hash of the previous one, so changing or removing
+// an entry breaks the chain of the entries after it.
+type AuditLog struct {
+	lock sync.Mutex
+	path string
+	file auditFile
+	// The size of the entries written, without a partial one.
+	size int64
+	seq  uint64
+	last string
+}
+
+// auditFile The file an AuditLog appends to.
+type auditFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenAuditLog Opens or creates the audit log at path, checking its hash chain.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{path: path, file: file}
+	err = ReadAudit(file, func(e *AuditEntry) error {
+		l.seq = e.Seq
+		l.last = e.Hash
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.size = info.Size()
+	return l, nil
+}
+
+// Append Adds an entry, setting its sequence number, time if it is zero, and hashes.
+// The entry is synced to the disk before returning. If it cannot be, what was written of it is removed,
+// so it does not break the chain of the next ones.
+func (l *AuditLog) Append(e AuditEntry) (*AuditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e.Seq = l.seq + 1
+	e.Prev = l.last
+	e.Hash = ""
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	entry, err := json.Marshal(&e)
+	if err != nil {
+		return nil, err
+	}
+	e.Hash = auditHash(entry)
+	line, err := json.Marshal(auditRecord{Entry: entry, Hash: e.Hash})
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	_, err = l.file.Write(line)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		if terr := l.file.Truncate(l.size); terr != nil {
+			return nil, errors.Join(err, terr)
+		}
+		return nil, err
+	}
+	l.size += int64(len(line))
+	l.seq = e.Seq
+	l.last = e.Hash
+	return &e, nil
+}
+
+// read Reads the entries written so far, see ReadAudit. The log is read through a file of its own,
+// so the entries appended meanwhile are not waiting for it.
+func (l *AuditLog) read(fn func(e *AuditEntry) error) error {
+	l.lock.Lock()
+	size := l.size
+	l.lock.Unlock()
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ReadAudit(io.NewSectionReader(file, 0, size), fn)
+}
+
+// Query Gets the entries selected by q, checking the hash chain.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	done := errors.New("done")
+	err := l.read(func(e *AuditEntry) error {
+		if q.Matches(e) {
+			entries = append(entries, *e)
+		}
+		if q.Limit > 0 && len(entries) >= q.Limit {
+			return done
+		}
+		return nil
+	})
+	if err != nil && err != done {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verify Checks the hash chain of the log, and gets the number of entries.
+func (l *AuditLog) Verify() (uint64, error) {
+	var n uint64
+	err := l.read(func(e *AuditEntry) error {
+		n = e.Seq
+		return nil
+	})
+	return n, err
+}
+
+func (l *AuditLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
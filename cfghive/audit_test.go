@@ -0,0 +1,126 @@
+package cfghive_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := cfghive.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := cfghive.NewHiveValue(8080)
+	for _, e := range []cfghive.AuditEntry{
+		{User: "alice", Client: "10.0.0.1", Op: "sub", Key: "app"},
+		{User: "alice", Client: "10.0.0.1", Op: "set", Key: "app/port", New: &v},
+		{User: "bob", Client: "10.0.0.2", Op: "delete", Key: "app/port", Old: &v},
+		{User: "bob", Client: "10.0.0.2", Tenant: "acme", Op: "set", Key: "db/host"},
+	} {
+		if _, err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// Appending continues the chain of the existing entries.
+	l, err = cfghive.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := l.Append(cfghive.AuditEntry{User: "admin", Environment: "prod", Op: "rollback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Seq != 5 || e.Prev == "" || e.Hash == "" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if n, err := l.Verify(); n != 5 || err != nil {
+		t.Fatalf("verify is %d, %v", n, err)
+	}
+
+	for _, tc := range []struct {
+		q    cfghive.AuditQuery
+		seqs []uint64
+	}{
+		{cfghive.AuditQuery{}, []uint64{1, 2, 3, 4, 5}},
+		{cfghive.AuditQuery{User: "bob"}, []uint64{3, 4}},
+		{cfghive.AuditQuery{Key: "app"}, []uint64{1, 2, 3, 5}},
+		{cfghive.AuditQuery{Key: "app/port", Op: "set"}, []uint64{2}},
+		{cfghive.AuditQuery{After: 2, Limit: 2}, []uint64{3, 4}},
+		{cfghive.AuditQuery{Tenant: "acme"}, []uint64{4}},
+		{cfghive.AuditQuery{Tenant: "-", User: "bob"}, []uint64{3}},
+		{cfghive.AuditQuery{Environment: "prod"}, []uint64{5}},
+		{cfghive.AuditQuery{Environment: "-"}, []uint64{1, 2, 3, 4}},
+		{cfghive.AuditQuery{Until: time.Now().Add(-time.Hour)}, nil},
+	} {
+		entries, err := l.Query(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seqs []uint64
+		for _, e := range entries {
+			seqs = append(seqs, e.Seq)
+		}
+		if !reflect.DeepEqual(seqs, tc.seqs) {
+			t.Errorf("query %+v got %v, expected %v", tc.q, seqs, tc.seqs)
+		}
+	}
+	entries, _ := l.Query(cfghive.AuditQuery{Op: "set", Key: "app"})
+	if n, _ := entries[0].New.Int(); n != 8080 || entries[0].Client != "10.0.0.1" {
+		t.Fatalf("unexpected entry %+v", entries[0])
+	}
+	l.Close()
+
+	// Changing an entry breaks the chain.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(strings.Replace(string(b), `"user":"bob"`, `"user":"eve"`, 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfghive.OpenAuditLog(path); !errors.Is(err, cfghive.ErrAuditTampered) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestAuditLogFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := cfghive.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := l.Append(cfghive.AuditEntry{User: "alice", Op: "sub", Key: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The part of the entry written is removed, so the next entries continue the chain.
+	restore := cfghive.FailAuditWrites(l, 20)
+	if _, err := l.Append(cfghive.AuditEntry{User: "alice", Op: "delete", Key: "app"}); err == nil {
+		t.Fatal("no error when the write fails")
+	}
+	restore()
+	e, err := l.Append(cfghive.AuditEntry{User: "bob", Op: "set", Key: "db"})
+	if err != nil || e.Seq != 2 {
+		t.Fatalf("unexpected entry %+v, %v", e, err)
+	}
+	if n, err := l.Verify(); n != 2 || err != nil {
+		t.Fatalf("verify is %d, %v", n, err)
+	}
+	reopened, err := cfghive.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+}
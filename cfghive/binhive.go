@@ -2,11 +2,14 @@ package cfghive
 
 import (
 	"bufio"
-	"compress/gzip"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/go-msgpack/codec"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"reflect"
 )
 
@@ -16,30 +19,67 @@ var (
 
 func configureCodec() *codec.MsgpackHandle {
 	mh.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	// Write strings and bytes with distinct msgpack types so they survive a round-trip.
+	mh.WriteExt = true
+	mh.RawToString = true
 	return &mh
 }
 
 // BinHive is a hive built on top of MemHive that can be serialized and loaded to/from a writer.
+// If Stream is nil, the hive is loaded from and saved to the file at Path instead.
 type BinHive struct {
 	hive       *MemHive
 	hasChange  bool
 	isCommited bool
 	Stream     *bufio.ReadWriter
-	comp       bool
+	Path       string
+	codecId    byte
 	compLevel  uint8
 }
 
-func NewBinHive(compression bool, level uint8) *BinHive {
+type binHiveOptions struct {
+	codecId   byte
+	compLevel uint8
+}
+
+// BinHiveOption An option for NewBinHive and NewIndexedHive.
+type BinHiveOption func(o *binHiveOptions)
+
+// WithCompression Compresses the hive with the given codec and level when saving.
+func WithCompression(codecId byte, level uint8) BinHiveOption {
+	return func(o *binHiveOptions) {
+		o.codecId = codecId
+		o.compLevel = level
+	}
+}
+
+func newBinHiveOptions(opts []BinHiveOption) binHiveOptions {
+	o := binHiveOptions{codecId: CodecNone, compLevel: 0}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// NewBinHive Creates a new binary hive.
+// By default the hive is saved uncompressed.
+func NewBinHive(opts ...BinHiveOption) *BinHive {
 	h, _ := NewMemHive()
+	o := newBinHiveOptions(opts)
 	return &BinHive{
 		hive:       h,
 		hasChange:  false,
 		isCommited: false,
-		comp:       compression,
-		compLevel:  level,
+		codecId:    o.codecId,
+		compLevel:  o.compLevel,
 	}
 }
 
+// Codec Gets the codec identifier and level the hive is saved with.
+func (h *BinHive) Codec() (byte, uint8) {
+	return h.codecId, h.compLevel
+}
+
 func (h *BinHive) Characteristics() HiveCharacteristics {
 	return HiveCharacteristics{false, true, false}
 }
@@ -69,7 +109,7 @@ func (h *BinHive) SetString(key string, value string) error {
 	return h.hive.SetString(key, value)
 }
 
-func (h *BinHive) Get(key string) (interface{}, error) {
+func (h *BinHive) Get(key string) (*HiveValue, error) {
 	return h.hive.Get(key)
 }
 
@@ -94,29 +134,101 @@ func (h *BinHive) NewSub(key string) {
 	h.hive.NewSub(key)
 }
 
+func (h *BinHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	return compareAndSet(h.Get, h.Set, key, old, value)
+}
+
 func (h *BinHive) Delete(key string) {
 	h.hasChange = true
 	h.hive.Delete(key)
 }
 
-func (h *BinHive) Commit() error {
+// Commit Saves the hive if it has changes.
+// A stream is only written once, while a file is rewritten on every commit.
+func (h *BinHive) Commit() (bool, error) {
 	if !h.hasChange {
-		return nil
+		return false, nil
 	}
-	if h.isCommited {
-		return nil
+	if h.isCommited && h.Stream != nil {
+		return false, nil
+	}
+	err := h.Save()
+	if err != nil {
+		return false, err
 	}
 	h.isCommited = true
 	h.hasChange = false
-	return h.saveToWriter(*h.Stream)
+	return true, nil
+}
+
+// Rollback Reloads the file, discarding the changes since the last Load or Save.
+// This is a no-op for a hive on a stream.
+func (h *BinHive) Rollback() (bool, error) {
+	if !h.hasChange || h.Stream != nil {
+		return false, nil
+	}
+	err := h.Load()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
+// Load Loads the hive from the stream, or from the file.
+// A missing file is an empty hive.
 func (h *BinHive) Load() error {
-	return h.loadFromReader(*h.Stream)
+	if h.Stream != nil {
+		return h.loadFromReader(*h.Stream)
+	}
+	file, err := os.Open(h.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		h.hive.data = make(map[string]HiveValue)
+		h.hasChange = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = h.loadFromReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	h.hasChange = false
+	return nil
 }
 
+// Save Saves the hive to the stream, or replaces the file.
+// The file is written next to its destination and renamed, so it is never left half-written.
 func (h *BinHive) Save() error {
-	return h.saveToWriter(*h.Stream)
+	if h.Stream != nil {
+		return h.saveToWriter(*h.Stream)
+	}
+	file, err := os.CreateTemp(filepath.Dir(h.Path), filepath.Base(h.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	w := bufio.NewWriter(file)
+	err = h.saveToWriter(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(file.Name(), h.Path)
+	if err != nil {
+		return err
+	}
+	h.hasChange = false
+	return nil
 }
 
 func (h *BinHive) GetData() *map[string]HiveValue {
@@ -126,81 +238,53 @@ func (h *BinHive) GetData() *map[string]HiveValue {
 func (h *BinHive) saveToWriter(w io.Writer) error {
 	len_ := uint64(HiveSize(h.hive.data))
 	dataRaw := HiveMapToGeneric(h.hive.data)
-	fmt.Printf("hive size: %d\n", len_)
-	if h.comp {
-		header := []byte{0xC1, h.compLevel}
-		header = binary.BigEndian.AppendUint64(header, len_)
-		_, err := w.Write(header)
-		if err != nil {
-			return err
-		}
-		cw, err := gzip.NewWriterLevel(w, int(h.compLevel))
-		if err != nil {
-			return err
-		}
-		defer cw.Close()
-		ch := configureCodec()
-		c := codec.NewEncoder(cw, ch)
-		err = c.Encode(dataRaw)
-		err = cw.Flush()
-		if err != nil {
-			return err
-		}
+	c, err := LookupCodec(h.codecId)
+	if err != nil {
+		return err
+	}
+	header := []byte{h.codecId, h.compLevel}
+	header = binary.BigEndian.AppendUint64(header, len_)
+	_, err = w.Write(header)
+	if err != nil {
 		return err
-	} else {
-		header := []byte{0xC0, 0x00}
-		header = binary.BigEndian.AppendUint64(header, len_)
-		_, err := w.Write(header)
-		if err != nil {
-			return err
-		}
-		ch := configureCodec()
-		c := codec.NewEncoder(w, ch)
-		err = c.Encode(dataRaw)
+	}
+	cw, err := c.NewWriter(w, h.compLevel)
+	if err != nil {
+		return err
+	}
+	ch := configureCodec()
+	enc := codec.NewEncoder(cw, ch)
+	err = enc.Encode(dataRaw)
+	if err != nil {
+		cw.Close()
 		return err
 	}
+	return cw.Close()
 }
 
 func (h *BinHive) loadFromReader(r io.Reader) error {
 	header := make([]byte, 10)
-	i, err := r.Read(header)
+	i, err := io.ReadFull(r, header)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid header length: %d: %w", i, err)
 	}
-	if i != 10 {
-		return fmt.Errorf("invalid header length: %d", i)
-	}
-	if header[0] != 0xC0 && header[0] != 0xC1 {
+	c, err := LookupCodec(header[0])
+	if err != nil {
 		return fmt.Errorf("invalid header byte: %x", header[0])
 	}
-	if header[0] == 0xC1 {
-		h.comp = true
-		h.compLevel = header[1]
-	} else {
-		h.comp = false
-		h.compLevel = 0
+	h.codecId = header[0]
+	h.compLevel = header[1]
+	cr, err := c.NewReader(r)
+	if err != nil {
+		return err
 	}
+	defer cr.Close()
 	rawData := make(map[string]interface{})
-	if h.comp {
-		cr, err := gzip.NewReader(r)
-		if err != nil {
-			return err
-		}
-		defer cr.Close()
-		ch := configureCodec()
-		c := codec.NewDecoder(cr, ch)
-		err = c.Decode(&rawData)
-		if err != nil {
-			return err
-		}
-
-	} else {
-		ch := configureCodec()
-		c := codec.NewDecoder(r, ch)
-		err = c.Decode(&rawData)
-		if err != nil {
-			return err
-		}
+	ch := configureCodec()
+	dec := codec.NewDecoder(cr, ch)
+	err = dec.Decode(&rawData)
+	if err != nil {
+		return err
 	}
 	data, err := GenericMapToSubMap(rawData)
 	if err != nil {
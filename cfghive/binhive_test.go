@@ -0,0 +1,211 @@
+package cfghive_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+var codecIds = []byte{
+	cfghive.CodecNone,
+	cfghive.CodecGzip,
+	cfghive.CodecZstd,
+	cfghive.CodecLz4,
+	cfghive.CodecSnappy,
+}
+
+func TestBinHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewBinHive()
+}
+
+// newProductHive Creates a hive with n product entries shaped like test_data.json.
+func newProductHive(t testing.TB, n int, opts ...cfghive.BinHiveOption) *cfghive.BinHive {
+	h := cfghive.NewBinHive(opts...)
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("product%d", i)
+		err := h.Set(p, map[string]interface{}{
+			"productParams": map[string]interface{}{
+				"channel":        "DESKTOP-RELEASE",
+				"support":        "LTS",
+				"productSku":     fmt.Sprintf("SKU-%d", i),
+				"productVersion": "23.04",
+				"productName":    fmt.Sprintf("Product %d Desktop 23.04 LTS", i),
+				"skuNum":         int64(i),
+			},
+			"license": map[string]interface{}{
+				"licensee":  "John Doe",
+				"company":   "Acme Inc.",
+				"licenseId": fmt.Sprintf("2fae3c4d-5b6f-4a7d-8c3a-%012d", i),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+func saveHive(t testing.TB, h *cfghive.BinHive) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	h.Stream = bufio.NewReadWriter(nil, w)
+	err := h.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func loadHive(t testing.TB, data []byte) *cfghive.BinHive {
+	h := cfghive.NewBinHive()
+	h.Stream = bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(data)), nil)
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestBinHiveCodecs(t *testing.T) {
+	for _, id := range codecIds {
+		c, err := cfghive.LookupCodec(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(c.Name(), func(t *testing.T) {
+			data := saveHive(t, newProductHive(t, 10, cfghive.WithCompression(id, 5)))
+			if data[0] != id {
+				t.Fatalf("header byte is %x, expected %x", data[0], id)
+			}
+			h := loadHive(t, data)
+			if codecId, level := h.Codec(); codecId != id || level != 5 {
+				t.Fatalf("loaded codec %x level %d", codecId, level)
+			}
+			if size := cfghive.HiveSize(*h.GetData()); size != 90 {
+				t.Fatalf("loaded hive size is %d, expected 90", size)
+			}
+			v, err := h.Get("product7/license/company")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, _ := v.String(); s != "Acme Inc." {
+				t.Fatalf("unexpected value %v", v)
+			}
+		})
+	}
+}
+
+func TestBinHiveUnknownCodec(t *testing.T) {
+	data := saveHive(t, newProductHive(t, 1))
+	data[0] = 0xCF
+	h := cfghive.NewBinHive()
+	h.Stream = bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err := h.Load(); err == nil {
+		t.Fatal("No error when loading a hive with an unknown codec")
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	for _, id := range codecIds {
+		c, _ := cfghive.LookupCodec(id)
+		got, err := cfghive.CodecByName(c.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != id {
+			t.Fatalf("codec %s has id %x, expected %x", c.Name(), got, id)
+		}
+	}
+	if _, err := cfghive.CodecByName("rot13"); err == nil {
+		t.Fatal("No error for an unknown codec name")
+	}
+}
+
+func BenchmarkBinHiveSave(b *testing.B) {
+	for _, id := range codecIds {
+		c, _ := cfghive.LookupCodec(id)
+		h := newProductHive(b, 1000, cfghive.WithCompression(id, 6))
+		b.Run(c.Name(), func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data = saveHive(b, h)
+			}
+			b.ReportMetric(float64(len(data)), "bytes/hive")
+		})
+	}
+}
+
+func BenchmarkBinHiveLoad(b *testing.B) {
+	for _, id := range codecIds {
+		c, _ := cfghive.LookupCodec(id)
+		data := saveHive(b, newProductHive(b, 1000, cfghive.WithCompression(id, 6)))
+		b.Run(c.Name(), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				loadHive(b, data)
+			}
+			b.ReportMetric(float64(len(data)), "bytes/hive")
+		})
+	}
+}
+
+func TestBinHiveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hive.bin")
+	h := cfghive.NewBinHive(cfghive.WithCompression(cfghive.CodecZstd, 3))
+	h.Path = path
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.SetString("kept", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("hive with changes was not committed")
+	}
+	err = h.SetString("dropped", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, err := h.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rolledBack {
+		t.Fatal("pending changes were not rolled back")
+	}
+	if _, err := h.Get("dropped"); !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	h2 := cfghive.NewBinHive()
+	h2.Path = path
+	err = h2.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := h2.GetString("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "yes" {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	if codecId, _ := h2.Codec(); codecId != cfghive.CodecZstd {
+		t.Fatalf("loaded codec %x", codecId)
+	}
+}
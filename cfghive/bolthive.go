@@ -0,0 +1,403 @@
+package cfghive
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var boltRootBucket = []byte("hive")
+
+// BoltHive is a persistent, transactional hive stored in a bbolt database.
+// Sub-hives are nested buckets, and leaf values are stored with their type as the first byte.
+// Changes are made in a write transaction that is started by the first change
+// and ended by Commit or Rollback. While no change is pending, reads run in
+// read-only transactions and may be concurrent.
+type BoltHive struct {
+	path string
+	db   *bbolt.DB
+	tx   *bbolt.Tx
+	lock sync.Mutex
+}
+
+// NewBoltHive Creates a hive backed by the bbolt database at path.
+// The database is opened, and created if needed, by Load.
+func NewBoltHive(path string) *BoltHive {
+	return &BoltHive{path: path}
+}
+
+func (h *BoltHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{true, true, true}
+}
+
+// Load Opens the database and creates the root bucket if it does not exist.
+func (h *BoltHive) Load() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db != nil {
+		return errors.New("hive is already loaded")
+	}
+	db, err := bbolt.Open(h.path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltRootBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	h.db = db
+	return nil
+}
+
+// Close Rolls back any pending changes and closes the database.
+func (h *BoltHive) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return nil
+	}
+	if h.tx != nil {
+		h.tx.Rollback()
+		h.tx = nil
+	}
+	err := h.db.Close()
+	h.db = nil
+	return err
+}
+
+// Ping Checks the database is open, with a read-only transaction.
+func (h *BoltHive) Ping() error {
+	return h.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(boltRootBucket) == nil {
+			return errors.New("hive bucket is missing")
+		}
+		return nil
+	})
+}
+
+// view Runs fn in the pending write transaction, or in a read-only transaction if there is none.
+func (h *BoltHive) view(fn func(tx *bbolt.Tx) error) error {
+	h.lock.Lock()
+	if h.db == nil {
+		h.lock.Unlock()
+		return errors.New("hive is not loaded")
+	}
+	if h.tx != nil {
+		defer h.lock.Unlock()
+		return fn(h.tx)
+	}
+	db := h.db
+	h.lock.Unlock()
+	return db.View(fn)
+}
+
+// update Runs fn in the pending write transaction, starting one if needed.
+func (h *BoltHive) update(fn func(tx *bbolt.Tx) error) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return errors.New("hive is not loaded")
+	}
+	if h.tx == nil {
+		tx, err := h.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		h.tx = tx
+	}
+	return fn(h.tx)
+}
+
+// boltParent Gets the bucket holding the last element of the path.
+func boltParent(tx *bbolt.Tx, key string, path []string) (*bbolt.Bucket, error) {
+	b := tx.Bucket(boltRootBucket)
+	for _, pf := range path[:len(path)-1] {
+		next := b.Bucket([]byte(pf))
+		if next == nil {
+			if b.Get([]byte(pf)) != nil {
+				return nil, errNotSubHive(pf)
+			}
+			return nil, errKeyNotFound(key)
+		}
+		b = next
+	}
+	return b, nil
+}
+
+func decodeBoltValue(raw []byte) (HiveValue, error) {
+	if len(raw) == 0 {
+		return HiveValue{}, errors.New("invalid empty value")
+	}
+	// Values are only valid for the life of the transaction.
+	return decodeValue(raw[0], bytes.Clone(raw[1:]))
+}
+
+func readBucket(b *bbolt.Bucket) (map[string]HiveValue, error) {
+	data := make(map[string]HiveValue)
+	err := b.ForEach(func(k, raw []byte) error {
+		if raw == nil {
+			sub, err := readBucket(b.Bucket(k))
+			if err != nil {
+				return err
+			}
+			data[string(k)], _ = NewHiveValue(sub)
+			return nil
+		}
+		v, err := decodeBoltValue(raw)
+		if err != nil {
+			return err
+		}
+		data[string(k)] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeBucket(b *bbolt.Bucket, k string, v HiveValue) error {
+	if !v.IsStoredType(HiveTypeSub) {
+		return b.Put([]byte(k), append([]byte{v.Type()}, encodeValue(v)...))
+	}
+	sb, err := b.CreateBucket([]byte(k))
+	if err != nil {
+		return err
+	}
+	sub, _ := v.Sub()
+	for sk, sv := range sub {
+		err = writeBucket(sb, sk, sv)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBoltKey(b *bbolt.Bucket, k string) error {
+	if b.Bucket([]byte(k)) != nil {
+		return b.DeleteBucket([]byte(k))
+	}
+	return b.Delete([]byte(k))
+}
+
+func boltGet(tx *bbolt.Tx, key string, path []string) (HiveValue, error) {
+	b, err := boltParent(tx, key, path)
+	if err != nil {
+		return HiveValue{}, err
+	}
+	leaf := []byte(path[len(path)-1])
+	if sb := b.Bucket(leaf); sb != nil {
+		sub, err := readBucket(sb)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(sub)
+	}
+	raw := b.Get(leaf)
+	if raw == nil {
+		return HiveValue{}, errKeyNotFound(key)
+	}
+	return decodeBoltValue(raw)
+}
+
+func boltSet(tx *bbolt.Tx, key string, path []string, val HiveValue) error {
+	b, err := boltParent(tx, key, path)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	err = deleteBoltKey(b, leaf)
+	if err != nil {
+		return err
+	}
+	return writeBucket(b, leaf, val)
+}
+
+func (h *BoltHive) Get(key string) (*HiveValue, error) {
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var v HiveValue
+	err = h.view(func(tx *bbolt.Tx) error {
+		v, err = boltGet(tx, key, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (h *BoltHive) GetBool(key string) (bool, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+func (h *BoltHive) GetInt(key string) (int, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int()
+}
+
+func (h *BoltHive) GetFloat(key string) (float64, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (h *BoltHive) GetString(key string) (*string, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (h *BoltHive) Set(key string, value interface{}) error {
+	val, err := NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return err
+	}
+	return h.update(func(tx *bbolt.Tx) error {
+		return boltSet(tx, key, path, val)
+	})
+}
+
+// CompareAndSet Compares and sets the value in the pending write transaction.
+func (h *BoltHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	val, err := NewHiveValue(value)
+	if err != nil {
+		return false, err
+	}
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return false, err
+	}
+	swapped := false
+	err = h.update(func(tx *bbolt.Tx) error {
+		cur, err := boltGet(tx, key, path)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		if err == nil && !valueMatches(&cur, old) || err != nil && !valueMatches(nil, old) {
+			return nil
+		}
+		swapped = true
+		return boltSet(tx, key, path, val)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+func (h *BoltHive) SetBool(key string, value bool) error {
+	return h.Set(key, value)
+}
+
+func (h *BoltHive) SetInt(key string, value int) error {
+	return h.Set(key, value)
+}
+
+func (h *BoltHive) SetFloat(key string, value float64) error {
+	return h.Set(key, value)
+}
+
+func (h *BoltHive) SetString(key string, value string) error {
+	return h.Set(key, value)
+}
+
+func (h *BoltHive) Delete(key string) {
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return
+	}
+	h.update(func(tx *bbolt.Tx) error {
+		b, err := boltParent(tx, key, path)
+		if err != nil {
+			return err
+		}
+		return deleteBoltKey(b, path[len(path)-1])
+	})
+}
+
+func (h *BoltHive) NewSub(key string) {
+	h.Set(key, make(map[string]HiveValue))
+}
+
+// Rollback Rolls back the pending write transaction.
+// Returns false if there were no pending changes.
+func (h *BoltHive) Rollback() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.tx == nil {
+		return false, nil
+	}
+	err := h.tx.Rollback()
+	h.tx = nil
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Commit Commits the pending write transaction.
+// Returns false if there were no pending changes.
+func (h *BoltHive) Commit() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.tx == nil {
+		return false, nil
+	}
+	err := h.tx.Commit()
+	h.tx = nil
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Save Commits the pending write transaction, if any.
+// Committed changes are already persisted by bbolt.
+func (h *BoltHive) Save() error {
+	_, err := h.Commit()
+	return err
+}
+
+// GetData Reads the whole hive.
+// Unlike MemHive, changes to the returned map are not reflected in the hive.
+func (h *BoltHive) GetData() *map[string]HiveValue {
+	data := make(map[string]HiveValue)
+	h.view(func(tx *bbolt.Tx) error {
+		sub, err := readBucket(tx.Bucket(boltRootBucket))
+		if err == nil {
+			data = sub
+		}
+		return err
+	})
+	return &data
+}
@@ -0,0 +1,177 @@
+package cfghive_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func openBoltHive(t *testing.T, path string) *cfghive.BoltHive {
+	h := cfghive.NewBoltHive(path)
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.Close()
+	})
+	return h
+}
+
+func TestBoltHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewBoltHive("")
+}
+
+func TestBoltHiveSetGet(t *testing.T) {
+	h := openBoltHive(t, filepath.Join(t.TempDir(), "hive.db"))
+	err := h.Set("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("foo/baz", "bar")
+	if err == nil {
+		t.Fatal("No error when setting a value in a non-existent sub-hive")
+	}
+	h.NewSub("fez")
+	err = h.Set("fez/nested", map[string]interface{}{"on": true, "bin": []byte{0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := h.GetBool("fez/nested/on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b {
+		t.Fatal("fez/nested/on is false")
+	}
+	v, err := h.Get("fez/nested/bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := v.Bytes(); len(bs) != 2 || bs[1] != 1 {
+		t.Fatalf("unexpected value %v", bs)
+	}
+	_, err = h.Get("foo/baz")
+	if err == nil {
+		t.Fatal("No error when getting a value below a leaf")
+	}
+	_, err = h.Get("")
+	if err == nil {
+		t.Fatal("No error with empty key")
+	}
+
+	err = h.SetInt("fez", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := h.GetInt("fez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 3 {
+		t.Fatalf("fez is %d, expected 3", i)
+	}
+	h.Delete("foo")
+	if size := cfghive.HiveSize(*h.GetData()); size != 1 {
+		t.Fatalf("hive has size %d, expected 1", size)
+	}
+}
+
+func TestBoltHiveTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hive.db")
+	h := openBoltHive(t, path)
+	err := h.Set("kept", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("pending changes were not committed")
+	}
+	err = h.Set("dropped", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get("dropped"); err != nil {
+		t.Fatal("pending change is not visible to the hive")
+	}
+	rolledBack, err := h.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rolledBack {
+		t.Fatal("pending changes were not rolled back")
+	}
+	h.Close()
+
+	h = openBoltHive(t, path)
+	if _, err := h.Get("kept"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get("dropped"); err == nil {
+		t.Fatal("rolled back value was persisted")
+	}
+}
+
+func TestBoltHiveConcurrentReaders(t *testing.T) {
+	h := openBoltHive(t, filepath.Join(t.TempDir(), "hive.db"))
+	for i := 0; i < 8; i++ {
+		err := h.SetInt(fmt.Sprintf("k%d", i), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := h.GetInt(fmt.Sprintf("k%d", i))
+			if err != nil {
+				t.Error(err)
+			}
+			if v != i {
+				t.Errorf("k%d is %d", i, v)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestBoltHivePing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hive.db")
+	if cfghive.NewBoltHive(path).Ping() == nil {
+		t.Fatal("No error pinging a hive that is not loaded")
+	}
+	h := openBoltHive(t, path)
+	err := h.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With a pending change
+	err = h.SetInt("n", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfghive.PingHive(cfghive.NewSyncHive(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+	if h.Ping() == nil {
+		t.Fatal("No error pinging a closed hive")
+	}
+}
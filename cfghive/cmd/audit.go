@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// parseSince Parses a time in RFC 3339, or a duration before now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// auditPrinter Prints audit entries as a table, or as JSON lines.
+type auditPrinter struct {
+	json  *json.Encoder
+	table *tabwriter.Writer
+	rows  int
+}
+
+func newAuditPrinter(w io.Writer, asJSON bool) *auditPrinter {
+	if asJSON {
+		return &auditPrinter{json: json.NewEncoder(w)}
+	}
+	return &auditPrinter{table: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)}
+}
+
+// auditValue Formats a value of an entry, - if there is none.
+func auditValue(v *cfghive.HiveValue) string {
+	if v == nil {
+		return "-"
+	}
+	value := v.Value()
+	if sub, err := v.Sub(); err == nil {
+		value = cfghive.HiveMapToGeneric(sub)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return v.TypeString()
+	}
+	return string(b)
+}
+
+func (p *auditPrinter) Print(e *cfghive.AuditEntry) error {
+	if p.json != nil {
+		return p.json.Encode(e)
+	}
+	if p.rows == 0 {
+		fmt.Fprintln(p.table, "SEQ\tTIME\tUSER\tCLIENT\tOP\tKEY\tOLD\tNEW")
+	}
+	p.rows++
+	// The keys of the tenant and environment hives are shown as their api path, e.g. /t/acme/a/b.
+	key := "/" + e.Key
+	if e.Environment != "" {
+		key = "/environments/" + e.Environment + strings.TrimSuffix(key, "/")
+	}
+	if e.Tenant != "" {
+		key = "/t/" + e.Tenant + strings.TrimSuffix(key, "/")
+	}
+	_, err := fmt.Fprintf(p.table, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Seq, e.Time.Format(time.RFC3339),
+		e.User, e.Client, e.Op, key, auditValue(e.Old), auditValue(e.New))
+	return err
+}
+
+func (p *auditPrinter) Flush() error {
+	if p.table != nil {
+		return p.table.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// loadHiveData Loads all the data of a hive file, detecting its layout from the header.
+// A directory is loaded as a directory hive.
+func loadHiveData(path string) (map[string]cfghive.HiveValue, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		hive := cfghive.NewDirHive(path)
+		err = hive.Load()
+		if err != nil {
+			return nil, err
+		}
+		return *hive.GetData(), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	_, err = file.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfghive.IsMmapHive(header) {
+		hive := cfghive.NewMmapHive(path)
+		err = hive.Load()
+		if err != nil {
+			return nil, err
+		}
+		defer hive.Close()
+		return *hive.GetData(), nil
+	}
+	if cfghive.IsIndexedHive(header) {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		hive := cfghive.NewIndexedHive()
+		hive.Reader = file
+		hive.Size = info.Size()
+		err = hive.Load()
+		if err != nil {
+			return nil, err
+		}
+		return *hive.GetData(), nil
+	}
+
+	hive := cfghive.NewBinHive()
+	hive.Stream = bufio.NewReadWriter(bufio.NewReader(file), nil)
+	err = hive.Load()
+	if err != nil {
+		return nil, err
+	}
+	return *hive.GetData(), nil
+}
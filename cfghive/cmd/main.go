@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/melanblack/potential-framework/cfghive"
 	"github.com/urfave/cli/v2"
@@ -31,8 +32,24 @@ func main() {
 						Aliases:  []string{"c"},
 						Required: false,
 					},
+					&cli.StringFlag{
+						Name:     "codec",
+						Usage:    "Compression codec (" + strings.Join(cfghive.CodecNames(), ", ") + "), implies --compress",
+						Required: false,
+					},
 				},
 				Action: func(c *cli.Context) error {
+					var opts []cfghive.BinHiveOption
+					if c.IsSet("codec") {
+						id, err := cfghive.CodecByName(c.String("codec"))
+						if err != nil {
+							return err
+						}
+						opts = append(opts, cfghive.WithCompression(id, 9))
+					} else if c.Bool("compress") {
+						opts = append(opts, cfghive.WithCompression(cfghive.CodecGzip, 9))
+					}
+
 					file, err := os.Create(c.Args().Get(0))
 					if err != nil {
 						return err
@@ -48,7 +65,7 @@ func main() {
 					writer := bufio.NewWriter(file)
 					buf := bufio.NewReadWriter(reader, writer)
 
-					hive := cfghive.NewBinHive(c.Bool("compress"), 9)
+					hive := cfghive.NewBinHive(opts...)
 					hive.Stream = buf
 					err = hive.Save()
 					if err != nil {
@@ -80,7 +97,7 @@ func main() {
 					reader := bufio.NewReader(hiveFile)
 					writer := bufio.NewWriter(hiveFile)
 					buf := bufio.NewReadWriter(reader, writer)
-					hive := cfghive.NewBinHive(false, 0)
+					hive := cfghive.NewBinHive()
 					hive.Stream = buf
 					err = hive.Load()
 					_, err = hiveFile.Seek(0, 0)
@@ -118,27 +135,169 @@ func main() {
 				Name:      "dump",
 				ArgsUsage: "<hive file>",
 				Action: func(context *cli.Context) error {
-					file, err := os.Open(context.Args().Get(0))
+					data, err := loadHiveData(context.Args().Get(0))
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					cfghive.HiveDump(&data)
+					return nil
+				},
+			},
+			{
+				Name:      "index",
+				Usage:     "Converts a hive into the indexed layout, for lazy loading",
+				ArgsUsage: "<hive file> <indexed hive file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "codec",
+						Value:    "none",
+						Usage:    "Compression codec (" + strings.Join(cfghive.CodecNames(), ", ") + ")",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					id, err := cfghive.CodecByName(c.String("codec"))
+					if err != nil {
+						return err
+					}
+					data, err := loadHiveData(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+
+					file, err := os.Create(c.Args().Get(1))
 					if err != nil {
 						return err
 					}
 					defer file.Close()
+					writer := bufio.NewWriter(file)
+					err = cfghive.WriteIndexedHive(writer, data, cfghive.WithCompression(id, 9))
+					if err != nil {
+						return err
+					}
+					return writer.Flush()
+				},
+			},
+			{
+				Name:      "compile",
+				Usage:     "Compiles a hive into a read-only image that can be memory mapped",
+				ArgsUsage: "<hive file> <image file>",
+				Action: func(c *cli.Context) error {
+					data, err := loadHiveData(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
 
-					reader := bufio.NewReader(file)
+					file, err := os.Create(c.Args().Get(1))
+					if err != nil {
+						return err
+					}
+					defer file.Close()
 					writer := bufio.NewWriter(file)
-					buf := bufio.NewReadWriter(reader, writer)
-					hive := cfghive.NewBinHive(false, 0)
-					hive.Stream = buf
-					err = hive.Load()
+					err = cfghive.WriteMmapHive(writer, data)
 					if err != nil {
-						log.Fatal(err)
+						return err
+					}
+					err = writer.Flush()
+					if err != nil {
+						return err
+					}
+					fmt.Printf("compiled hive size: %d\n", cfghive.HiveSize(data))
+					return nil
+				},
+			},
+			{
+				Name:      "migrate",
+				Usage:     "Copies a hive into a bbolt database",
+				ArgsUsage: "<hive file> <bolt file>",
+				Action: func(c *cli.Context) error {
+					data, err := loadHiveData(c.Args().Get(0))
+					if err != nil {
+						return err
 					}
 
-					data := hive.GetData()
-					cfghive.HiveDump(data)
+					hive := cfghive.NewBoltHive(c.Args().Get(1))
+					err = hive.Load()
+					if err != nil {
+						return err
+					}
+					defer hive.Close()
+					for k, v := range data {
+						err = hive.Set(k, v.Value())
+						if err != nil {
+							hive.Rollback()
+							return err
+						}
+					}
+					_, err = hive.Commit()
+					if err != nil {
+						return err
+					}
+					fmt.Printf("migrated hive size: %d\n", cfghive.HiveSize(*hive.GetData()))
 					return nil
 				},
 			},
+			{
+				Name:      "audit",
+				Usage:     "Queries an audit log written by the api server, checking its hash chain",
+				ArgsUsage: "<audit log>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "user", Usage: "Only the changes of this user"},
+					&cli.StringFlag{Name: "tenant", Usage: "Only the changes of this tenant, - for the main hive"},
+					&cli.StringFlag{Name: "environment", Usage: "Only the changes of this environment, - for the other hives"},
+					&cli.StringFlag{Name: "key", Usage: "Only the changes of this key and its children"},
+					&cli.StringFlag{Name: "op", Usage: "Only the changes of this operation (set, delete, sub, rollback)"},
+					&cli.StringFlag{Name: "since", Usage: "Only the changes since a time (RFC 3339) or a duration ago"},
+					&cli.Uint64Flag{Name: "after", Usage: "Only the changes after this sequence number"},
+					&cli.IntFlag{Name: "limit", Usage: "The number of changes printed at most"},
+					&cli.BoolFlag{Name: "json", Usage: "Print the changes as JSON lines"},
+					&cli.BoolFlag{Name: "verify", Usage: "Only check the hash chain"},
+				},
+				Action: func(c *cli.Context) error {
+					q := cfghive.AuditQuery{
+						User:        c.String("user"),
+						Tenant:      c.String("tenant"),
+						Environment: c.String("environment"),
+						Key:         c.String("key"),
+						Op:          c.String("op"),
+						After:       c.Uint64("after"),
+						Limit:       c.Int("limit"),
+					}
+					if c.IsSet("since") {
+						since, err := parseSince(c.String("since"))
+						if err != nil {
+							return err
+						}
+						q.Since = since
+					}
+
+					file, err := os.Open(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					out := newAuditPrinter(os.Stdout, c.Bool("json"))
+					var n uint64
+					printed := 0
+					err = cfghive.ReadAudit(file, func(e *cfghive.AuditEntry) error {
+						n = e.Seq
+						if c.Bool("verify") || !q.Matches(e) || q.Limit > 0 && printed >= q.Limit {
+							return nil
+						}
+						printed++
+						return out.Print(e)
+					})
+					if err != nil {
+						return err
+					}
+					if c.Bool("verify") {
+						fmt.Printf("audit log is valid, %d entries\n", n)
+						return nil
+					}
+					return out.Flush()
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,182 @@
+package cfghive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec identifiers. The identifier is the first byte of a BinHive header.
+const (
+	CodecNone   byte = 0xC0
+	CodecGzip   byte = 0xC1
+	CodecZstd   byte = 0xC2
+	CodecLz4    byte = 0xC3
+	CodecSnappy byte = 0xC4
+)
+
+// Codec A compression codec used to wrap the serialized hive stream.
+type Codec interface {
+	// Name Gets the name of the codec, as used on the command line.
+	Name() string
+	// NewWriter Wraps w in a compressing writer.
+	// The level is codec specific, codecs without levels ignore it.
+	NewWriter(w io.Writer, level uint8) (io.WriteCloser, error)
+	// NewReader Wraps r in a decompressing reader.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = map[byte]Codec{
+	CodecNone:   noneCodec{},
+	CodecGzip:   gzipCodec{},
+	CodecZstd:   zstdCodec{},
+	CodecLz4:    lz4Codec{},
+	CodecSnappy: snappyCodec{},
+}
+
+// RegisterCodec Registers a codec under the given header identifier.
+// Returns an error if the identifier is already in use.
+func RegisterCodec(id byte, c Codec) error {
+	if _, ok := codecs[id]; ok {
+		return fmt.Errorf("codec id %x is already registered", id)
+	}
+	codecs[id] = c
+	return nil
+}
+
+// LookupCodec Gets the codec registered under the given header identifier.
+func LookupCodec(id byte) (Codec, error) {
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec id: %x", id)
+	}
+	return c, nil
+}
+
+// CodecByName Gets the identifier of the codec with the given name.
+func CodecByName(name string) (byte, error) {
+	for id, c := range codecs {
+		if c.Name() == name {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown codec: %s", name)
+}
+
+// CodecNames Gets the names of all registered codecs, sorted.
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string {
+	return "none"
+}
+
+func (noneCodec) NewWriter(w io.Writer, _ uint8) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level uint8) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, int(level))
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (zstdCodec) NewWriter(w io.Writer, level uint8) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(int(level))))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+type lz4Codec struct{}
+
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Fast,
+	lz4.Level1,
+	lz4.Level2,
+	lz4.Level3,
+	lz4.Level4,
+	lz4.Level5,
+	lz4.Level6,
+	lz4.Level7,
+	lz4.Level8,
+	lz4.Level9,
+}
+
+func (lz4Codec) Name() string {
+	return "lz4"
+}
+
+func (lz4Codec) NewWriter(w io.Writer, level uint8) (io.WriteCloser, error) {
+	if int(level) >= len(lz4Levels) {
+		return nil, fmt.Errorf("invalid lz4 level: %d", level)
+	}
+	lw := lz4.NewWriter(w)
+	err := lw.Apply(lz4.CompressionLevelOption(lz4Levels[level]))
+	if err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string {
+	return "snappy"
+}
+
+func (snappyCodec) NewWriter(w io.Writer, _ uint8) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
@@ -0,0 +1,394 @@
+package cfghive
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dirHiveKeepFile Marks empty sub-hives, so they survive in git.
+const dirHiveKeepFile = ".keep"
+
+// DirHive is a hive built on top of MemHive that is stored as a directory tree.
+// Each sub-hive is a directory and each value is a text file named after its key,
+// with the value type as the extension, e.g. "productParams/skuNum.int64".
+// Files and directories starting with a dot are ignored.
+type DirHive struct {
+	hive      *MemHive
+	root      string
+	hasChange bool
+	// The files written by the last Load or Save, relative to root, and their content.
+	files map[string]string
+}
+
+// NewDirHive Creates a hive stored in the directory root.
+func NewDirHive(root string) *DirHive {
+	h, _ := NewMemHive()
+	return &DirHive{
+		hive:  h,
+		root:  root,
+		files: make(map[string]string),
+	}
+}
+
+func (h *DirHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{false, true, false}
+}
+
+func formatTextValue(v HiveValue) string {
+	switch v.Type() {
+	case HiveTypeBool:
+		b, _ := v.Bool()
+		return strconv.FormatBool(b)
+	case HiveTypeByte:
+		b, _ := v.Byte()
+		return strconv.FormatUint(uint64(b), 10)
+	case HiveTypeInt64:
+		i, _ := v.Int64()
+		return strconv.FormatInt(i, 10)
+	case HiveTypeUint64:
+		i, _ := v.Uint64()
+		return strconv.FormatUint(i, 10)
+	case HiveTypeFloat64:
+		f, _ := v.Float64()
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case HiveTypeInt:
+		i, _ := v.Int()
+		return strconv.FormatInt(int64(i), 10)
+	case HiveTypeUint:
+		i, _ := v.Uint()
+		return strconv.FormatUint(uint64(i), 10)
+	case HiveTypeFloat32:
+		f, _ := v.Float32()
+		return strconv.FormatFloat(float64(f), 'g', -1, 32)
+	case HiveTypeString:
+		s, _ := v.String()
+		return s
+	case HiveTypeBytes:
+		b, _ := v.Bytes()
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return ""
+}
+
+func parseTextValue(t string, text string) (HiveValue, error) {
+	switch t {
+	case "bool":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(b)
+	case "byte":
+		i, err := strconv.ParseUint(text, 10, 8)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(byte(i))
+	case "int64":
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(i)
+	case "uint64":
+		i, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(i)
+	case "float64":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(f)
+	case "int":
+		i, err := strconv.ParseInt(text, 10, 0)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(int(i))
+	case "uint":
+		i, err := strconv.ParseUint(text, 10, 0)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(uint(i))
+	case "float32":
+		f, err := strconv.ParseFloat(text, 32)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(float32(f))
+	case "string":
+		return NewHiveValue(text)
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return HiveValue{}, err
+		}
+		return NewHiveValue(b)
+	}
+	return HiveValue{}, fmt.Errorf("unknown value type %s", t)
+}
+
+// checkDirHiveName Ensures a key element can be stored as a file name.
+// Names ending in a type extension are rejected, as the directory of a sub-hive "x.int"
+// would be the file of the int value "x".
+func checkDirHiveName(name string) error {
+	if strings.HasPrefix(name, ".") || strings.ContainsAny(name, `\`+string(filepath.Separator)) {
+		return fmt.Errorf("%w: key element %q cannot be stored in a directory hive", ErrInvalidKey, name)
+	}
+	ext := filepath.Ext(name)
+	for _, t := range HiveTypeMap {
+		if ext == "."+t && t != "sub" {
+			return fmt.Errorf("%w: key element %q ends in a type extension", ErrInvalidKey, name)
+		}
+	}
+	return nil
+}
+
+// Load Reads the directory tree into memory.
+// A missing root directory is an empty hive.
+func (h *DirHive) Load() error {
+	data := make(map[string]HiveValue)
+	files := make(map[string]string)
+	_, err := os.Stat(h.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		h.hive.data = data
+		h.files = files
+		h.hasChange = false
+		return nil
+	}
+	err = filepath.WalkDir(h.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(h.root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		name := elems[len(elems)-1]
+		if strings.HasPrefix(name, ".") {
+			if name == dirHiveKeepFile && !d.IsDir() {
+				files[filepath.ToSlash(rel)] = ""
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Directories are walked before their content, so the parent exists.
+		parent := data
+		for _, e := range elems[:len(elems)-1] {
+			next := parent[e]
+			parent, _ = next.Sub()
+		}
+		if d.IsDir() {
+			parent[name], _ = NewHiveValue(make(map[string]HiveValue))
+			return nil
+		}
+		ext := filepath.Ext(name)
+		if ext == "" {
+			return fmt.Errorf("%s has no type extension", path)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		text := strings.TrimSuffix(string(content), "\n")
+		v, err := parseTextValue(ext[1:], text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		parent[strings.TrimSuffix(name, ext)] = v
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.hive.data = data
+	h.files = files
+	h.hasChange = false
+	return nil
+}
+
+// dirHiveFiles Gets the files representing data, relative to the hive root, and their content.
+func dirHiveFiles(data map[string]HiveValue, prefix string, files map[string]string) error {
+	if prefix != "" && len(data) == 0 {
+		files[prefix+dirHiveKeepFile] = ""
+	}
+	for k, v := range data {
+		err := checkDirHiveName(k)
+		if err != nil {
+			return err
+		}
+		if v.IsStoredType(HiveTypeSub) {
+			sub, _ := v.Sub()
+			err = dirHiveFiles(sub, prefix+k+"/", files)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		files[prefix+k+"."+v.TypeString()] = formatTextValue(v) + "\n"
+	}
+	return nil
+}
+
+// Save Writes the hive to the directory tree.
+// Only files whose content changed since the last Load or Save are written,
+// and files of deleted keys are removed.
+func (h *DirHive) Save() error {
+	files := make(map[string]string)
+	err := dirHiveFiles(h.hive.data, "", files)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(h.root, 0755)
+	if err != nil {
+		return err
+	}
+	for rel, content := range files {
+		old, ok := h.files[rel]
+		if ok && old == content {
+			continue
+		}
+		path := filepath.Join(h.root, filepath.FromSlash(rel))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove stale files, then the directories they leave empty, deepest first.
+	var dirs []string
+	for rel := range h.files {
+		if _, ok := files[rel]; ok {
+			continue
+		}
+		err = os.Remove(filepath.Join(h.root, filepath.FromSlash(rel)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		// Fails for directories that are still in use, which is what we want.
+		os.Remove(filepath.Join(h.root, filepath.FromSlash(dir)))
+	}
+
+	h.files = files
+	h.hasChange = false
+	return nil
+}
+
+func (h *DirHive) Get(key string) (*HiveValue, error) {
+	return h.hive.Get(key)
+}
+
+func (h *DirHive) GetBool(key string) (bool, error) {
+	return h.hive.GetBool(key)
+}
+
+func (h *DirHive) GetInt(key string) (int, error) {
+	return h.hive.GetInt(key)
+}
+
+func (h *DirHive) GetFloat(key string) (float64, error) {
+	return h.hive.GetFloat(key)
+}
+
+func (h *DirHive) GetString(key string) (*string, error) {
+	return h.hive.GetString(key)
+}
+
+func (h *DirHive) Set(key string, value interface{}) error {
+	path := pathToKeys(key)
+	if len(path) > 0 {
+		err := checkDirHiveName(path[len(path)-1])
+		if err != nil {
+			return err
+		}
+	}
+	h.hasChange = true
+	return h.hive.Set(key, value)
+}
+
+func (h *DirHive) SetBool(key string, value bool) error {
+	return h.Set(key, value)
+}
+
+func (h *DirHive) SetInt(key string, value int) error {
+	return h.Set(key, value)
+}
+
+func (h *DirHive) SetFloat(key string, value float64) error {
+	return h.Set(key, value)
+}
+
+func (h *DirHive) SetString(key string, value string) error {
+	return h.Set(key, value)
+}
+
+func (h *DirHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	return compareAndSet(h.Get, h.Set, key, old, value)
+}
+
+func (h *DirHive) Delete(key string) {
+	h.hasChange = true
+	h.hive.Delete(key)
+}
+
+func (h *DirHive) NewSub(key string) {
+	h.Set(key, make(map[string]HiveValue))
+}
+
+// Rollback Reloads the directory tree, discarding the changes since the last Load or Save.
+func (h *DirHive) Rollback() (bool, error) {
+	if !h.hasChange {
+		return false, nil
+	}
+	err := h.Load()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *DirHive) Commit() (bool, error) {
+	if !h.hasChange {
+		return false, nil
+	}
+	err := h.Save()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *DirHive) GetData() *map[string]HiveValue {
+	return h.hive.GetData()
+}
@@ -0,0 +1,162 @@
+package cfghive_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func TestDirHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewDirHive("")
+}
+
+func TestDirHiveLayout(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "hive")
+	h := cfghive.NewDirHive(root)
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NewSub("productParams")
+	err = h.Set("productParams/skuNum", int64(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.SetString("productParams/productName", "Ubuntu Desktop\n23.04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("blob", []byte{0xff, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NewSub("empty")
+	err = h.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "productParams", "skuNum.int64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "100\n" {
+		t.Fatalf("unexpected file content %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, "empty", ".keep")); err != nil {
+		t.Fatal(err)
+	}
+
+	h2 := cfghive.NewDirHive(root)
+	err = h2.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := h2.GetString("productParams/productName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "Ubuntu Desktop\n23.04" {
+		t.Fatalf("unexpected value %q", *s)
+	}
+	v, err := h2.Get("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := v.Bytes(); len(b) != 2 || b[0] != 0xff {
+		t.Fatalf("unexpected value %v", b)
+	}
+	if size := cfghive.HiveSize(*h2.GetData()); size != 3 {
+		t.Fatalf("hive size is %d, expected 3", size)
+	}
+	if err := h2.Set(".git", "x"); err == nil {
+		t.Fatal("No error when setting a hidden key")
+	}
+	// The directory of the sub-hive would be the file of the int value x.
+	if err := h2.Set("x.int", map[string]cfghive.HiveValue{}); !errors.Is(err, cfghive.ErrInvalidKey) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := h2.Set("x.sub", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirHiveSaveOnlyChanges(t *testing.T) {
+	root := t.TempDir()
+	h := cfghive.NewDirHive(root)
+	h.NewSub("a")
+	h.NewSub("a/b")
+	for _, k := range []string{"a/one", "a/two", "a/b/three"} {
+		if err := h.SetString(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	untouched := filepath.Join(root, "a", "one.string")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(untouched, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetInt("a/two", 2); err != nil {
+		t.Fatal(err)
+	}
+	h.Delete("a/b")
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("hive with changes was not committed")
+	}
+
+	info, err := os.Stat(untouched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Fatal("unchanged file was rewritten")
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "two.string")); err == nil {
+		t.Fatal("file of the old value type was not removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "two.int")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "b")); err == nil {
+		t.Fatal("directory of a deleted sub-hive was not removed")
+	}
+}
+
+func TestDirHiveRollback(t *testing.T) {
+	root := t.TempDir()
+	h := cfghive.NewDirHive(root)
+	if err := h.SetString("kept", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetString("dropped", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, err := h.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rolledBack {
+		t.Fatal("pending changes were not rolled back")
+	}
+	if _, err := h.Get("dropped"); err == nil {
+		t.Fatal("rolled back value still exists")
+	}
+	if _, err := h.Get("kept"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,33 @@
+package cfghive
+
+import "errors"
+
+// FailAuditWrites Makes the writes of l fail after writing n bytes of them, until the returned
+// function is called.
+func FailAuditWrites(l *AuditLog, n int) func() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	file := l.file
+	l.file = &failingAuditFile{auditFile: file, n: n}
+	return func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.file = file
+	}
+}
+
+type failingAuditFile struct {
+	auditFile
+	n int
+}
+
+func (f *failingAuditFile) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.auditFile.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, errors.New("no space left on device")
+}
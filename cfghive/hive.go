@@ -1,9 +1,38 @@
 package cfghive
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 )
 
+// Errors returned by hives, which can be tested with errors.Is.
+var (
+	// ErrInvalidKey The key is empty, or cannot be stored by the hive.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrKeyNotFound The key, or one of its parents, does not exist.
+	ErrKeyNotFound = errors.New("does not exist")
+	// ErrNotSubHive A key element is a value, not a sub-hive.
+	ErrNotSubHive = errors.New("is not a subhive")
+	// ErrInvalidType The value type cannot be stored in a hive.
+	ErrInvalidType = errors.New("invalid type")
+	// ErrReadOnly The hive cannot be changed.
+	ErrReadOnly = errors.New("hive is read-only")
+)
+
+// errEmptyKey Is returned for keys without path elements.
+var errEmptyKey = fmt.Errorf("%w: a key must have at least one path element", ErrInvalidKey)
+
+// errKeyNotFound Reports a missing key.
+func errKeyNotFound(key string) error {
+	return fmt.Errorf("key %s %w", key, ErrKeyNotFound)
+}
+
+// errNotSubHive Reports a path element that is a value.
+func errNotSubHive(pf string) error {
+	return fmt.Errorf("%s is not at the path leaf, and %w", pf, ErrNotSubHive)
+}
+
 type HiveCharacteristics struct {
 	// Is the hive implementation transactional?
 	IsTxn bool
@@ -36,6 +65,11 @@ type Hive interface {
 	SetFloat(key string, value float64) error
 	SetString(key string, value string) error
 
+	// CompareAndSet Sets a value only if the current value equals old,
+	// or if old is nil and the key does not exist.
+	// Returns false if the value was not set because it did not match.
+	CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error)
+
 	// Delete Deletes a value from the hive.
 	// Returns the old value, or nil if the value did not exist.
 	Delete(key string)
@@ -61,6 +95,20 @@ type Hive interface {
 	GetData() *map[string]HiveValue
 }
 
+// Pinger A hive whose storage backend can be checked, e.g. for the readiness of a server.
+type Pinger interface {
+	// Ping Returns an error if the hive is not loaded, or its backend cannot be reached.
+	Ping() error
+}
+
+// PingHive Pings hive if it is a Pinger. Other hives are always ready once loaded.
+func PingHive(hive Hive) error {
+	if p, ok := hive.(Pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
 func pathToKeys(path string) []string {
 	path = strings.TrimSuffix(path, "/")
 	if path == "" {
@@ -68,3 +116,44 @@ func pathToKeys(path string) []string {
 	}
 	return strings.Split(path, "/")
 }
+
+// normalizeKey Gets the canonical form of a key, and its path elements.
+func normalizeKey(key string) (string, []string, error) {
+	path := pathToKeys(key)
+	if len(path) == 0 {
+		return "", nil, errEmptyKey
+	}
+	return strings.Join(path, "/"), path, nil
+}
+
+// valueMatches Reports whether the current value of a key, nil if it does not exist, is old.
+func valueMatches(cur *HiveValue, old *HiveValue) bool {
+	if old == nil || cur == nil {
+		return old == nil && cur == nil
+	}
+	return cur.Equal(old)
+}
+
+// compareAndSet Implements CompareAndSet with the get and set of a hive.
+// Hives shared between goroutines must hold their lock around it.
+func compareAndSet(get func(key string) (*HiveValue, error), set func(key string, value interface{}) error,
+	key string, old *HiveValue, value interface{}) (bool, error) {
+	cur, err := get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return false, err
+	}
+	if !valueMatches(cur, old) {
+		return false, nil
+	}
+	err = set(key, value)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// prefixRange Gets the bounds of the paths below key, for stores sorted by path.
+// '0' is the character after '/', so every path starting with key + "/" is in the range.
+func prefixRange(key string) (string, string) {
+	return key + "/", key + "0"
+}
@@ -0,0 +1,146 @@
+package cfghive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// HiveTypeByName Gets the type with the given name, as returned by HiveValue.TypeString.
+func HiveTypeByName(name string) (byte, bool) {
+	for t, n := range HiveTypeMap {
+		if n == name {
+			return byte(t), true
+		}
+	}
+	return 0, false
+}
+
+type jsonHiveValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON Encodes the value with its type, as {"type": "int64", "value": 100}.
+// Sub-hive values are objects of typed values, and bytes are base64 strings.
+func (v HiveValue) MarshalJSON() ([]byte, error) {
+	var raw []byte
+	var err error
+	switch v.storedType {
+	case HiveTypeSub:
+		raw, err = json.Marshal(v.value.(map[string]HiveValue))
+	case HiveTypeFloat32:
+		// Avoid the float64 widening noise, 0.1 and not 0.10000000149011612.
+		raw = []byte(strconv.FormatFloat(float64(v.value.(float32)), 'g', -1, 32))
+		if math.IsInf(float64(v.value.(float32)), 0) || math.IsNaN(float64(v.value.(float32))) {
+			err = fmt.Errorf("unsupported float32 value %v", v.value)
+		}
+	default:
+		raw, err = json.Marshal(v.value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonHiveValue{Type: v.TypeString(), Value: raw})
+}
+
+// UnmarshalJSON Decodes a value encoded by MarshalJSON.
+// Integers are decoded exactly, and must fit in their type.
+func (v *HiveValue) UnmarshalJSON(data []byte) error {
+	var jv jsonHiveValue
+	err := json.Unmarshal(data, &jv)
+	if err != nil {
+		return err
+	}
+	t, ok := HiveTypeByName(jv.Type)
+	if !ok {
+		return fmt.Errorf("invalid type %q", jv.Type)
+	}
+	if len(jv.Value) == 0 {
+		return fmt.Errorf("missing %s value", jv.Type)
+	}
+	var value interface{}
+	switch t {
+	case HiveTypeBool:
+		var b bool
+		err = json.Unmarshal(jv.Value, &b)
+		value = b
+	case HiveTypeByte:
+		var u uint64
+		u, err = parseJSONUint(jv.Value, 8)
+		value = byte(u)
+	case HiveTypeUint64:
+		value, err = parseJSONUint(jv.Value, 64)
+	case HiveTypeUint:
+		var u uint64
+		u, err = parseJSONUint(jv.Value, strconv.IntSize)
+		value = uint(u)
+	case HiveTypeInt64:
+		value, err = parseJSONInt(jv.Value, 64)
+	case HiveTypeInt:
+		var i int64
+		i, err = parseJSONInt(jv.Value, strconv.IntSize)
+		value = int(i)
+	case HiveTypeFloat64:
+		var f float64
+		err = json.Unmarshal(jv.Value, &f)
+		value = f
+	case HiveTypeFloat32:
+		var f float64
+		err = json.Unmarshal(jv.Value, &f)
+		if err == nil && math.Abs(f) > math.MaxFloat32 {
+			err = fmt.Errorf("value %v overflows float32", f)
+		}
+		value = float32(f)
+	case HiveTypeString:
+		var s string
+		err = json.Unmarshal(jv.Value, &s)
+		value = s
+	case HiveTypeBytes:
+		var b []byte
+		err = json.Unmarshal(jv.Value, &b)
+		if b == nil {
+			b = []byte{}
+		}
+		value = b
+	case HiveTypeSub:
+		sub := make(map[string]HiveValue)
+		err = json.Unmarshal(jv.Value, &sub)
+		value = sub
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %w", jv.Type, err)
+	}
+	hv, err := NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	*v = hv
+	return nil
+}
+
+func decodeJSONNumber(raw []byte) (json.Number, error) {
+	var n json.Number
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	err := d.Decode(&n)
+	return n, err
+}
+
+func parseJSONUint(raw []byte, bits int) (uint64, error) {
+	n, err := decodeJSONNumber(raw)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(n.String(), 10, bits)
+}
+
+func parseJSONInt(raw []byte, bits int) (int64, error) {
+	n, err := decodeJSONNumber(raw)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(n.String(), 10, bits)
+}
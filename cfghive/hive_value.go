@@ -1,8 +1,11 @@
 package cfghive
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 )
 
 const (
@@ -87,7 +90,7 @@ func NewHiveValue(v interface{}) (HiveValue, error) {
 		}
 		hv.value = value
 	default:
-		return hv, fmt.Errorf("invalid type %T", v)
+		return hv, fmt.Errorf("%w %T", ErrInvalidType, v)
 	}
 	return hv, nil
 }
@@ -185,6 +188,30 @@ func (v *HiveValue) Sub() (map[string]HiveValue, error) {
 	return v.value.(map[string]HiveValue), nil
 }
 
+// Equal Reports whether both values have the same type and value. Sub-hives are compared deeply.
+func (v *HiveValue) Equal(o *HiveValue) bool {
+	if v.storedType != o.storedType {
+		return false
+	}
+	switch v.storedType {
+	case HiveTypeBytes:
+		return bytes.Equal(v.value.([]byte), o.value.([]byte))
+	case HiveTypeSub:
+		a, b := v.value.(map[string]HiveValue), o.value.(map[string]HiveValue)
+		if len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !av.Equal(&bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.value == o.value
+}
+
 func (v *HiveValue) TypeString() string {
 	return HiveTypeMap[int(v.storedType)]
 }
@@ -201,6 +228,18 @@ func GenericMapToSubMap(v map[string]interface{}) (map[string]HiveValue, error)
 	return sub, nil
 }
 
+// copyHiveData Deep copies hive data, so the copy shares no sub-hive map with data.
+func copyHiveData(data map[string]HiveValue) map[string]HiveValue {
+	c := make(map[string]HiveValue, len(data))
+	for k, v := range data {
+		if sub, err := v.Sub(); err == nil {
+			v, _ = NewHiveValue(copyHiveData(sub))
+		}
+		c[k] = v
+	}
+	return c
+}
+
 func HiveMapToGeneric(hive map[string]HiveValue) map[string]interface{} {
 	generic := make(map[string]interface{})
 	for k, v := range hive {
@@ -231,3 +270,86 @@ func HiveMapToGeneric(hive map[string]HiveValue) map[string]interface{} {
 	}
 	return generic
 }
+
+// valueSizes The encoded size of the fixed size types.
+var valueSizes = map[byte]int{
+	HiveTypeBool:    1,
+	HiveTypeByte:    1,
+	HiveTypeInt64:   8,
+	HiveTypeUint64:  8,
+	HiveTypeFloat64: 8,
+	HiveTypeInt:     8,
+	HiveTypeUint:    8,
+	HiveTypeFloat32: 4,
+}
+
+// encodeValue Encodes a leaf value in a little endian layout, without its type.
+// Returns nil for sub-hives.
+func encodeValue(v HiveValue) []byte {
+	switch v.Type() {
+	case HiveTypeBool:
+		if b, _ := v.Bool(); b {
+			return []byte{1}
+		}
+		return []byte{0}
+	case HiveTypeByte:
+		b, _ := v.Byte()
+		return []byte{b}
+	case HiveTypeInt64:
+		i, _ := v.Int64()
+		return binary.LittleEndian.AppendUint64(nil, uint64(i))
+	case HiveTypeUint64:
+		i, _ := v.Uint64()
+		return binary.LittleEndian.AppendUint64(nil, i)
+	case HiveTypeFloat64:
+		f, _ := v.Float64()
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(f))
+	case HiveTypeInt:
+		i, _ := v.Int()
+		return binary.LittleEndian.AppendUint64(nil, uint64(i))
+	case HiveTypeUint:
+		i, _ := v.Uint()
+		return binary.LittleEndian.AppendUint64(nil, uint64(i))
+	case HiveTypeFloat32:
+		f, _ := v.Float32()
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(f))
+	case HiveTypeString:
+		s, _ := v.String()
+		return []byte(s)
+	case HiveTypeBytes:
+		b, _ := v.Bytes()
+		return b
+	}
+	return nil
+}
+
+// decodeValue Decodes a leaf value of type t encoded by encodeValue.
+// Bytes values share b.
+func decodeValue(t byte, b []byte) (HiveValue, error) {
+	if size, ok := valueSizes[t]; ok && len(b) != size {
+		return HiveValue{}, fmt.Errorf("invalid encoded %s length: %d", HiveTypeMap[int(t)], len(b))
+	}
+	switch t {
+	case HiveTypeBool:
+		return NewHiveValue(b[0] != 0)
+	case HiveTypeByte:
+		return NewHiveValue(b[0])
+	case HiveTypeInt64:
+		return NewHiveValue(int64(binary.LittleEndian.Uint64(b)))
+	case HiveTypeUint64:
+		return NewHiveValue(binary.LittleEndian.Uint64(b))
+	case HiveTypeFloat64:
+		return NewHiveValue(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case HiveTypeInt:
+		return NewHiveValue(int(int64(binary.LittleEndian.Uint64(b))))
+	case HiveTypeUint:
+		return NewHiveValue(uint(binary.LittleEndian.Uint64(b)))
+	case HiveTypeFloat32:
+		return NewHiveValue(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case HiveTypeString:
+		return NewHiveValue(string(b))
+	case HiveTypeBytes:
+		return NewHiveValue(b)
+	}
+	return HiveValue{}, fmt.Errorf("invalid stored type %d", t)
+}
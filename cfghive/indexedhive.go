@@ -0,0 +1,452 @@
+package cfghive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// Layout of an indexed hive file:
+//
+//	header  | magic "CHIX", version, codec id, level, reserved, hive size (uint64)
+//	nodes   | one compressed msgpack blob per sub-hive, children before their parent
+//	trailer | root offset (uint64), root length (uint64)
+//
+// Every blob holds the leaf values of one sub-hive and the offset and length of
+// each of its child sub-hives, so a single key can be read by decoding only the
+// blobs along its path.
+const (
+	indexedHiveVersion     = 1
+	indexedHiveHeaderSize  = 16
+	indexedHiveTrailerSize = 16
+)
+
+var indexedHiveMagic = []byte("CHIX")
+
+// IsIndexedHive Reports whether the header belongs to an indexed hive.
+func IsIndexedHive(header []byte) bool {
+	return bytes.HasPrefix(header, indexedHiveMagic)
+}
+
+type indexRef struct {
+	Off uint64 `codec:"o"`
+	Len uint64 `codec:"l"`
+}
+
+type indexBlob struct {
+	Values map[string]interface{} `codec:"v"`
+	Subs   map[string]indexRef    `codec:"s"`
+}
+
+type indexNode struct {
+	ref    indexRef
+	loaded bool
+	values map[string]HiveValue
+	subs   map[string]*indexNode
+}
+
+// IndexedHive is a persistent hive whose sub-hives are decoded lazily on first access.
+// Reader must be set before Load, and Writer before Save or Commit.
+type IndexedHive struct {
+	Reader    io.ReaderAt
+	Size      int64
+	Writer    io.Writer
+	root      *indexNode
+	hasChange bool
+	codecId   byte
+	compLevel uint8
+}
+
+// NewIndexedHive Creates a new, empty, indexed hive.
+func NewIndexedHive(opts ...BinHiveOption) *IndexedHive {
+	o := newBinHiveOptions(opts)
+	return &IndexedHive{
+		root:      newLoadedNode(),
+		hasChange: false,
+		codecId:   o.codecId,
+		compLevel: o.compLevel,
+	}
+}
+
+func newLoadedNode() *indexNode {
+	return &indexNode{
+		loaded: true,
+		values: make(map[string]HiveValue),
+		subs:   make(map[string]*indexNode),
+	}
+}
+
+func (h *IndexedHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{false, true, false}
+}
+
+// Codec Gets the codec identifier and level the hive is saved with.
+func (h *IndexedHive) Codec() (byte, uint8) {
+	return h.codecId, h.compLevel
+}
+
+// Load Reads the header and the root index. Sub-hives are decoded on first access.
+func (h *IndexedHive) Load() error {
+	if h.Reader == nil {
+		return errors.New("indexed hive has no reader")
+	}
+	if h.Size < indexedHiveHeaderSize+indexedHiveTrailerSize {
+		return fmt.Errorf("invalid indexed hive size: %d", h.Size)
+	}
+	header := make([]byte, indexedHiveHeaderSize)
+	_, err := h.Reader.ReadAt(header, 0)
+	if err != nil {
+		return err
+	}
+	if !IsIndexedHive(header) {
+		return fmt.Errorf("invalid indexed hive magic: %x", header[:4])
+	}
+	if header[4] != indexedHiveVersion {
+		return fmt.Errorf("unsupported indexed hive version: %d", header[4])
+	}
+	_, err = LookupCodec(header[5])
+	if err != nil {
+		return err
+	}
+	trailer := make([]byte, indexedHiveTrailerSize)
+	_, err = h.Reader.ReadAt(trailer, h.Size-indexedHiveTrailerSize)
+	if err != nil {
+		return err
+	}
+	h.codecId = header[5]
+	h.compLevel = header[6]
+	h.root = &indexNode{ref: indexRef{
+		Off: binary.BigEndian.Uint64(trailer[0:8]),
+		Len: binary.BigEndian.Uint64(trailer[8:16]),
+	}}
+	h.hasChange = false
+	return h.load(h.root)
+}
+
+func (h *IndexedHive) load(n *indexNode) error {
+	if n.loaded {
+		return nil
+	}
+	size := uint64(h.Size)
+	if n.ref.Off > size || n.ref.Len > size-n.ref.Off {
+		return fmt.Errorf("sub-hive at offset %d is out of bounds", n.ref.Off)
+	}
+	c, err := LookupCodec(h.codecId)
+	if err != nil {
+		return err
+	}
+	cr, err := c.NewReader(io.NewSectionReader(h.Reader, int64(n.ref.Off), int64(n.ref.Len)))
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+	var blob indexBlob
+	err = codec.NewDecoder(cr, configureCodec()).Decode(&blob)
+	if err != nil {
+		return err
+	}
+	values, err := GenericMapToSubMap(blob.Values)
+	if err != nil {
+		return err
+	}
+	n.values = values
+	n.subs = make(map[string]*indexNode, len(blob.Subs))
+	for k, ref := range blob.Subs {
+		n.subs[k] = &indexNode{ref: ref}
+	}
+	n.loaded = true
+	return nil
+}
+
+// walk Gets the loaded node holding the last element of the path.
+func (h *IndexedHive) walk(key string) (*indexNode, string, error) {
+	path := pathToKeys(key)
+	if len(path) == 0 {
+		return nil, "", errEmptyKey
+	}
+	n := h.root
+	for _, pf := range path[:len(path)-1] {
+		err := h.load(n)
+		if err != nil {
+			return nil, "", err
+		}
+		next, ok := n.subs[pf]
+		if !ok {
+			if _, ok := n.values[pf]; ok {
+				return nil, "", errNotSubHive(pf)
+			}
+			return nil, "", errKeyNotFound(key)
+		}
+		n = next
+	}
+	err := h.load(n)
+	if err != nil {
+		return nil, "", err
+	}
+	return n, path[len(path)-1], nil
+}
+
+// materialize Decodes the node and all of its descendants into a sub map.
+func (h *IndexedHive) materialize(n *indexNode) (map[string]HiveValue, error) {
+	err := h.load(n)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]HiveValue, len(n.values)+len(n.subs))
+	for k, v := range n.values {
+		data[k] = v
+	}
+	for k, sn := range n.subs {
+		sub, err := h.materialize(sn)
+		if err != nil {
+			return nil, err
+		}
+		data[k], _ = NewHiveValue(sub)
+	}
+	return data, nil
+}
+
+func (h *IndexedHive) Get(key string) (*HiveValue, error) {
+	n, leaf, err := h.walk(key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := n.values[leaf]; ok {
+		return &v, nil
+	}
+	sn, ok := n.subs[leaf]
+	if !ok {
+		return nil, errKeyNotFound(key)
+	}
+	sub, err := h.materialize(sn)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := NewHiveValue(sub)
+	return &v, nil
+}
+
+func (h *IndexedHive) GetBool(key string) (bool, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+func (h *IndexedHive) GetInt(key string) (int, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int()
+}
+
+func (h *IndexedHive) GetFloat(key string) (float64, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (h *IndexedHive) GetString(key string) (*string, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func nodeFromSub(sub map[string]HiveValue) *indexNode {
+	n := newLoadedNode()
+	for k, v := range sub {
+		if v.IsStoredType(HiveTypeSub) {
+			s, _ := v.Sub()
+			n.subs[k] = nodeFromSub(s)
+		} else {
+			n.values[k] = v
+		}
+	}
+	return n
+}
+
+func (h *IndexedHive) Set(key string, value interface{}) error {
+	val, err := NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	n, leaf, err := h.walk(key)
+	if err != nil {
+		return err
+	}
+	delete(n.values, leaf)
+	delete(n.subs, leaf)
+	if val.IsStoredType(HiveTypeSub) {
+		sub, _ := val.Sub()
+		n.subs[leaf] = nodeFromSub(sub)
+	} else {
+		n.values[leaf] = val
+	}
+	h.hasChange = true
+	return nil
+}
+
+func (h *IndexedHive) SetBool(key string, value bool) error {
+	return h.Set(key, value)
+}
+
+func (h *IndexedHive) SetInt(key string, value int) error {
+	return h.Set(key, value)
+}
+
+func (h *IndexedHive) SetFloat(key string, value float64) error {
+	return h.Set(key, value)
+}
+
+func (h *IndexedHive) SetString(key string, value string) error {
+	return h.Set(key, value)
+}
+
+func (h *IndexedHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	return compareAndSet(h.Get, h.Set, key, old, value)
+}
+
+func (h *IndexedHive) Delete(key string) {
+	n, leaf, err := h.walk(key)
+	if err != nil {
+		return
+	}
+	delete(n.values, leaf)
+	delete(n.subs, leaf)
+	h.hasChange = true
+}
+
+func (h *IndexedHive) NewSub(key string) {
+	h.Set(key, make(map[string]HiveValue))
+}
+
+func (h *IndexedHive) Rollback() (bool, error) {
+	return false, nil
+}
+
+func (h *IndexedHive) Commit() (bool, error) {
+	if !h.hasChange {
+		return false, nil
+	}
+	err := h.Save()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Save Decodes every sub-hive that was not loaded yet and writes the whole hive to Writer.
+func (h *IndexedHive) Save() error {
+	if h.Writer == nil {
+		return errors.New("indexed hive has no writer")
+	}
+	data, err := h.materialize(h.root)
+	if err != nil {
+		return err
+	}
+	err = WriteIndexedHive(h.Writer, data, WithCompression(h.codecId, h.compLevel))
+	if err != nil {
+		return err
+	}
+	h.hasChange = false
+	return nil
+}
+
+// GetData Decodes the whole hive.
+// Unlike MemHive, changes to the returned map are not reflected in the hive.
+func (h *IndexedHive) GetData() *map[string]HiveValue {
+	data, err := h.materialize(h.root)
+	if err != nil {
+		data = make(map[string]HiveValue)
+	}
+	return &data
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// WriteIndexedHive Writes data to w in the indexed hive layout.
+func WriteIndexedHive(w io.Writer, data map[string]HiveValue, opts ...BinHiveOption) error {
+	o := newBinHiveOptions(opts)
+	c, err := LookupCodec(o.codecId)
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: w}
+	header := append([]byte{}, indexedHiveMagic...)
+	header = append(header, indexedHiveVersion, o.codecId, o.compLevel, 0)
+	header = binary.BigEndian.AppendUint64(header, uint64(HiveSize(data)))
+	_, err = cw.Write(header)
+	if err != nil {
+		return err
+	}
+	root, err := writeIndexNode(cw, c, o.compLevel, data)
+	if err != nil {
+		return err
+	}
+	trailer := binary.BigEndian.AppendUint64(nil, root.Off)
+	trailer = binary.BigEndian.AppendUint64(trailer, root.Len)
+	_, err = cw.Write(trailer)
+	return err
+}
+
+func writeIndexNode(cw *countingWriter, c Codec, level uint8, data map[string]HiveValue) (indexRef, error) {
+	blob := indexBlob{Subs: make(map[string]indexRef)}
+	leaves := make(map[string]HiveValue)
+	for k, v := range data {
+		if !v.IsStoredType(HiveTypeSub) {
+			leaves[k] = v
+			continue
+		}
+		sub, _ := v.Sub()
+		ref, err := writeIndexNode(cw, c, level, sub)
+		if err != nil {
+			return indexRef{}, err
+		}
+		blob.Subs[k] = ref
+	}
+	blob.Values = HiveMapToGeneric(leaves)
+
+	var buf bytes.Buffer
+	zw, err := c.NewWriter(&buf, level)
+	if err != nil {
+		return indexRef{}, err
+	}
+	err = codec.NewEncoder(zw, configureCodec()).Encode(&blob)
+	if err != nil {
+		zw.Close()
+		return indexRef{}, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return indexRef{}, err
+	}
+	ref := indexRef{Off: cw.n, Len: uint64(buf.Len())}
+	_, err = cw.Write(buf.Bytes())
+	if err != nil {
+		return indexRef{}, err
+	}
+	return ref, nil
+}
@@ -0,0 +1,168 @@
+package cfghive_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// countingReaderAt Counts the bytes read through it.
+type countingReaderAt struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += n
+	return n, err
+}
+
+func writeIndexed(t testing.TB, n int, opts ...cfghive.BinHiveOption) []byte {
+	var buf bytes.Buffer
+	err := cfghive.WriteIndexedHive(&buf, *newProductHive(t, n).GetData(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openIndexed(t testing.TB, data []byte) (*cfghive.IndexedHive, *countingReaderAt) {
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	h := cfghive.NewIndexedHive()
+	h.Reader = r
+	h.Size = int64(len(data))
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, r
+}
+
+func TestIndexedHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewIndexedHive()
+}
+
+func TestIndexedHiveLazyGet(t *testing.T) {
+	data := writeIndexed(t, 200, cfghive.WithCompression(cfghive.CodecZstd, 3))
+	if !cfghive.IsIndexedHive(data) {
+		t.Fatal("missing indexed hive magic")
+	}
+	h, r := openIndexed(t, data)
+	s, err := h.GetString("product42/license/company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "Acme Inc." {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	if r.n >= len(data)/10 {
+		t.Fatalf("read %d of %d bytes for a single key", r.n, len(data))
+	}
+	_, err = h.Get("product42/license/company/name")
+	if err == nil {
+		t.Fatal("No error when getting a value below a leaf")
+	}
+	_, err = h.Get("product9000/license")
+	if err == nil {
+		t.Fatal("No error when getting a non-existent key")
+	}
+	if size := cfghive.HiveSize(*h.GetData()); size != 200*9 {
+		t.Fatalf("hive size is %d, expected %d", size, 200*9)
+	}
+}
+
+func TestIndexedHiveGetSub(t *testing.T) {
+	h, _ := openIndexed(t, writeIndexed(t, 3))
+	v, err := h.Get("product1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := cfghive.HiveSize(sub); size != 9 {
+		t.Fatalf("sub-hive size is %d, expected 9", size)
+	}
+}
+
+func TestIndexedHiveSave(t *testing.T) {
+	h, _ := openIndexed(t, writeIndexed(t, 5, cfghive.WithCompression(cfghive.CodecSnappy, 0)))
+	err := h.SetString("product1/license/company", "Globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NewSub("product1/extra")
+	err = h.SetBool("product1/extra/beta", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Delete("product2")
+
+	var buf bytes.Buffer
+	h.Writer = &buf
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("hive with changes was not committed")
+	}
+
+	h2, _ := openIndexed(t, buf.Bytes())
+	if id, _ := h2.Codec(); id != cfghive.CodecSnappy {
+		t.Fatalf("codec %x was not preserved", id)
+	}
+	s, err := h2.GetString("product1/license/company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "Globex" {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	b, err := h2.GetBool("product1/extra/beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b {
+		t.Fatal("product1/extra/beta is false")
+	}
+	_, err = h2.Get("product2")
+	if err == nil {
+		t.Fatal("deleted key still exists")
+	}
+	if size := cfghive.HiveSize(*h2.GetData()); size != 4*9+1 {
+		t.Fatalf("hive size is %d, expected %d", size, 4*9+1)
+	}
+}
+
+func TestIndexedHiveOutOfBounds(t *testing.T) {
+	data := writeIndexed(t, 1)
+	// The root index reference, whose end overflows.
+	trailer := data[len(data)-16:]
+	binary.BigEndian.PutUint64(trailer[0:8], 16)
+	binary.BigEndian.PutUint64(trailer[8:16], math.MaxUint64-8)
+	h := cfghive.NewIndexedHive()
+	h.Reader = bytes.NewReader(data)
+	h.Size = int64(len(data))
+	if err := h.Load(); err == nil {
+		t.Fatal("no error loading an out of bounds root index")
+	}
+}
+
+func BenchmarkIndexedHiveGet(b *testing.B) {
+	data := writeIndexed(b, 1000, cfghive.WithCompression(cfghive.CodecZstd, 6))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h, _ := openIndexed(b, data)
+		_, err := h.Get("product500/productParams/skuNum")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
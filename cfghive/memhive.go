@@ -2,7 +2,6 @@ package cfghive
 
 import (
 	"errors"
-	"fmt"
 )
 
 // MemHive A hive that is memory resident.
@@ -14,8 +13,15 @@ type MemHive struct {
 }
 
 func (h *MemHive) GetString(key string) (*string, error) {
-	//TODO implement me
-	panic("implement me")
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
 }
 
 // NewMemHive Creates a new file hive.
@@ -39,28 +45,28 @@ func (h *MemHive) Load() error {
 func (h *MemHive) Get(key string) (*HiveValue, error) {
 	path := pathToKeys(key)
 	if len(path) == 0 {
-		return nil, errors.New("a key must have at least one path element")
+		return nil, errEmptyKey
 	}
 	search := h.data
 	for i, pf := range path {
 		if i == len(path)-1 {
 			val, ok := search[pf]
 			if !ok {
-				return nil, fmt.Errorf("key %s does not exist", key)
+				return nil, errKeyNotFound(key)
 			}
 			return &val, nil
 		}
 		next, ok := search[pf]
 		if !ok {
-			return nil, fmt.Errorf("key %s does not exist", key)
+			return nil, errKeyNotFound(key)
 		}
 		if next.IsStoredType(HiveTypeSub) {
 			search, _ = next.Sub()
 		} else {
-			return nil, fmt.Errorf("%s is not at the path leaf, and is not a subhive", pf)
+			return nil, errNotSubHive(pf)
 		}
 	}
-	return nil, fmt.Errorf("key %s does not exist", key)
+	return nil, errKeyNotFound(key)
 }
 
 func (h *MemHive) GetBool(key string) (bool, error) {
@@ -102,7 +108,7 @@ func (h *MemHive) GetFloat(key string) (float64, error) {
 func (h *MemHive) Set(key string, value interface{}) error {
 	path := pathToKeys(key)
 	if len(path) == 0 {
-		return errors.New("a key must have at least one path element")
+		return errEmptyKey
 	}
 	search := h.data
 	for i, pf := range path {
@@ -116,11 +122,11 @@ func (h *MemHive) Set(key string, value interface{}) error {
 		}
 		next, ok := search[pf]
 		if !ok {
-			return fmt.Errorf("key %s does not exist", key)
+			return errKeyNotFound(key)
 		}
 		sub, err := next.Sub()
 		if err != nil {
-			return fmt.Errorf("%s is not at the path leaf, and is not a subhive", pf)
+			return errNotSubHive(pf)
 		}
 		search = sub
 	}
@@ -160,6 +166,10 @@ func (h *MemHive) SetString(key string, value string) error {
 	return nil
 }
 
+func (h *MemHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	return compareAndSet(h.Get, h.Set, key, old, value)
+}
+
 func (h *MemHive) Delete(key string) {
 	path := pathToKeys(key)
 	if len(path) == 0 {
@@ -185,8 +195,7 @@ func (h *MemHive) Delete(key string) {
 }
 
 func (h *MemHive) NewSub(key string) {
-	v, _ := NewHiveValue(make(map[string]HiveValue))
-	h.Set(key, v)
+	h.Set(key, make(map[string]HiveValue))
 }
 
 func (h *MemHive) Rollback() (bool, error) {
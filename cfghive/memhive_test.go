@@ -72,7 +72,7 @@ func TestGet(t *testing.T) {
 	if v == nil {
 		t.Fatal("v is nil")
 	}
-	if v.(string) != "bar" {
+	if s, _ := v.String(); s != "bar" {
 		t.Fatal("v is not bar")
 	}
 	h.NewSub("fez")
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package cfghive
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile Reads the file into memory on platforms without mmap support.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(file, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
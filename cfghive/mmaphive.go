@@ -0,0 +1,423 @@
+package cfghive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// Layout of a compiled hive image, all integers little endian:
+//
+//	header | magic "CHMM", version, 3 reserved bytes, entry count, table offset, data offset
+//	table  | one fixed size entry per key, sorted by full path
+//	data   | key bytes and value bytes, referenced by offset from the table
+//
+// Sub-hives have an entry of their own with no value, their children are the
+// entries whose path starts with the path of the sub-hive and a slash.
+const (
+	mmapHiveVersion    = 1
+	mmapHiveHeaderSize = 32
+	mmapHiveEntrySize  = 32
+)
+
+var mmapHiveMagic = []byte("CHMM")
+
+// IsMmapHive Reports whether the header belongs to a compiled hive image.
+func IsMmapHive(header []byte) bool {
+	return bytes.HasPrefix(header, mmapHiveMagic)
+}
+
+// MmapHive is a read-only hive over a memory mapped image written by WriteMmapHive.
+// String and bytes values returned by the hive point into the mapping,
+// they must not be modified or used after Close.
+type MmapHive struct {
+	path   string
+	file   *os.File
+	data   []byte
+	count  uint64
+	table  uint64
+	values uint64
+}
+
+// NewMmapHive Creates a read-only hive over the image at path.
+// The image is mapped by Load.
+func NewMmapHive(path string) *MmapHive {
+	return &MmapHive{path: path}
+}
+
+func (h *MmapHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{false, true, true}
+}
+
+// Load Maps the image into memory.
+func (h *MmapHive) Load() error {
+	if h.data != nil {
+		return errors.New("hive is already loaded")
+	}
+	file, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() < mmapHiveHeaderSize {
+		file.Close()
+		return fmt.Errorf("invalid hive image size: %d", info.Size())
+	}
+	data, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if !IsMmapHive(data) {
+		unmapFile(data)
+		file.Close()
+		return fmt.Errorf("invalid hive image magic: %x", data[:4])
+	}
+	if data[4] != mmapHiveVersion {
+		unmapFile(data)
+		file.Close()
+		return fmt.Errorf("unsupported hive image version: %d", data[4])
+	}
+	h.count = binary.LittleEndian.Uint64(data[8:16])
+	h.table = binary.LittleEndian.Uint64(data[16:24])
+	h.values = binary.LittleEndian.Uint64(data[24:32])
+	size := uint64(len(data))
+	if h.table > size || h.count > (size-h.table)/mmapHiveEntrySize || h.values > size {
+		unmapFile(data)
+		file.Close()
+		return errors.New("hive image is truncated")
+	}
+	h.data = data
+	err = h.checkEntries()
+	if err != nil {
+		h.data = nil
+		unmapFile(data)
+		file.Close()
+		return err
+	}
+	h.file = file
+	return nil
+}
+
+// Close Unmaps the image.
+func (h *MmapHive) Close() error {
+	if h.data == nil {
+		return nil
+	}
+	err := unmapFile(h.data)
+	h.data = nil
+	if cerr := h.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type mmapEntry struct {
+	keyOff uint64
+	valOff uint64
+	keyLen uint32
+	valLen uint32
+	vtype  byte
+}
+
+func (h *MmapHive) entry(i int) mmapEntry {
+	e := h.data[h.table+uint64(i)*mmapHiveEntrySize:]
+	return mmapEntry{
+		keyOff: binary.LittleEndian.Uint64(e[0:8]),
+		valOff: binary.LittleEndian.Uint64(e[8:16]),
+		keyLen: binary.LittleEndian.Uint32(e[16:20]),
+		valLen: binary.LittleEndian.Uint32(e[20:24]),
+		vtype:  e[24],
+	}
+}
+
+// checkEntries Checks that the keys and values of every entry are within the data section,
+// so a corrupt image is not read out of the mapping.
+func (h *MmapHive) checkEntries() error {
+	size := uint64(len(h.data)) - h.values
+	for i := 0; uint64(i) < h.count; i++ {
+		e := h.entry(i)
+		if e.keyOff > size || uint64(e.keyLen) > size-e.keyOff || e.valOff > size || uint64(e.valLen) > size-e.valOff {
+			return fmt.Errorf("hive image entry %d is out of bounds", i)
+		}
+	}
+	return nil
+}
+
+// bytesAt Gets a slice of the data section without copying it.
+func (h *MmapHive) bytesAt(off uint64, n uint32) []byte {
+	start := h.values + off
+	return h.data[start : start+uint64(n) : start+uint64(n)]
+}
+
+func (h *MmapHive) stringAt(off uint64, n uint32) string {
+	if n == 0 {
+		return ""
+	}
+	return unsafe.String(&h.bytesAt(off, n)[0], n)
+}
+
+func (h *MmapHive) key(i int) string {
+	e := h.entry(i)
+	return h.stringAt(e.keyOff, e.keyLen)
+}
+
+// search Gets the index of the first entry not less than key.
+func (h *MmapHive) search(key string) int {
+	return sort.Search(int(h.count), func(i int) bool {
+		return h.key(i) >= key
+	})
+}
+
+func (h *MmapHive) value(e mmapEntry) (HiveValue, error) {
+	if e.vtype == HiveTypeString {
+		return NewHiveValue(h.stringAt(e.valOff, e.valLen))
+	}
+	return decodeValue(e.vtype, h.bytesAt(e.valOff, e.valLen))
+}
+
+// detachValue Copies a string or bytes value out of the mapping.
+func detachValue(v HiveValue) HiveValue {
+	switch v.Type() {
+	case HiveTypeString:
+		s, _ := v.String()
+		v, _ = NewHiveValue(strings.Clone(s))
+	case HiveTypeBytes:
+		b, _ := v.Bytes()
+		v, _ = NewHiveValue(bytes.Clone(b))
+	}
+	return v
+}
+
+// sub Builds the sub map of the direct children of prefix.
+// If detach is set, values are copied out of the mapping.
+func (h *MmapHive) sub(prefix string, detach bool) (map[string]HiveValue, error) {
+	data := make(map[string]HiveValue)
+	for i := h.search(prefix); i < int(h.count); i++ {
+		k := h.key(i)
+		if !strings.HasPrefix(k, prefix) {
+			break
+		}
+		name := k[len(prefix):]
+		if strings.Contains(name, "/") {
+			continue
+		}
+		// Map keys outlive the lookup, only values point into the mapping.
+		name = strings.Clone(name)
+		e := h.entry(i)
+		if e.vtype == HiveTypeSub {
+			sub, err := h.sub(k+"/", detach)
+			if err != nil {
+				return nil, err
+			}
+			data[name], _ = NewHiveValue(sub)
+			continue
+		}
+		v, err := h.value(e)
+		if err != nil {
+			return nil, err
+		}
+		if detach {
+			v = detachValue(v)
+		}
+		data[name] = v
+	}
+	return data, nil
+}
+
+func (h *MmapHive) Get(key string) (*HiveValue, error) {
+	if h.data == nil {
+		return nil, errors.New("hive is not loaded")
+	}
+	path := pathToKeys(key)
+	if len(path) == 0 {
+		return nil, errEmptyKey
+	}
+	key = strings.Join(path, "/")
+	i := h.search(key)
+	if i >= int(h.count) || h.key(i) != key {
+		// Report a leaf in the middle of the path like MemHive does.
+		for j := 1; j < len(path); j++ {
+			parent := strings.Join(path[:j], "/")
+			p := h.search(parent)
+			if p < int(h.count) && h.key(p) == parent && h.entry(p).vtype != HiveTypeSub {
+				return nil, errNotSubHive(path[j-1])
+			}
+		}
+		return nil, errKeyNotFound(key)
+	}
+	e := h.entry(i)
+	if e.vtype == HiveTypeSub {
+		sub, err := h.sub(key+"/", false)
+		if err != nil {
+			return nil, err
+		}
+		v, _ := NewHiveValue(sub)
+		return &v, nil
+	}
+	v, err := h.value(e)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (h *MmapHive) GetBool(key string) (bool, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+func (h *MmapHive) GetInt(key string) (int, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int()
+}
+
+func (h *MmapHive) GetFloat(key string) (float64, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (h *MmapHive) GetString(key string) (*string, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (h *MmapHive) Set(key string, value interface{}) error {
+	return ErrReadOnly
+}
+
+func (h *MmapHive) SetBool(key string, value bool) error {
+	return ErrReadOnly
+}
+
+func (h *MmapHive) SetInt(key string, value int) error {
+	return ErrReadOnly
+}
+
+func (h *MmapHive) SetFloat(key string, value float64) error {
+	return ErrReadOnly
+}
+
+func (h *MmapHive) SetString(key string, value string) error {
+	return ErrReadOnly
+}
+
+// CompareAndSet Fails with ErrReadOnly, the hive is read-only.
+func (h *MmapHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Delete Does nothing, the hive is read-only.
+func (h *MmapHive) Delete(key string) {
+}
+
+// NewSub Does nothing, the hive is read-only.
+func (h *MmapHive) NewSub(key string) {
+}
+
+func (h *MmapHive) Rollback() (bool, error) {
+	return false, nil
+}
+
+func (h *MmapHive) Commit() (bool, error) {
+	return false, nil
+}
+
+func (h *MmapHive) Save() error {
+	return ErrReadOnly
+}
+
+// GetData Copies the whole hive into a sub map.
+// Unlike Get, the returned values remain valid after Close.
+func (h *MmapHive) GetData() *map[string]HiveValue {
+	data := make(map[string]HiveValue)
+	if h.data != nil {
+		data, _ = h.sub("", true)
+	}
+	return &data
+}
+
+type mmapFlatEntry struct {
+	key   string
+	vtype byte
+	value []byte
+}
+
+func flattenHive(data map[string]HiveValue, prefix string, entries []mmapFlatEntry) []mmapFlatEntry {
+	for k, v := range data {
+		e := mmapFlatEntry{key: prefix + k, vtype: v.Type(), value: encodeValue(v)}
+		if v.IsStoredType(HiveTypeSub) {
+			sub, _ := v.Sub()
+			entries = flattenHive(sub, e.key+"/", entries)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// WriteMmapHive Writes data to w as a compiled hive image, to be opened with NewMmapHive.
+func WriteMmapHive(w io.Writer, data map[string]HiveValue) error {
+	entries := flattenHive(data, "", nil)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	header := append([]byte{}, mmapHiveMagic...)
+	header = append(header, mmapHiveVersion, 0, 0, 0)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(entries)))
+	header = binary.LittleEndian.AppendUint64(header, mmapHiveHeaderSize)
+	header = binary.LittleEndian.AppendUint64(header, mmapHiveHeaderSize+uint64(len(entries))*mmapHiveEntrySize)
+
+	table := make([]byte, 0, len(entries)*mmapHiveEntrySize)
+	var values []byte
+	for _, e := range entries {
+		if len(e.key) > math.MaxUint32 || len(e.value) > math.MaxUint32 {
+			return fmt.Errorf("key %s is too large for a hive image", e.key)
+		}
+		keyOff := uint64(len(values))
+		values = append(values, e.key...)
+		valOff := uint64(len(values))
+		values = append(values, e.value...)
+
+		table = binary.LittleEndian.AppendUint64(table, keyOff)
+		table = binary.LittleEndian.AppendUint64(table, valOff)
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(e.key)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(e.value)))
+		table = append(table, e.vtype, 0, 0, 0, 0, 0, 0, 0)
+	}
+
+	for _, b := range [][]byte{header, table, values} {
+		_, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cfghive_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func compileHive(t testing.TB, data map[string]cfghive.HiveValue) *cfghive.MmapHive {
+	path := filepath.Join(t.TempDir(), "hive.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfghive.WriteMmapHive(file, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := cfghive.NewMmapHive(path)
+	err = h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.Close()
+	})
+	return h
+}
+
+func TestMmapHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewMmapHive("")
+}
+
+func TestMmapHiveGet(t *testing.T) {
+	m, _ := cfghive.NewMemHive()
+	m.NewSub("a")
+	m.NewSub("a/x")
+	values := map[string]interface{}{
+		"a/bool":   true,
+		"a/byte":   byte(7),
+		"a/int64":  int64(-64),
+		"a/uint64": uint64(64),
+		"a/float":  1.5,
+		"a/int":    -1,
+		"a/uint":   uint(1),
+		"a/f32":    float32(0.25),
+		"a/str":    "hello",
+		"a/bytes":  []byte{1, 2, 3},
+		"a/x/y":    "deep",
+		"a-b":      "sibling",
+		"a0":       "after",
+	}
+	for k, v := range values {
+		err := m.Set(k, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := compileHive(t, *m.GetData())
+
+	for k, want := range values {
+		v, err := h.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, _ := cfghive.NewHiveValue(want)
+		if v.Type() != w.Type() {
+			t.Fatalf("%s has type %s, expected %s", k, v.TypeString(), w.TypeString())
+		}
+		if w.Type() != cfghive.HiveTypeBytes && v.Value() != want {
+			t.Fatalf("%s is %v, expected %v", k, v.Value(), want)
+		}
+	}
+	b, _ := h.Get("a/bytes")
+	if bs, _ := b.Bytes(); string(bs) != "\x01\x02\x03" {
+		t.Fatalf("a/bytes is %v", bs)
+	}
+
+	v, err := h.Get("a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub) != 11 {
+		t.Fatalf("a has %d children, expected 11", len(sub))
+	}
+	if cfghive.HiveSize(*h.GetData()) != cfghive.HiveSize(*m.GetData()) {
+		t.Fatal("compiled hive size differs from the source hive")
+	}
+
+	_, err = h.Get("a/str/z")
+	if err == nil {
+		t.Fatal("No error when getting a value below a leaf")
+	}
+	_, err = h.Get("b")
+	if err == nil {
+		t.Fatal("No error when getting a non-existent key")
+	}
+	_, err = h.Get("")
+	if err == nil {
+		t.Fatal("No error with empty key")
+	}
+}
+
+func TestMmapHiveReadOnly(t *testing.T) {
+	h := compileHive(t, *newProductHive(t, 2).GetData())
+	if err := h.Set("product0/license/company", "Globex"); err == nil {
+		t.Fatal("No error when setting a value in a read-only hive")
+	}
+	if _, err := h.CompareAndSet("product0/license/company", nil, "Globex"); !errors.Is(err, cfghive.ErrReadOnly) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	h.Delete("product0")
+	s, err := h.GetString("product0/license/company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "Acme Inc." {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	if err := h.Save(); err == nil {
+		t.Fatal("No error when saving a read-only hive")
+	}
+}
+
+func TestMmapHiveCorrupt(t *testing.T) {
+	var image bytes.Buffer
+	if err := cfghive.WriteMmapHive(&image, *newProductHive(t, 1).GetData()); err != nil {
+		t.Fatal(err)
+	}
+	table := binary.LittleEndian.Uint64(image.Bytes()[16:24])
+	corrupt := map[string]func([]byte) []byte{
+		"truncated data": func(b []byte) []byte { return b[:len(b)-1] },
+		"entry count": func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[8:16], math.MaxUint64/16)
+			return b
+		},
+		"key offset": func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[table:table+8], math.MaxUint64)
+			return b
+		},
+		"value length": func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[table+20:table+24], math.MaxUint32)
+			return b
+		},
+	}
+	for name, f := range corrupt {
+		path := filepath.Join(t.TempDir(), "hive.img")
+		if err := os.WriteFile(path, f(bytes.Clone(image.Bytes())), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		h := cfghive.NewMmapHive(path)
+		if err := h.Load(); err == nil {
+			h.Close()
+			t.Fatalf("%s: no error loading a corrupt image", name)
+		}
+	}
+}
+
+func BenchmarkMmapHiveGet(b *testing.B) {
+	h := compileHive(b, *newProductHive(b, 1000).GetData())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := h.Get("product500/productParams/skuNum")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
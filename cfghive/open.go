@@ -0,0 +1,69 @@
+package cfghive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hiveBackends Creates a hive of each backend supported by OpenHive, from its location.
+var hiveBackends = map[string]func(location string) Hive{
+	"mem": func(string) Hive {
+		h, _ := NewMemHive()
+		return h
+	},
+	"bin": func(location string) Hive {
+		h := NewBinHive()
+		h.Path = location
+		return h
+	},
+	"mmap": func(location string) Hive {
+		return NewMmapHive(location)
+	},
+	"sqlite": func(location string) Hive {
+		return NewSQLiteHive(location)
+	},
+	"bolt": func(location string) Hive {
+		return NewBoltHive(location)
+	},
+	"dir": func(location string) Hive {
+		return NewDirHive(location)
+	},
+	"remote": func(location string) Hive {
+		return NewRemoteHive(location)
+	},
+}
+
+// HiveBackends Gets the names of the backends supported by OpenHive.
+func HiveBackends() []string {
+	names := make([]string, 0, len(hiveBackends))
+	for name := range hiveBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenHive Creates and loads a hive from a "backend:location" spec,
+// e.g. "bolt:/var/lib/hive.db", "dir:./config" or "remote:http://localhost:8080".
+// The mem backend takes no location.
+// Hives holding resources, such as BoltHive, implement io.Closer.
+func OpenHive(spec string) (Hive, error) {
+	backend, location, _ := strings.Cut(spec, ":")
+	create, ok := hiveBackends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown hive backend %q, expected one of %s", backend, strings.Join(HiveBackends(), ", "))
+	}
+	if location == "" && backend != "mem" {
+		return nil, fmt.Errorf("the %s hive backend needs a location", backend)
+	}
+	h := create(location)
+	if backend == "mem" {
+		return h, nil
+	}
+	err := h.Load()
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,162 @@
+package cfghive_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func TestOpenHive(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{
+		"mem:",
+		"bin:" + filepath.Join(dir, "hive.bin"),
+		"sqlite:" + filepath.Join(dir, "hive.sqlite"),
+		"bolt:" + filepath.Join(dir, "hive.db"),
+		"dir:" + filepath.Join(dir, "hive"),
+	} {
+		t.Run(spec, func(t *testing.T) {
+			h, err := cfghive.OpenHive(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := h.(interface{ Close() error }); ok {
+				defer c.Close()
+			}
+			h.NewSub("a")
+			err = h.SetInt("a/n", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := h.GetInt("a/n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("n is %d, expected 1", n)
+			}
+		})
+	}
+
+	if _, err := cfghive.OpenHive("floppy:/dev/fd0"); err == nil {
+		t.Fatal("No error for an unknown backend")
+	}
+	if _, err := cfghive.OpenHive("bolt:"); err == nil {
+		t.Fatal("No error for a missing location")
+	}
+}
+
+func TestHiveErrors(t *testing.T) {
+	h, _ := cfghive.NewMemHive()
+	if err := h.SetString("v", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get(""); !errors.Is(err, cfghive.ErrInvalidKey) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := h.Get("missing/x"); !errors.Is(err, cfghive.ErrKeyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := h.Set("v/x", 1); !errors.Is(err, cfghive.ErrNotSubHive) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := h.Set("c", make(chan int)); !errors.Is(err, cfghive.ErrInvalidType) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSyncHive(t *testing.T) {
+	mem, _ := cfghive.NewMemHive()
+	h := cfghive.NewSyncHive(mem)
+	if !h.Characteristics().IsThreadSafe {
+		t.Fatal("SyncHive is not thread-safe")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.Do(func(hive cfghive.Hive) error {
+					n, _ := hive.GetInt("n")
+					return hive.SetInt("n", n+1)
+				})
+				h.GetData()
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := h.GetInt("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 800 {
+		t.Fatalf("n is %d, expected 800", n)
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{
+		"mem:",
+		"bin:" + filepath.Join(dir, "hive.bin"),
+		"sqlite:" + filepath.Join(dir, "hive.sqlite"),
+		"bolt:" + filepath.Join(dir, "hive.db"),
+		"dir:" + filepath.Join(dir, "hive"),
+	} {
+		t.Run(spec, func(t *testing.T) {
+			h, err := cfghive.OpenHive(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := h.(interface{ Close() error }); ok {
+				defer c.Close()
+			}
+			swapped, err := h.CompareAndSet("a", nil, map[string]interface{}{"n": 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !swapped {
+				t.Fatal("missing key was not set")
+			}
+			swapped, err = h.CompareAndSet("a", nil, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swapped {
+				t.Fatal("existing key was set, expected to be missing")
+			}
+
+			sub, _ := cfghive.NewHiveValue(map[string]interface{}{"n": 1})
+			other, _ := cfghive.NewHiveValue(map[string]interface{}{"n": 2})
+			swapped, err = h.CompareAndSet("a", &other, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swapped {
+				t.Fatal("key was set although the value did not match")
+			}
+			swapped, err = h.CompareAndSet("a", &sub, "replaced")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !swapped {
+				t.Fatal("key was not set although the value matched")
+			}
+			s, err := h.GetString("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *s != "replaced" {
+				t.Fatalf("unexpected value %s", *s)
+			}
+
+			if _, err := h.CompareAndSet("missing/x", nil, 1); !errors.Is(err, cfghive.ErrKeyNotFound) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package cfghive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteHive is a hive stored on an api server, accessed over HTTP.
+//
+// Values are read from and written to BaseURL + "/hive/<key>" as JSON typed values,
+// see HiveValue.MarshalJSON. Commit, Rollback and Save are forwarded to
+// BaseURL + "/commit", "/rollback" and "/save".
+// Values read are cached, and revalidated with their ETag once older than CacheTTL.
+type RemoteHive struct {
+	// The server URL, e.g. "http://localhost:8080".
+	BaseURL string
+	// The HTTP client, http.DefaultClient if nil.
+	Client *http.Client
+	// Credentials sent with every request, if Username is set.
+	Username string
+	Password string
+	// Headers sent with every request, e.g. an Authorization header.
+	Header http.Header
+	// How long cached values are used without revalidation.
+	CacheTTL time.Duration
+	// How many times failed requests are retried, and the delay before the first retry.
+	// The delay doubles for every retry.
+	Retries    int
+	RetryDelay time.Duration
+
+	lock  sync.Mutex
+	cache map[string]remoteCacheEntry
+}
+
+type remoteCacheEntry struct {
+	value   HiveValue
+	etag    string
+	fetched time.Time
+}
+
+// RemoteError An error response from the server.
+type RemoteError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote hive: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap Gets the hive error matching the status code, so errors.Is works as for local hives.
+func (e *RemoteError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrInvalidKey
+	case http.StatusNotFound:
+		return ErrKeyNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrReadOnly
+	case http.StatusUnprocessableEntity:
+		return ErrInvalidType
+	}
+	return nil
+}
+
+// NewRemoteHive Creates a hive stored on the api server at baseURL.
+func NewRemoteHive(baseURL string) *RemoteHive {
+	return &RemoteHive{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Header:     make(http.Header),
+		Retries:    3,
+		RetryDelay: 100 * time.Millisecond,
+		cache:      make(map[string]remoteCacheEntry),
+	}
+}
+
+func (h *RemoteHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{true, true, true}
+}
+
+// Load Drops the cache, so every value is fetched again.
+func (h *RemoteHive) Load() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.cache = make(map[string]remoteCacheEntry)
+	return nil
+}
+
+// Ping Checks the server answers on BaseURL + "/ping", without retrying.
+func (h *RemoteHive) Ping() error {
+	resp, err := h.do(http.MethodGet, h.BaseURL+"/ping", nil, nil, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (h *RemoteHive) hiveURL(key string) string {
+	path := pathToKeys(key)
+	for i, pf := range path {
+		path[i] = url.PathEscape(pf)
+	}
+	return h.BaseURL + "/hive/" + strings.Join(path, "/")
+}
+
+// retryable Reports whether a response status is worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// do Sends a request, retrying on network errors and server errors if retry is set.
+func (h *RemoteHive) do(method string, u string, body []byte, header http.Header, retry bool) (*http.Response, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	delay := h.RetryDelay
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u, r)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range h.Header {
+			req.Header[k] = v
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if h.Username != "" {
+			req.SetBasicAuth(h.Username, h.Password)
+		}
+		resp, err := client.Do(req)
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if !retry || attempt >= h.Retries {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// responseError Reads the error of a failed response.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil || body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	return &RemoteError{StatusCode: resp.StatusCode, Message: body.Error}
+}
+
+// fetch Gets a value, revalidating the cached value if it is too old.
+func (h *RemoteHive) fetch(key string) (*HiveValue, error) {
+	u := h.hiveURL(key)
+	h.lock.Lock()
+	cached, ok := h.cache[u]
+	h.lock.Unlock()
+	if ok && time.Since(cached.fetched) < h.CacheTTL {
+		return &cached.value, nil
+	}
+
+	header := make(http.Header)
+	if ok {
+		header.Set("If-None-Match", cached.etag)
+	}
+	resp, err := h.do(http.MethodGet, u, nil, header, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if ok && resp.StatusCode == http.StatusNotModified {
+		cached.fetched = time.Now()
+		h.lock.Lock()
+		h.cache[u] = cached
+		h.lock.Unlock()
+		return &cached.value, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		h.invalidate(key)
+		return nil, responseError(resp)
+	}
+	var v HiveValue
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		h.lock.Lock()
+		h.cache[u] = remoteCacheEntry{value: v, etag: etag, fetched: time.Now()}
+		h.lock.Unlock()
+	}
+	return &v, nil
+}
+
+// invalidate Drops the cached values of key, its parents and its children.
+func (h *RemoteHive) invalidate(key string) {
+	u := h.hiveURL(key)
+	base := h.BaseURL + "/hive/"
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for k := range h.cache {
+		if k == u || k == base || strings.HasPrefix(u, k+"/") || strings.HasPrefix(k, u+"/") {
+			delete(h.cache, k)
+		}
+	}
+}
+
+func (h *RemoteHive) Get(key string) (*HiveValue, error) {
+	if len(pathToKeys(key)) == 0 {
+		return nil, errEmptyKey
+	}
+	return h.fetch(key)
+}
+
+func (h *RemoteHive) GetBool(key string) (bool, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+func (h *RemoteHive) GetInt(key string) (int, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int()
+}
+
+func (h *RemoteHive) GetFloat(key string) (float64, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (h *RemoteHive) GetString(key string) (*string, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// send Sends a change and drops the cached values it affects.
+func (h *RemoteHive) send(method string, key string, body []byte) error {
+	if len(pathToKeys(key)) == 0 {
+		return errEmptyKey
+	}
+	defer h.invalidate(key)
+	resp, err := h.do(method, h.hiveURL(key), body, nil, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (h *RemoteHive) Set(key string, value interface{}) error {
+	v, err := NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.send(http.MethodPut, key, body)
+}
+
+func (h *RemoteHive) SetBool(key string, value bool) error {
+	return h.Set(key, value)
+}
+
+func (h *RemoteHive) SetInt(key string, value int) error {
+	return h.Set(key, value)
+}
+
+func (h *RemoteHive) SetFloat(key string, value float64) error {
+	return h.Set(key, value)
+}
+
+func (h *RemoteHive) SetString(key string, value string) error {
+	return h.Set(key, value)
+}
+
+// current Gets the value of key on the server, nil if it does not exist, and its ETag.
+func (h *RemoteHive) current(key string) (*HiveValue, string, error) {
+	resp, err := h.do(http.MethodGet, h.hiveURL(key), nil, nil, true)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp)
+	}
+	var v HiveValue
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	if err != nil {
+		return nil, "", err
+	}
+	return &v, resp.Header.Get("ETag"), nil
+}
+
+// CompareAndSet Compares the current value read from the server, then sets the value
+// conditionally on the ETag read, so the server rejects it if the key changed in between.
+// The conditional request is not retried, as a lost response would be reported as a mismatch.
+func (h *RemoteHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	if len(pathToKeys(key)) == 0 {
+		return false, errEmptyKey
+	}
+	v, err := NewHiveValue(value)
+	if err != nil {
+		return false, err
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	cur, etag, err := h.current(key)
+	if err != nil {
+		return false, err
+	}
+	if !valueMatches(cur, old) {
+		return false, nil
+	}
+	header := make(http.Header)
+	if cur == nil {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+	defer h.invalidate(key)
+	resp, err := h.do(http.MethodPut, h.hiveURL(key), body, header, false)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return false, responseError(resp)
+	}
+	return true, nil
+}
+
+func (h *RemoteHive) Delete(key string) {
+	h.send(http.MethodDelete, key, nil)
+}
+
+func (h *RemoteHive) NewSub(key string) {
+	h.send(http.MethodPost, key, nil)
+}
+
+// txn Forwards a transaction operation, which is not retried.
+func (h *RemoteHive) txn(op string) (bool, error) {
+	h.Load()
+	resp, err := h.do(http.MethodPost, h.BaseURL+"/"+op, nil, nil, false)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+	var body struct {
+		Done bool `json:"done"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return false, err
+	}
+	return body.Done, nil
+}
+
+func (h *RemoteHive) Rollback() (bool, error) {
+	return h.txn("rollback")
+}
+
+func (h *RemoteHive) Commit() (bool, error) {
+	return h.txn("commit")
+}
+
+func (h *RemoteHive) Save() error {
+	_, err := h.txn("save")
+	return err
+}
+
+// GetData Fetches the whole hive.
+// Unlike MemHive, changes to the returned map are not reflected in the hive.
+func (h *RemoteHive) GetData() *map[string]HiveValue {
+	data := make(map[string]HiveValue)
+	v, err := h.fetch("")
+	if err == nil {
+		if sub, err := v.Sub(); err == nil {
+			data = sub
+		}
+	}
+	return &data
+}
@@ -0,0 +1,319 @@
+package cfghive_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+// fakeHiveServer Serves a MemHive with the protocol RemoteHive expects.
+type fakeHiveServer struct {
+	hive     *cfghive.MemHive
+	gets     atomic.Int32
+	notMod   atomic.Int32
+	failures atomic.Int32
+}
+
+func (s *fakeHiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.failures.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Path == "/ping" {
+		json.NewEncoder(w).Encode(map[string]string{"response": "pong"})
+		return
+	}
+	if r.URL.Path == "/commit" {
+		json.NewEncoder(w).Encode(map[string]bool{"done": true})
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/hive/")
+	switch r.Method {
+	case http.MethodGet:
+		s.gets.Add(1)
+		var v *cfghive.HiveValue
+		var err error
+		if key == "" {
+			root, _ := cfghive.NewHiveValue(*s.hive.GetData())
+			v = &root
+		} else {
+			v, err = s.hive.Get(key)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		body, _ := json.Marshal(v)
+		etag := valueETag(body)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			s.notMod.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(body)
+	case http.MethodPut:
+		cur, err := s.hive.Get(key)
+		if match := r.Header.Get("If-Match"); match != "" {
+			body, _ := json.Marshal(cur)
+			if err != nil || match != valueETag(body) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		if r.Header.Get("If-None-Match") == "*" && err == nil {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		var v cfghive.HiveValue
+		err = json.NewDecoder(r.Body).Decode(&v)
+		if err == nil {
+			err = s.hive.Set(key, v.Value())
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		s.hive.Delete(key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.hive.NewSub(key)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func valueETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func newRemoteHive(t *testing.T) (*cfghive.RemoteHive, *fakeHiveServer) {
+	mem, _ := cfghive.NewMemHive()
+	fake := &fakeHiveServer{hive: mem}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	h := cfghive.NewRemoteHive(srv.URL)
+	h.RetryDelay = time.Millisecond
+	return h, fake
+}
+
+func TestRemoteHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewRemoteHive("")
+}
+
+func TestRemoteHiveSetGet(t *testing.T) {
+	h, _ := newRemoteHive(t)
+	h.NewSub("productParams")
+	err := h.Set("productParams/skuNum", uint64(1<<63+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.SetString("productParams/name", "Ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("productParams/name/x", "y")
+	var remoteErr *cfghive.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	v, err := h.Get("productParams/skuNum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := v.Uint64(); u != 1<<63+1 {
+		t.Fatalf("unexpected value %d", u)
+	}
+	s, err := h.GetString("productParams/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "Ubuntu" {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	if size := cfghive.HiveSize(*h.GetData()); size != 2 {
+		t.Fatalf("hive size is %d, expected 2", size)
+	}
+
+	h.Delete("productParams/name")
+	_, err = h.Get("productParams/name")
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("commit was not forwarded")
+	}
+}
+
+func TestRemoteHiveCache(t *testing.T) {
+	h, fake := newRemoteHive(t)
+	err := h.SetInt("n", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := h.GetInt("n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.gets.Load() != 3 || fake.notMod.Load() != 2 {
+		t.Fatalf("%d gets, %d not modified, expected 3 and 2", fake.gets.Load(), fake.notMod.Load())
+	}
+
+	h.CacheTTL = time.Hour
+	for i := 0; i < 3; i++ {
+		if _, err := h.GetInt("n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.gets.Load() != 3 {
+		t.Fatalf("%d gets, expected 3", fake.gets.Load())
+	}
+
+	// A change invalidates the cached value.
+	err = h.SetInt("n", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := h.GetInt("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("n is %d, expected 2", n)
+	}
+}
+
+func TestRemoteHiveCompareAndSet(t *testing.T) {
+	h, _ := newRemoteHive(t)
+	swapped, err := h.CompareAndSet("n", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !swapped {
+		t.Fatal("missing key was not set")
+	}
+	one, _ := cfghive.NewHiveValue(1)
+	two, _ := cfghive.NewHiveValue(2)
+	swapped, err = h.CompareAndSet("n", &two, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swapped {
+		t.Fatal("key was set although the value did not match")
+	}
+	swapped, err = h.CompareAndSet("n", &one, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !swapped {
+		t.Fatal("key was not set although the value matched")
+	}
+	if n, _ := h.GetInt("n"); n != 2 {
+		t.Fatalf("n is %d, expected 2", n)
+	}
+}
+
+func TestRemoteHiveRetries(t *testing.T) {
+	h, fake := newRemoteHive(t)
+	fake.failures.Store(2)
+	err := h.SetBool("b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.failures.Store(int32(h.Retries + 1))
+	_, err = h.Get("b")
+	var remoteErr *cfghive.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRemoteHivePing(t *testing.T) {
+	h, fake := newRemoteHive(t)
+	err := h.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not retried
+	fake.failures.Store(1)
+	err = h.Ping()
+	var remoteErr *cfghive.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	h = cfghive.NewRemoteHive("http://127.0.0.1:1")
+	if h.Ping() == nil {
+		t.Fatal("No error pinging an unreachable server")
+	}
+}
+
+func TestHiveValueJSON(t *testing.T) {
+	in := map[string]interface{}{
+		"bool":    true,
+		"byte":    byte(255),
+		"int64":   int64(-1 << 62),
+		"uint64":  uint64(1<<64 - 1),
+		"float64": 0.1,
+		"int":     -5,
+		"uint":    uint(5),
+		"float32": float32(0.1),
+		"string":  "s",
+		"bytes":   []byte("raw"),
+		"sub":     map[string]interface{}{"x": "y"},
+	}
+	v, err := cfghive.NewHiveValue(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out cfghive.HiveValue
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := out.Sub()
+	for k, want := range in {
+		got := sub[k]
+		w, _ := cfghive.NewHiveValue(want)
+		if got.Type() != w.Type() {
+			t.Fatalf("%s has type %s, expected %s", k, got.TypeString(), w.TypeString())
+		}
+	}
+	u := sub["uint64"]
+	if n, _ := u.Uint64(); n != 1<<64-1 {
+		t.Fatalf("uint64 is %d", n)
+	}
+
+	err = json.Unmarshal([]byte(`{"type":"byte","value":256}`), &out)
+	if err == nil {
+		t.Fatal("No error for an overflowing byte")
+	}
+	err = json.Unmarshal([]byte(`{"type":"list","value":[]}`), &out)
+	if err == nil {
+		t.Fatal("No error for an unknown type")
+	}
+}
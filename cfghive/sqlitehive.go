@@ -0,0 +1,533 @@
+package cfghive
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteHiveSchema = `CREATE TABLE IF NOT EXISTS hive (
+	path  TEXT PRIMARY KEY,
+	type  INTEGER NOT NULL,
+	value BLOB
+) WITHOUT ROWID`
+
+// SQLiteHive is a persistent, transactional hive storing each path as a row in a SQLite database.
+// Changes are made in a SQL transaction that is started by the first change
+// and ended by Commit or Rollback.
+type SQLiteHive struct {
+	path string
+	db   *sql.DB
+	tx   *sql.Tx
+	lock sync.Mutex
+}
+
+// NewSQLiteHive Creates a hive backed by the SQLite database at path.
+// The database is opened, and created if needed, by Load.
+func NewSQLiteHive(path string) *SQLiteHive {
+	return &SQLiteHive{path: path}
+}
+
+func (h *SQLiteHive) Characteristics() HiveCharacteristics {
+	return HiveCharacteristics{true, true, true}
+}
+
+// Load Opens the database and creates the hive table if it does not exist.
+func (h *SQLiteHive) Load() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db != nil {
+		return errors.New("hive is already loaded")
+	}
+	db, err := sql.Open("sqlite", h.path)
+	if err != nil {
+		return err
+	}
+	// A single connection keeps in-memory databases alive and serializes writers.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqliteHiveSchema)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	h.db = db
+	return nil
+}
+
+// Ping Checks the database can be reached.
+func (h *SQLiteHive) Ping() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return errors.New("hive is not loaded")
+	}
+	if h.tx != nil {
+		// The pending transaction holds the only connection
+		return h.tx.QueryRow("SELECT 1").Err()
+	}
+	return h.db.Ping()
+}
+
+// Close Rolls back any pending changes and closes the database.
+func (h *SQLiteHive) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return nil
+	}
+	if h.tx != nil {
+		h.tx.Rollback()
+		h.tx = nil
+	}
+	err := h.db.Close()
+	h.db = nil
+	return err
+}
+
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// q Gets the pending transaction, or the database if there is none.
+func (h *SQLiteHive) q() (sqlQueryer, error) {
+	if h.db == nil {
+		return nil, errors.New("hive is not loaded")
+	}
+	if h.tx != nil {
+		return h.tx, nil
+	}
+	return h.db, nil
+}
+
+// begin Gets the pending transaction, starting one if needed.
+func (h *SQLiteHive) begin() (*sql.Tx, error) {
+	if h.db == nil {
+		return nil, errors.New("hive is not loaded")
+	}
+	if h.tx == nil {
+		tx, err := h.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		h.tx = tx
+	}
+	return h.tx, nil
+}
+
+func encodeSQLValue(v HiveValue) interface{} {
+	switch v.Type() {
+	case HiveTypeBool:
+		if b, _ := v.Bool(); b {
+			return int64(1)
+		}
+		return int64(0)
+	case HiveTypeByte:
+		b, _ := v.Byte()
+		return int64(b)
+	case HiveTypeInt64:
+		i, _ := v.Int64()
+		return i
+	case HiveTypeUint64:
+		// SQLite integers are signed, keep the bits.
+		i, _ := v.Uint64()
+		return int64(i)
+	case HiveTypeFloat64:
+		f, _ := v.Float64()
+		return f
+	case HiveTypeInt:
+		i, _ := v.Int()
+		return int64(i)
+	case HiveTypeUint:
+		i, _ := v.Uint()
+		return int64(i)
+	case HiveTypeFloat32:
+		f, _ := v.Float32()
+		return float64(f)
+	case HiveTypeString:
+		s, _ := v.String()
+		return s
+	case HiveTypeBytes:
+		b, _ := v.Bytes()
+		return b
+	}
+	return nil
+}
+
+func decodeSQLValue(t byte, raw interface{}) (HiveValue, error) {
+	if t == HiveTypeSub {
+		return NewHiveValue(make(map[string]HiveValue))
+	}
+	var i int64
+	var f float64
+	switch r := raw.(type) {
+	case int64:
+		i = r
+		f = float64(r)
+	case float64:
+		f = r
+		i = int64(r)
+	}
+	switch t {
+	case HiveTypeBool:
+		return NewHiveValue(i != 0)
+	case HiveTypeByte:
+		return NewHiveValue(byte(i))
+	case HiveTypeInt64:
+		return NewHiveValue(i)
+	case HiveTypeUint64:
+		return NewHiveValue(uint64(i))
+	case HiveTypeFloat64:
+		return NewHiveValue(f)
+	case HiveTypeInt:
+		return NewHiveValue(int(i))
+	case HiveTypeUint:
+		return NewHiveValue(uint(i))
+	case HiveTypeFloat32:
+		if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return HiveValue{}, fmt.Errorf("value %f overflows float32", f)
+		}
+		return NewHiveValue(float32(f))
+	case HiveTypeString:
+		switch r := raw.(type) {
+		case string:
+			return NewHiveValue(r)
+		case []byte:
+			return NewHiveValue(string(r))
+		}
+	case HiveTypeBytes:
+		switch r := raw.(type) {
+		case []byte:
+			return NewHiveValue(r)
+		case string:
+			return NewHiveValue([]byte(r))
+		case nil:
+			return NewHiveValue([]byte{})
+		}
+	}
+	return HiveValue{}, fmt.Errorf("invalid stored type %d for value %T", t, raw)
+}
+
+// sqlTypeOf Gets the stored type of the row at key.
+func sqlTypeOf(q sqlQueryer, key string) (byte, bool, error) {
+	var t byte
+	err := q.QueryRow(`SELECT type FROM hive WHERE path = ?`, key).Scan(&t)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return t, true, nil
+}
+
+// sqlCheckParent Ensures the parent of a key exists and is a sub-hive.
+func sqlCheckParent(q sqlQueryer, key string, path []string) error {
+	if len(path) < 2 {
+		return nil
+	}
+	for i := len(path) - 1; i > 0; i-- {
+		parent := strings.Join(path[:i], "/")
+		t, ok, err := sqlTypeOf(q, parent)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if t != HiveTypeSub {
+			return errNotSubHive(path[i-1])
+		}
+		if i != len(path)-1 {
+			return errKeyNotFound(key)
+		}
+		return nil
+	}
+	return errKeyNotFound(key)
+}
+
+// sqlReadSub Reads every row below key into a sub map.
+func sqlReadSub(q sqlQueryer, key string) (map[string]HiveValue, error) {
+	var rows *sql.Rows
+	var err error
+	prefix := ""
+	if key == "" {
+		rows, err = q.Query(`SELECT path, type, value FROM hive ORDER BY path`)
+	} else {
+		var end string
+		prefix, end = prefixRange(key)
+		rows, err = q.Query(`SELECT path, type, value FROM hive WHERE path >= ? AND path < ? ORDER BY path`, prefix, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := make(map[string]HiveValue)
+	for rows.Next() {
+		var p string
+		var t byte
+		var raw interface{}
+		err = rows.Scan(&p, &t, &raw)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeSQLValue(t, raw)
+		if err != nil {
+			return nil, err
+		}
+		// Parents sort before their children, so the sub map of the parent already exists.
+		elems := strings.Split(p[len(prefix):], "/")
+		parent := data
+		for _, e := range elems[:len(elems)-1] {
+			next, ok := parent[e]
+			if !ok {
+				return nil, fmt.Errorf("orphaned row %s", p)
+			}
+			parent, err = next.Sub()
+			if err != nil {
+				return nil, fmt.Errorf("orphaned row %s", p)
+			}
+		}
+		parent[elems[len(elems)-1]] = v
+	}
+	return data, rows.Err()
+}
+
+func sqlDeleteTree(q sqlQueryer, key string) error {
+	start, end := prefixRange(key)
+	_, err := q.Exec(`DELETE FROM hive WHERE path = ? OR (path >= ? AND path < ?)`, key, start, end)
+	return err
+}
+
+func sqlInsertTree(q sqlQueryer, key string, v HiveValue) error {
+	_, err := q.Exec(`INSERT INTO hive (path, type, value) VALUES (?, ?, ?)`, key, v.Type(), encodeSQLValue(v))
+	if err != nil {
+		return err
+	}
+	if !v.IsStoredType(HiveTypeSub) {
+		return nil
+	}
+	sub, _ := v.Sub()
+	for k, sv := range sub {
+		err = sqlInsertTree(q, key+"/"+k, sv)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *SQLiteHive) Get(key string) (*HiveValue, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.get(key)
+}
+
+// get Gets a value, with the lock held.
+func (h *SQLiteHive) get(key string) (*HiveValue, error) {
+	q, err := h.q()
+	if err != nil {
+		return nil, err
+	}
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var t byte
+	var raw interface{}
+	err = q.QueryRow(`SELECT type, value FROM hive WHERE path = ?`, key).Scan(&t, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = sqlCheckParent(q, key, path)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errKeyNotFound(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t == HiveTypeSub {
+		sub, err := sqlReadSub(q, key)
+		if err != nil {
+			return nil, err
+		}
+		v, _ := NewHiveValue(sub)
+		return &v, nil
+	}
+	v, err := decodeSQLValue(t, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (h *SQLiteHive) GetBool(key string) (bool, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+func (h *SQLiteHive) GetInt(key string) (int, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int()
+}
+
+func (h *SQLiteHive) GetFloat(key string) (float64, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (h *SQLiteHive) GetString(key string) (*string, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.String()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (h *SQLiteHive) Set(key string, value interface{}) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.set(key, value)
+}
+
+// CompareAndSet Compares and sets the value in the pending transaction.
+func (h *SQLiteHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return compareAndSet(h.get, h.set, key, old, value)
+}
+
+// set Sets a value, with the lock held.
+func (h *SQLiteHive) set(key string, value interface{}) error {
+	val, err := NewHiveValue(value)
+	if err != nil {
+		return err
+	}
+	key, path, err := normalizeKey(key)
+	if err != nil {
+		return err
+	}
+	tx, err := h.begin()
+	if err != nil {
+		return err
+	}
+	err = sqlCheckParent(tx, key, path)
+	if err != nil {
+		return err
+	}
+	err = sqlDeleteTree(tx, key)
+	if err != nil {
+		return err
+	}
+	return sqlInsertTree(tx, key, val)
+}
+
+func (h *SQLiteHive) SetBool(key string, value bool) error {
+	return h.Set(key, value)
+}
+
+func (h *SQLiteHive) SetInt(key string, value int) error {
+	return h.Set(key, value)
+}
+
+func (h *SQLiteHive) SetFloat(key string, value float64) error {
+	return h.Set(key, value)
+}
+
+func (h *SQLiteHive) SetString(key string, value string) error {
+	return h.Set(key, value)
+}
+
+func (h *SQLiteHive) Delete(key string) {
+	key, _, err := normalizeKey(key)
+	if err != nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	tx, err := h.begin()
+	if err != nil {
+		return
+	}
+	sqlDeleteTree(tx, key)
+}
+
+func (h *SQLiteHive) NewSub(key string) {
+	h.Set(key, make(map[string]HiveValue))
+}
+
+// Rollback Rolls back the pending transaction.
+// Returns false if there were no pending changes.
+func (h *SQLiteHive) Rollback() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.tx == nil {
+		return false, nil
+	}
+	err := h.tx.Rollback()
+	h.tx = nil
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Commit Commits the pending transaction.
+// Returns false if there were no pending changes.
+func (h *SQLiteHive) Commit() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.tx == nil {
+		return false, nil
+	}
+	err := h.tx.Commit()
+	h.tx = nil
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Save Commits the pending transaction, if any.
+// Committed changes are already persisted by SQLite.
+func (h *SQLiteHive) Save() error {
+	_, err := h.Commit()
+	return err
+}
+
+// GetData Reads the whole hive.
+// Unlike MemHive, changes to the returned map are not reflected in the hive.
+func (h *SQLiteHive) GetData() *map[string]HiveValue {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	data := make(map[string]HiveValue)
+	q, err := h.q()
+	if err != nil {
+		return &data
+	}
+	sub, err := sqlReadSub(q, "")
+	if err == nil {
+		data = sub
+	}
+	return &data
+}
@@ -0,0 +1,197 @@
+package cfghive_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/melanblack/potential-framework/cfghive"
+)
+
+func openSQLiteHive(t *testing.T, path string) *cfghive.SQLiteHive {
+	h := cfghive.NewSQLiteHive(path)
+	err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.Close()
+	})
+	return h
+}
+
+func TestSQLiteHiveFitsInterface(t *testing.T) {
+	var _ cfghive.Hive = cfghive.NewSQLiteHive("")
+}
+
+func TestSQLiteHiveSetGet(t *testing.T) {
+	h := openSQLiteHive(t, ":memory:")
+	err := h.Set("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("foo/baz", "bar")
+	if err == nil {
+		t.Fatal("No error when setting a value in a non-existent sub-hive")
+	}
+	err = h.Set("nope/baz", "bar")
+	if err == nil {
+		t.Fatal("No error when setting a value in a non-existent sub-hive")
+	}
+	h.NewSub("fez")
+	err = h.Set("fez/baz", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("fez-sibling", uint64(1<<63))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Set("fez/nested", map[string]interface{}{"on": true, "ratio": float32(0.5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := h.GetString("fez/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s != "bar" {
+		t.Fatalf("unexpected value %s", *s)
+	}
+	u, err := h.Get("fez-sibling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := u.Uint64(); i != 1<<63 {
+		t.Fatalf("unexpected value %d", i)
+	}
+	v, err := h.Get("fez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := v.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := cfghive.HiveSize(sub); size != 3 {
+		t.Fatalf("fez has size %d, expected 3", size)
+	}
+	_, err = h.Get("fez/baz/zaz")
+	if err == nil {
+		t.Fatal("No error when getting a value below a leaf")
+	}
+	_, err = h.Get("")
+	if err == nil {
+		t.Fatal("No error with empty key")
+	}
+
+	err = h.Set("fez", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = h.Get("fez/nested/on")
+	if err == nil {
+		t.Fatal("children of a replaced sub-hive still exist")
+	}
+	h.Delete("fez")
+	if size := cfghive.HiveSize(*h.GetData()); size != 2 {
+		t.Fatalf("hive has size %d, expected 2", size)
+	}
+}
+
+func TestSQLiteHiveTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hive.db")
+	h := openSQLiteHive(t, path)
+	err := h.Set("kept", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := h.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("pending changes were not committed")
+	}
+	err = h.Set("dropped", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Delete("kept")
+	rolledBack, err := h.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rolledBack {
+		t.Fatal("pending changes were not rolled back")
+	}
+	if committed, _ := h.Commit(); committed {
+		t.Fatal("commit without pending changes")
+	}
+	h.Close()
+
+	h = openSQLiteHive(t, path)
+	if _, err := h.Get("kept"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get("dropped"); err == nil {
+		t.Fatal("rolled back value was persisted")
+	}
+}
+
+func TestSQLiteHiveConcurrent(t *testing.T) {
+	h := openSQLiteHive(t, ":memory:")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", i)
+			err := h.SetInt(key, i)
+			if err != nil {
+				t.Error(err)
+			}
+			if _, err := h.GetInt(key); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := h.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size := cfghive.HiveSize(*h.GetData()); size != 8 {
+		t.Fatalf("hive has size %d, expected 8", size)
+	}
+}
+
+func TestSQLiteHivePing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hive.db")
+	if cfghive.NewSQLiteHive(path).Ping() == nil {
+		t.Fatal("No error pinging a hive that is not loaded")
+	}
+	h := openSQLiteHive(t, path)
+	err := h.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With a pending change
+	err = h.SetInt("n", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfghive.PingHive(cfghive.NewSyncHive(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+	if h.Ping() == nil {
+		t.Fatal("No error pinging a closed hive")
+	}
+}
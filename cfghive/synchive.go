@@ -0,0 +1,148 @@
+package cfghive
+
+import (
+	"sync"
+)
+
+// SyncHive is a hive wrapper that serializes every call with a lock,
+// making any hive safe for concurrent use.
+type SyncHive struct {
+	lock sync.Mutex
+	hive Hive
+}
+
+// NewSyncHive Wraps hive so it can be used concurrently.
+func NewSyncHive(hive Hive) *SyncHive {
+	return &SyncHive{hive: hive}
+}
+
+// Do Calls fn with the wrapped hive while holding the lock,
+// so several operations are applied without interleaving other calls.
+func (h *SyncHive) Do(fn func(hive Hive) error) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return fn(h.hive)
+}
+
+func (h *SyncHive) Characteristics() HiveCharacteristics {
+	c := h.hive.Characteristics()
+	c.IsThreadSafe = true
+	return c
+}
+
+func (h *SyncHive) Load() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Load()
+}
+
+// Ping Pings the wrapped hive, see PingHive.
+func (h *SyncHive) Ping() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return PingHive(h.hive)
+}
+
+func (h *SyncHive) Get(key string) (*HiveValue, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Get(key)
+}
+
+func (h *SyncHive) GetBool(key string) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.GetBool(key)
+}
+
+func (h *SyncHive) GetInt(key string) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.GetInt(key)
+}
+
+func (h *SyncHive) GetFloat(key string) (float64, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.GetFloat(key)
+}
+
+func (h *SyncHive) GetString(key string) (*string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.GetString(key)
+}
+
+func (h *SyncHive) Set(key string, value interface{}) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Set(key, value)
+}
+
+func (h *SyncHive) SetBool(key string, value bool) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.SetBool(key, value)
+}
+
+func (h *SyncHive) SetInt(key string, value int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.SetInt(key, value)
+}
+
+func (h *SyncHive) SetFloat(key string, value float64) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.SetFloat(key, value)
+}
+
+func (h *SyncHive) SetString(key string, value string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.SetString(key, value)
+}
+
+func (h *SyncHive) CompareAndSet(key string, old *HiveValue, value interface{}) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.CompareAndSet(key, old, value)
+}
+
+func (h *SyncHive) Delete(key string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hive.Delete(key)
+}
+
+func (h *SyncHive) NewSub(key string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hive.NewSub(key)
+}
+
+func (h *SyncHive) Rollback() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Rollback()
+}
+
+func (h *SyncHive) Commit() (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Commit()
+}
+
+func (h *SyncHive) Save() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hive.Save()
+}
+
+// GetData Gets a copy of the hive data, as the wrapped hive's map may change once the lock is released.
+func (h *SyncHive) GetData() *map[string]HiveValue {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	data := copyHiveData(*h.hive.GetData())
+	return &data
+}